@@ -0,0 +1,52 @@
+package app
+
+import (
+	"net"
+
+	"github.com/charmbracelet/ssh"
+	"github.com/sirupsen/logrus"
+
+	"github.com/archimoebius/fishler/util"
+	"github.com/archimoebius/fishler/util/access"
+	"github.com/archimoebius/fishler/util/metrics"
+)
+
+var contextKeyAccess = &struct{ name string }{"access"}
+
+// admit checks conn against the access lists, remembering the decision in ctx, and reports whether it may proceed
+func (a *app) admit(ctx ssh.Context, conn net.Conn) bool {
+	if a.Access == nil {
+		return true
+	}
+
+	decision := a.Access.Check(conn.RemoteAddr())
+	if decision.Action == "" {
+		return true
+	}
+
+	metrics.AccessMatches.WithLabelValues(decision.List, string(decision.Action)).Inc()
+	ctx.SetValue(contextKeyAccess, decision)
+
+	if decision.Action == access.ActionDrop {
+		util.Logger.WithFields(logrus.Fields{
+			"address": conn.RemoteAddr().String(),
+			"list":    decision.List,
+		}).Debug("connection dropped by access list")
+
+		return false
+	}
+
+	return true
+}
+
+// accessDecision returns the access list decision made for the connection
+func accessDecision(ctx ssh.Context) access.Decision {
+	decision, _ := ctx.Value(contextKeyAccess).(access.Decision)
+
+	return decision
+}
+
+// neverAuthenticate reports whether the connection matched a list whose clients may never log in
+func neverAuthenticate(ctx ssh.Context) bool {
+	return accessDecision(ctx).Action == access.ActionNeverAuthenticate
+}
@@ -3,7 +3,6 @@ package app
 import (
 	"context"
 	"errors"
-	"fmt"
 	"io"
 	"log"
 	"math/rand"
@@ -11,6 +10,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -20,23 +20,32 @@ import (
 	configServe "github.com/archimoebius/fishler/cli/config/serve"
 	"github.com/archimoebius/fishler/shim"
 	"github.com/archimoebius/fishler/util"
+	"github.com/archimoebius/fishler/util/access"
+	"github.com/archimoebius/fishler/util/cidr"
+	"github.com/archimoebius/fishler/util/event"
+	"github.com/archimoebius/fishler/util/geoip"
+	"github.com/archimoebius/fishler/util/limit"
+	"github.com/archimoebius/fishler/util/metrics"
+	"github.com/archimoebius/fishler/util/pcap"
+	"github.com/archimoebius/fishler/util/proxy"
+	"github.com/archimoebius/fishler/util/quota"
+	"github.com/archimoebius/fishler/util/seed"
 	FishlerSFTP "github.com/archimoebius/fishler/util/sftp"
+	"github.com/archimoebius/fishler/util/tarpit"
+	"github.com/archimoebius/fishler/util/token"
+	"github.com/archimoebius/fishler/util/uplink"
+	"github.com/archimoebius/fishler/util/vault"
+	"github.com/archimoebius/fishler/util/webhook"
 	fishyfs "github.com/archimoebius/fishyfs/fs"
 	"github.com/charmbracelet/ssh"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
-	"github.com/google/uuid"
 	"github.com/pkg/sftp"
 	"github.com/sirupsen/logrus"
 	gossh "golang.org/x/crypto/ssh"
-
-	client "github.com/ArchiMoebius/uplink/client"
-	pb "github.com/ArchiMoebius/uplink/pkg/gen/v1"
 )
 
-var ServiceUUIDString = "00000000-0000-0000-0000-000000000000"
-var ServiceUUID = uuid.MustParse(ServiceUUIDString)
-
 // Application is the interface for the application
 type Application interface {
 	Start() error
@@ -44,18 +53,36 @@ type Application interface {
 
 // app is the implementation of the application
 type app struct {
-	BeamClient     *client.BeamClient
+	Uplink         *uplink.Queue
+	Webhooks       *webhook.Dispatcher
+	HASSHSeen      *hasshRegistry
+	Sensor         util.Sensor
+	GeoIP          *geoip.Enricher
+	Access         *access.Rules
+	Limiter        *limit.Limiter
+	LimitAction    limit.Action
+	Tarpit         tarpit.Tarpit
+	TarpitNetworks cidr.List
 	ServiceUUID    []byte
 	FishyFSMgr     *fishyfs.Manager
 	cleanupCtx     context.Context
 	cleanupCancel  context.CancelFunc
-	beamMutex      sync.Mutex
+	sessions       sync.WaitGroup
+	hardening      *hardening
+	egress         egressSessions
+	egressProxy    string
+	egressCA       string
+	pcap           *pcap.Recorder
+	vault          *vault.Vault
+	seed           *seed.Seed
+	tokens         *token.Registry
+	seeded         seededHomes
+	quotas         sync.Map
+	spool          *quota.Tracker
 	HASSHBlockList map[string]string
 }
 
 func NewApplication() Application {
-	b, _ := ServiceUUID.MarshalBinary()
-
 	fishyfsBaseDir := filepath.Join(rootConfig.Setting.LogBasepath, "fishyfs")
 	mgr := fishyfs.NewManager(fishyfsBaseDir)
 
@@ -64,8 +91,8 @@ func NewApplication() Application {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	app := &app{
-		BeamClient:     nil,
-		ServiceUUID:    b,
+		Uplink:         nil,
+		ServiceUUID:    nil,
 		FishyFSMgr:     mgr,
 		cleanupCtx:     ctx,
 		cleanupCancel:  cancel,
@@ -74,90 +101,124 @@ func NewApplication() Application {
 
 	// EXAMPLE: app.HASSHBlockList["1b8acd46a07d2dc9854db9ec4044c45c"] = "" // SSH-2.0-russh_0.51.1
 
+	metrics.TrackFishyFSMounts(func() int {
+		return len(mgr.GetActiveMounts())
+	})
+
 	go mgr.CleanupIdleMounts(ctx)
 
 	return app
 }
 
-func (a *app) BeamEvent(
-	sessionID string,
-	sourceAddr net.Addr,
-	authMethod pb.AuthMethod,
-	username,
-	password,
-	sshClientName string,
-	hassh string,
-) {
-	if a.BeamClient == nil {
-		return
+func (a *app) Start() error {
+	sensor, err := util.GetSensor()
+	if err != nil {
+		return err
 	}
 
-	event := &pb.SSHConnectionEvent{
-		TimestampMicros: time.Now().UnixMicro(),
-		ServiceUuid:     a.ServiceUUID,
-		SessionUuid:     []byte(sessionID),
-		AuthMethods: []pb.AuthMethod{
-			authMethod,
-		},
-		Username:      []byte(username),
-		Password:      []byte(password),
-		SshClientName: sshClientName,
-		Hassh:         []byte(hassh),
+	a.Sensor = sensor
+	a.ServiceUUID, _ = sensor.UUID.MarshalBinary()
+
+	util.SetLogFields(sensor.Fields())
+
+	if len(rootConfig.Setting.UplinkServerAddress) > 0 {
+		queue, err := uplink.NewQueue(
+			rootConfig.Setting.UplinkServerAddress,
+			filepath.Join(rootConfig.Setting.LogBasepath, "uplink", "journal"),
+			rootConfig.Setting.UplinkQueueSize,
+		)
+
+		if err != nil {
+			return err
+		}
+		a.Uplink = queue
+
+		defer func() {
+			if err := a.Uplink.Close(); err != nil {
+				util.Logger.WithError(err).Error("failed to close uplink queue")
+			}
+		}()
+
+		util.Logger.WithFields(logrus.Fields{
+			"server":       rootConfig.Setting.UplinkServerAddress,
+			"service_uuid": a.Sensor.UUID.String(),
+		}).Info("uplink queue started")
 	}
 
-	if err := util.ParseNetAddr(sourceAddr, event); err != nil {
-		log.Fatalf("Failed to parse source address: (%s) %v", sourceAddr.String(), err)
+	if len(configServe.Setting.WebhookFilepath) > 0 {
+		dispatcher, err := webhook.Load(configServe.Setting.WebhookFilepath)
+
+		if err != nil {
+			return err
+		}
+		a.Webhooks = dispatcher
+		defer a.Webhooks.Close()
 	}
 
-	util.Logger.WithFields(logrus.Fields{
-		"TimestampMicros": event.TimestampMicros,
-		"ServiceUuid":     event.ServiceUuid,
-		"Username":        event.Username,
-		"Password":        event.Password,
-		"SshClientName":   event.SshClientName,
-		"Hassh":           event.Hassh,
-		"SourceIp":        event.SourceIp,
-		"SourcePort":      event.SourcePort,
-	}).Debug("beaming event")
-
-	a.beamMutex.Lock()
-	err := a.BeamClient.SendEvent(event)
-	if err != nil {
-		log.Printf("event send failure - reconnecting: %v", err)
+	if len(configServe.Setting.GeoIPCountryFilepath) > 0 || len(configServe.Setting.GeoIPCityFilepath) > 0 || len(configServe.Setting.GeoIPASNFilepath) > 0 {
+		enricher, err := geoip.Open(
+			configServe.Setting.GeoIPCountryFilepath,
+			configServe.Setting.GeoIPCityFilepath,
+			configServe.Setting.GeoIPASNFilepath,
+		)
+
+		if err != nil {
+			return err
+		}
+		a.GeoIP = enricher
+		defer a.GeoIP.Close()
+	}
 
-		if err := a.BeamClient.Reconnect(); err != nil {
-			a.beamMutex.Unlock()
-			log.Printf("Failed to reconnect to uplink server: %v", err)
-			return
+	if len(configServe.Setting.AccessFilepath) > 0 || len(configServe.Setting.DenyCIDRs) > 0 {
+		a.Access, err = access.Load(configServe.Setting.AccessFilepath, configServe.Setting.DenyCIDRs)
+		if err != nil {
+			return err
 		}
+	}
 
-		err = a.BeamClient.SendEvent(event)
+	a.hardening, err = newHardening()
+	if err != nil {
+		return err
 	}
-	a.beamMutex.Unlock()
 
+	a.LimitAction, err = limit.ParseAction(configServe.Setting.LimitAction)
 	if err != nil {
-		log.Printf("Failed to send event: %v", err)
+		return err
 	}
-}
 
-func (a *app) Start() error {
+	a.Limiter = limit.New(
+		configServe.Setting.LimitConnectionsPerMinute,
+		configServe.Setting.LimitSessionsPerIP,
+		configServe.Setting.LimitContainers,
+	)
 
-	if len(rootConfig.Setting.UplinkServerAddress) > 0 {
-		beamClient, err := client.NewBeamClient(rootConfig.Setting.UplinkServerAddress)
+	a.Tarpit = tarpit.Tarpit{
+		Interval:    time.Duration(configServe.Setting.TarpitInterval) * time.Second,
+		MaxDuration: time.Duration(configServe.Setting.TarpitSeconds) * time.Second,
+	}
 
-		if err != nil {
+	a.TarpitNetworks, err = cidr.Parse(configServe.Setting.TarpitCIDRs)
+	if err != nil {
+		return err
+	}
+
+	hasshSeen, err := newHASSHRegistry(filepath.Join(rootConfig.Setting.LogBasepath, "hassh", "seen"))
+	if err != nil {
+		return err
+	}
+	a.HASSHSeen = hasshSeen
+	defer a.HASSHSeen.Close()
+
+	if len(configServe.Setting.MetricsAddress) > 0 {
+		go func() {
 			util.Logger.WithFields(logrus.Fields{
-				"error": err,
-			}).Fatal("failed to create beam client")
-		}
-		a.BeamClient = beamClient
-		defer a.BeamClient.Close()
+				"address": configServe.Setting.MetricsAddress,
+			}).Info("serving metrics")
 
-		util.Logger.WithFields(logrus.Fields{
-			"server":       rootConfig.Setting.UplinkServerAddress,
-			"state":        beamClient.GetState(),
-			"service_uuid": ServiceUUIDString,
-		}).Info("connected to uplink server")
+			if err := metrics.Serve(configServe.Setting.MetricsAddress); err != nil {
+				util.Logger.WithError(err).Error("metrics server failed")
+			}
+		}()
 	}
 
 	defer func() {
@@ -170,20 +231,72 @@ func (a *app) Start() error {
 		}
 	}()
 
+	forwardHandler := &ssh.ForwardedTCPHandler{}
+
+	// registered so forwarding requests reach the forwarding callbacks - which refuse them
+	channelHandlers := map[string]ssh.ChannelHandler{
+		"session":      ssh.DefaultSessionHandler,
+		"direct-tcpip": ssh.DirectTCPIPHandler,
+	}
+	requestHandlers := map[string]ssh.RequestHandler{
+		"tcpip-forward":        forwardHandler.HandleSSHRequest,
+		"cancel-tcpip-forward": forwardHandler.HandleSSHRequest,
+	}
+
+	// with emulation the callbacks accept, so the handlers that dial out and bind listeners must be replaced
+	if configServe.Setting.ForwardEmulation {
+		channelHandlers["direct-tcpip"] = a.emulatedDirectTCPIP
+		requestHandlers["tcpip-forward"] = a.emulatedTCPIPForward
+		requestHandlers["cancel-tcpip-forward"] = a.emulatedTCPIPForward
+	}
+
 	s := &ssh.Server{
 		ConnCallback: func(ctx ssh.Context, conn net.Conn) net.Conn {
-			return &shim.HASSHConnectionWrapper{
+			metrics.ConnectionsAccepted.Inc()
+
+			if pc, ok := conn.(*profileConn); ok {
+				ctx.SetValue(contextKeyListener, pc.profile)
+			}
+
+			if !a.admit(ctx, conn) {
+				return nil
+			}
+
+			if !a.Limiter.AllowConnection(cidr.IP(conn.RemoteAddr()).String()) && a.overLimit(conn.RemoteAddr(), "connections-per-minute", conn) {
+				return nil
+			}
+
+			if a.TarpitNetworks.Contains(conn.RemoteAddr()) {
+				a.tarpit(conn, "cidr", tarpitBanner)
+				return nil
+			}
+
+			wrapper := &shim.HASSHConnectionWrapper{
 				Conn: conn,
 				OnCapture: func(info *shim.HASSHInfo) bool {
 					util.Logger.WithFields(logrus.Fields{
 						"client": info.RemoteAddr,
 						"SSH ID": info.ClientID,
 						"HASSH":  info.Hash,
-					}).Info("HASSH Event")
+					}).WithFields(a.geoFields(info.RemoteAddr)).Info("HASSH Event")
 
 					ctx.SetValue(shim.ContextKeyHASSHInfo, info)
-
-					if _, ok := a.HASSHBlockList[info.Hash]; ok {
+					metrics.HASSHCaptured.Inc()
+
+					_, blocked := a.HASSHBlockList[info.Hash]
+
+					// many probes never reach authentication - this is the only trace of them
+					e := newEvent(ctx, event.KindHASSH)
+					e.RemoteAddr = info.RemoteAddr
+					e.ClientVersion = info.ClientID
+					e.HASSH = info.Hash
+					e.Fields["algorithms"] = info.Algorithms
+					e.Fields["blocked"] = strconv.FormatBool(blocked)
+					e.Fields["first_seen"] = strconv.FormatBool(a.HASSHSeen.Observe(info.Hash))
+					a.Publish(e)
+
+					if blocked {
+						metrics.HASSHBlocked.Inc()
 						return true
 					}
 
@@ -191,11 +304,61 @@ func (a *app) Start() error {
 				},
 				Buffer: make([]byte, 0, 8192),
 			}
+
+			if configServe.Setting.TarpitHASSH {
+				wrapper.OnBlock = func(conn net.Conn) {
+					a.tarpit(conn, "hassh", tarpitKEX)
+				}
+			}
+
+			return wrapper
+		},
+		ServerConfigCallback: func(ctx ssh.Context) *gossh.ServerConfig {
+			config := &gossh.ServerConfig{
+				ServerVersion: "SSH-2.0-" + listenerProfile(ctx).Banner,
+			}
+
+			// rejecting lets the transport drop the connection - otherwise authAttemptAllowed decides
+			if configServe.Setting.LimitAuthAttempts > 0 {
+				if a.LimitAction == limit.ActionReject {
+					config.MaxAuthTries = configServe.Setting.LimitAuthAttempts
+				} else {
+					config.MaxAuthTries = -1
+				}
+			}
+
+			return config
 		},
-		Version: configServe.Setting.Banner,
-		Addr:    fmt.Sprintf("%s:%d", configServe.Setting.IP, configServe.Setting.Port),
 		SubsystemHandlers: map[string]ssh.SubsystemHandler{
 			"sftp": func(sess ssh.Session) {
+				a.sessions.Add(1)
+				defer a.sessions.Done()
+
+				if !a.Limiter.AcquireSession(cidr.IP(sess.RemoteAddr()).String()) {
+					if a.overLimit(sess.RemoteAddr(), "sessions-per-ip", nil) {
+						_ = sess.Exit(1)
+						return
+					}
+				} else {
+					defer a.Limiter.ReleaseSession(cidr.IP(sess.RemoteAddr()).String())
+				}
+
+				metrics.SessionsActive.Inc()
+				defer metrics.SessionsActive.Dec()
+
+				start := newEvent(sess.Context(), event.KindSessionStart)
+				start.Fields["subsystem"] = sess.Subsystem()
+				a.Publish(start)
+
+				startedAt := time.Now()
+
+				defer func() {
+					end := newEvent(sess.Context(), event.KindSessionEnd)
+					end.Fields["subsystem"] = sess.Subsystem()
+					end.Fields["duration"] = time.Since(startedAt).String()
+					a.Publish(end)
+				}()
+
 				hostVolumnWorkingDir, err := a.FishyFSMgr.GetMountPoint(sess.Context().User())
 				if err != nil {
 					util.Logger.WithError(err).Error("failed to get mount point")
@@ -203,58 +366,51 @@ func (a *app) Start() error {
 					return
 				}
 
-				var dockerVolumnWorkingDir = fmt.Sprintf("/home/%s", sess.User())
-
-				if sess.User() == "root" {
-					dockerVolumnWorkingDir = "/root/"
-				}
-
 				options := []sftp.RequestServerOption{
-					sftp.WithStartDirectory(dockerVolumnWorkingDir),
+					sftp.WithStartDirectory(homeDirectory(sess.User())),
 				}
 
-				p := FishlerSFTP.FishlerFS{
-					HasDiskSpace: func(fs FishlerSFTP.FishlerFS) bool {
-						var dirSize int64 = 0
-
-						readSize := func(path string, file os.FileInfo, err error) error {
-							if !file.IsDir() {
-								dirSize += file.Size()
-							}
+				rootSeed, releaseSeed := a.seedSession(sess, hostVolumnWorkingDir)
+				defer releaseSeed()
 
-							return nil
-						}
-
-						_ = filepath.Walk(hostVolumnWorkingDir, readSize)
-
-						sizeMB := dirSize / 1024 / 1024
+				p := a.fishlerFS(sess, hostVolumnWorkingDir)
 
-						return sizeMB <= configServe.Setting.DockerDiskLimit
-					},
-					GetDockerVolumnPath: func(fs FishlerSFTP.FishlerFS, p string) (string, error) {
-						var replace = filepath.Clean(hostVolumnWorkingDir)
-
-						p = filepath.Clean(strings.ReplaceAll(filepath.Clean(p), dockerVolumnWorkingDir, replace))
+				handlers := sftp.Handlers{
+					FileGet:  p,
+					FilePut:  p,
+					FileCmd:  p,
+					FileList: p,
+				}
 
-						if !strings.HasPrefix(p, replace) {
-							return "", errors.New("bad path")
+				if configServe.Setting.SFTPContainer {
+					if !a.Limiter.AcquireContainer() {
+						if a.overLimit(sess.RemoteAddr(), "containers", nil) {
+							_ = sess.Exit(1)
+							return
 						}
+					} else {
+						defer a.Limiter.ReleaseContainer()
+					}
 
-						return p, nil
-					},
-					Lock:     &sync.Mutex{},
-					User:     sess.User(),
-					RemoteIP: sess.RemoteAddr().String(),
+					c, closeContainer, err := a.containerFS(sess, p, hostVolumnWorkingDir, rootSeed)
+					if err != nil {
+						util.Logger.WithError(err).Error("failed to start sftp container")
+						_ = sess.Exit(1)
+						return
+					}
+					defer closeContainer()
+
+					handlers = sftp.Handlers{
+						FileGet:  c,
+						FilePut:  c,
+						FileCmd:  c,
+						FileList: c,
+					}
 				}
 
 				requestServer := sftp.NewRequestServer(
 					sess,
-					sftp.Handlers{
-						FileGet:  p,
-						FilePut:  p,
-						FileCmd:  p,
-						FileList: p,
-					},
+					handlers,
 					options...,
 				)
 
@@ -276,6 +432,10 @@ func (a *app) Start() error {
 			},
 		},
 		PasswordHandler: func(ctx ssh.Context, password string) bool {
+			if !a.authAttemptAllowed(ctx) {
+				return false
+			}
+
 			if configServe.Setting.RandomConnectionSleepCount > 0 {
 				min := 1.0
 				max := float64(configServe.Setting.RandomConnectionSleepCount)
@@ -283,22 +443,19 @@ func (a *app) Start() error {
 				time.Sleep(time.Duration((min + rand.Float64()*(max-min)) * float64(time.Second))) // #nosec
 			}
 
-			authenticated := configServe.Setting.Authenticate(ctx.User(), password)
+			authenticated := configServe.Setting.Authenticate(ctx.User(), password) && !neverAuthenticate(ctx)
+			metrics.AuthAttempts.WithLabelValues("password", metrics.Outcome(authenticated)).Inc()
 
 			info := ctx.Value(shim.ContextKeyHASSHInfo).(*shim.HASSHInfo)
 			if info == nil {
 				return false
 			}
 
-			a.BeamEvent(
-				ctx.SessionID(),
-				ctx.RemoteAddr(),
-				pb.AuthMethod_AUTH_METHOD_PASSWORD,
-				ctx.User(),
-				password,
-				ctx.ClientVersion(),
-				info.Hash,
-			)
+			e := newEvent(ctx, event.KindAuth)
+			e.AuthMethod = event.AuthMethodPassword
+			e.Password = password
+			e.Fields["success"] = strconv.FormatBool(authenticated)
+			a.Publish(e)
 
 			util.Logger.WithFields(logrus.Fields{
 				"address":        ctx.RemoteAddr().String(),
@@ -307,11 +464,19 @@ func (a *app) Start() error {
 				"client_version": ctx.ClientVersion(),
 				"session_id":     ctx.SessionID(),
 				"success":        authenticated,
-			}).Info("password authentication event")
+			}).WithFields(a.geoFields(ctx.RemoteAddr())).Info("password authentication event")
+
+			if authenticated {
+				ctx.SetValue(util.ContextKeyPassword, password)
+			}
 
 			return authenticated
 		},
 		PublicKeyHandler: func(ctx ssh.Context, key ssh.PublicKey) bool {
+			if !a.authAttemptAllowed(ctx) {
+				return false
+			}
+
 			if configServe.Setting.RandomConnectionSleepCount > 0 {
 				min := 1.0
 				max := float64(configServe.Setting.RandomConnectionSleepCount)
@@ -325,26 +490,27 @@ func (a *app) Start() error {
 				"key":            key.Marshal(),
 				"client_version": ctx.ClientVersion(),
 				"session_id":     ctx.SessionID(),
-			}).Info("public-key authentication event")
+			}).WithFields(a.geoFields(ctx.RemoteAddr())).Info("public-key authentication event")
+			metrics.AuthAttempts.WithLabelValues("publickey", metrics.OutcomeFailure).Inc()
 
 			info := ctx.Value(shim.ContextKeyHASSHInfo).(*shim.HASSHInfo)
 			if info == nil {
 				return false
 			}
 
-			a.BeamEvent(
-				ctx.SessionID(),
-				ctx.RemoteAddr(),
-				pb.AuthMethod_AUTH_METHOD_PUBLICKEY,
-				ctx.User(),
-				string(key.Marshal()),
-				ctx.ClientVersion(),
-				info.Hash,
-			)
+			e := newEvent(ctx, event.KindAuth)
+			e.AuthMethod = event.AuthMethodPublicKey
+			e.Password = string(key.Marshal())
+			e.Fields["success"] = strconv.FormatBool(false)
+			a.Publish(e)
 
 			return false
 		},
 		KeyboardInteractiveHandler: func(ctx ssh.Context, challenger gossh.KeyboardInteractiveChallenge) bool {
+			if !a.authAttemptAllowed(ctx) {
+				return false
+			}
+
 			if configServe.Setting.RandomConnectionSleepCount > 0 {
 				min := 1.0
 				max := float64(configServe.Setting.RandomConnectionSleepCount)
@@ -360,6 +526,7 @@ func (a *app) Start() error {
 			answers, err := challenger("", "", questions, echos)
 
 			if err != nil || len(answers) == 0 {
+				metrics.AuthAttempts.WithLabelValues("keyboard-interactive", metrics.OutcomeFailure).Inc()
 				util.Logger.WithFields(logrus.Fields{
 					"address":        ctx.RemoteAddr().String(),
 					"username":       ctx.User(),
@@ -367,28 +534,25 @@ func (a *app) Start() error {
 					"client_version": ctx.ClientVersion(),
 					"session_id":     ctx.SessionID(),
 					"success":        authenticated,
-				}).Info("keyboard-interactive authentication event")
+				}).WithFields(a.geoFields(ctx.RemoteAddr())).Info("keyboard-interactive authentication event")
 
 				return false
 			}
 			password = answers[0]
 
-			authenticated = configServe.Setting.Authenticate(ctx.User(), password)
+			authenticated = configServe.Setting.Authenticate(ctx.User(), password) && !neverAuthenticate(ctx)
+			metrics.AuthAttempts.WithLabelValues("keyboard-interactive", metrics.Outcome(authenticated)).Inc()
 
 			info := ctx.Value(shim.ContextKeyHASSHInfo).(*shim.HASSHInfo)
 			if info == nil {
 				return false
 			}
 
-			a.BeamEvent(
-				ctx.SessionID(),
-				ctx.RemoteAddr(),
-				pb.AuthMethod_AUTH_METHOD_KEYBOARD_INTERACTIVE,
-				ctx.User(),
-				password,
-				ctx.ClientVersion(),
-				info.Hash,
-			)
+			e := newEvent(ctx, event.KindAuth)
+			e.AuthMethod = event.AuthMethodKeyboardInteractive
+			e.Password = password
+			e.Fields["success"] = strconv.FormatBool(authenticated)
+			a.Publish(e)
 
 			util.Logger.WithFields(logrus.Fields{
 				"address":        ctx.RemoteAddr().String(),
@@ -397,11 +561,30 @@ func (a *app) Start() error {
 				"client_version": ctx.ClientVersion(),
 				"session_id":     ctx.SessionID(),
 				"success":        authenticated,
-			}).Info("keyboard-interactive authentication event")
+			}).WithFields(a.geoFields(ctx.RemoteAddr())).Info("keyboard-interactive authentication event")
+
+			if authenticated {
+				ctx.SetValue(util.ContextKeyPassword, password)
+			}
 
 			return authenticated
 		},
 		Handler: func(sess ssh.Session) {
+			a.sessions.Add(1)
+			defer a.sessions.Done()
+
+			if !a.Limiter.AcquireSession(cidr.IP(sess.RemoteAddr()).String()) {
+				if a.overLimit(sess.RemoteAddr(), "sessions-per-ip", nil) {
+					_ = sess.Exit(1)
+					return
+				}
+			} else {
+				defer a.Limiter.ReleaseSession(cidr.IP(sess.RemoteAddr()).String())
+			}
+
+			metrics.SessionsActive.Inc()
+			defer metrics.SessionsActive.Dec()
+
 			_, _, isTty := sess.Pty()
 
 			util.Logger.WithFields(logrus.Fields{
@@ -413,7 +596,21 @@ func (a *app) Start() error {
 				"pty":         isTty,
 				"publickey":   sess.PublicKey(),
 				"subsystem":   sess.Subsystem(),
-			}).Info("session event")
+			}).WithFields(a.geoFields(sess.RemoteAddr())).Info("session event")
+
+			start := newEvent(sess.Context(), event.KindSessionStart)
+			start.Fields["pty"] = strconv.FormatBool(isTty)
+			start.Fields["command"] = sess.RawCommand()
+			start.Fields["environment"] = strings.Join(sess.Environ(), " ")
+			a.Publish(start)
+
+			if sess.RawCommand() != "" {
+				exec := newEvent(sess.Context(), event.KindExec)
+				exec.Fields["command"] = sess.RawCommand()
+				a.Publish(exec)
+			}
+
+			startedAt := time.Now()
 
 			mountPoint, err := a.FishyFSMgr.GetMountPoint(sess.Context().User())
 
@@ -423,58 +620,89 @@ func (a *app) Start() error {
 				return
 			}
 
-			var workingDir = fmt.Sprintf("/home/%s", sess.User())
+			rootSeed, releaseSeed := a.seedSession(sess, mountPoint)
+			defer releaseSeed()
 
-			var appendRoot = true
-			if sess.User() == "root" {
-				workingDir = "/root"
-				appendRoot = false
-			}
+			// scp is answered natively rather than typed into the container's shell
+			if FishlerSFTP.IsSCP(sess.Command()) {
+				status := 0
 
-			createCfg := &container.Config{
-				Image:        rootConfig.Setting.DockerImagename,
-				Hostname:     configServe.Setting.DockerHostname,
-				User:         sess.User(),
-				Cmd:          nil,
-				Env:          sess.Environ(),
-				Tty:          true,
-				OpenStdin:    true,
-				AttachStderr: true,
-				AttachStdin:  true,
-				AttachStdout: true,
-				StdinOnce:    false,
-				WorkingDir:   workingDir,
-				Labels:       map[string]string{"fishler": "fishler"},
-			}
-			hostCfg := &container.HostConfig{
-				AutoRemove:    true,
-				NetworkMode:   "none",
-				DNS:           []string{},
-				DNSSearch:     []string{},
-				Privileged:    false,
-				ShmSize:       1024,
-				ConsoleSize:   [2]uint{1024, 768},
-				ReadonlyPaths: []string{"/bin", "/dev", "/lib", "/media", "/mnt", "/opt", "/run", "/sbin", "/srv", "/sys", "/usr", "/var"},
-				Resources: container.Resources{
-					Memory: 1024 * 1024 * int64(configServe.Setting.DockerMemoryLimit),
-				},
+				if err := a.fishlerFS(sess, mountPoint).SCP(sess, sess.Command()); err != nil {
+					util.Logger.WithFields(logrus.Fields{
+						"address":  sess.RemoteAddr().String(),
+						"username": sess.User(),
+						"error":    err,
+					}).Error("scp error event")
+
+					status = 1
+				}
+
+				end := newEvent(sess.Context(), event.KindSessionEnd)
+				end.Fields["exit_code"] = strconv.Itoa(status)
+				end.Fields["duration"] = time.Since(startedAt).String()
+				a.Publish(end)
+
+				_ = sess.Exit(status)
+				return
 			}
 
-			if appendRoot {
-				hostCfg.ReadonlyPaths = append(hostCfg.ReadonlyPaths, "/root")
+			createCfg, hostCfg := a.containerConfig(sess)
+
+			var started func(ip string)
+
+			if a.egressProxy != "" {
+				hostCfg.NetworkMode = container.NetworkMode(configServe.Setting.EgressNetwork)
+
+				for _, name := range []string{"http_proxy", "HTTP_PROXY", "ftp_proxy", "FTP_PROXY", "https_proxy", "HTTPS_PROXY"} {
+					createCfg.Env = append(createCfg.Env, name+"="+a.egressProxy)
+				}
+
+				if a.egressCA != "" {
+					// the proxy's CA is all the container trusts as the proxy is all it can reach
+					hostCfg.Mounts = append(hostCfg.Mounts, mount.Mount{
+						ReadOnly: true,
+						Type:     mount.TypeBind,
+						Source:   a.egressCA,
+						Target:   egressCAPath,
+					})
+
+					for _, name := range []string{"SSL_CERT_FILE", "CURL_CA_BUNDLE", "REQUESTS_CA_BUNDLE", "GIT_SSL_CAINFO", "NODE_EXTRA_CA_CERTS"} {
+						createCfg.Env = append(createCfg.Env, name+"="+egressCAPath)
+					}
+				}
+
+				a.egress.sessions.Store(sess.Context().SessionID(), sess.Context())
+				defer a.egress.sessions.Delete(sess.Context().SessionID())
+
+				if a.pcap != nil {
+					started = func(ip string) {
+						a.pcap.Begin(sess.Context().SessionID(), ip)
+					}
+					defer a.pcap.End(sess.Context().SessionID())
+				}
 			}
 
-			if len(configServe.Setting.Volumns) > 0 {
-				hostCfg.Binds = configServe.Setting.Volumns
+			if !a.Limiter.AcquireContainer() {
+				if a.overLimit(sess.RemoteAddr(), "containers", nil) {
+					_ = sess.Exit(1)
+					return
+				}
+			} else {
+				defer a.Limiter.ReleaseContainer()
 			}
 
 			networkCfg := &network.NetworkingConfig{}
-			status, err := util.CreateRunWaitSSHContainer(mountPoint, createCfg, hostCfg, networkCfg, sess)
+			status, err := util.CreateRunWaitSSHContainer(a.cleanupCtx, mountPoint, createCfg, hostCfg, networkCfg, rootSeed, sess, started)
 
 			if err != nil {
 				util.Logger.Error(err)
 			}
 
+			end := newEvent(sess.Context(), event.KindSessionEnd)
+			end.Fields["exit_code"] = strconv.FormatInt(status, 10)
+			end.Fields["duration"] = time.Since(startedAt).String()
+			a.Publish(end)
+
 			err = sess.Exit(int(status))
 
 			if err != nil {
@@ -490,7 +718,15 @@ func (a *app) Start() error {
 				"host":           destinationHost,
 				"port":           destinationPort,
 			}).Info("ssh local port forward request")
-			return false
+
+			e := newEvent(ctx, event.KindPortForward)
+			e.Fields["direction"] = "local"
+			e.Fields["host"] = destinationHost
+			e.Fields["port"] = strconv.FormatUint(uint64(destinationPort), 10)
+			e.Fields["emulated"] = strconv.FormatBool(configServe.Setting.ForwardEmulation)
+			a.Publish(e)
+
+			return configServe.Setting.ForwardEmulation
 		},
 		ReversePortForwardingCallback: func(ctx ssh.Context, bindHost string, bindPort uint32) bool {
 			util.Logger.WithFields(logrus.Fields{
@@ -501,8 +737,18 @@ func (a *app) Start() error {
 				"host":           bindHost,
 				"port":           bindPort,
 			}).Info("ssh reverse port forward request")
-			return false
+
+			e := newEvent(ctx, event.KindPortForward)
+			e.Fields["direction"] = "reverse"
+			e.Fields["host"] = bindHost
+			e.Fields["port"] = strconv.FormatUint(uint64(bindPort), 10)
+			e.Fields["emulated"] = strconv.FormatBool(configServe.Setting.ForwardEmulation)
+			a.Publish(e)
+
+			return configServe.Setting.ForwardEmulation
 		},
+		ChannelHandlers: channelHandlers,
+		RequestHandlers: requestHandlers,
 		ConnectionFailedCallback: func(conn net.Conn, err error) {
 			util.Logger.WithFields(logrus.Fields{
 				"address": conn.RemoteAddr().String(),
@@ -518,30 +764,117 @@ func (a *app) Start() error {
 
 	s.AddHostKey(signer)
 
-	addr := s.Addr
-	if addr == "" {
-		addr = ":2222"
+	a.vault, err = vault.Open(filepath.Join(rootConfig.Setting.LogBasepath, "vault"))
+	if err != nil {
+		return err
+	}
+
+	if configServe.Setting.SFTPContainer {
+		// spool files are only ever in flight - anything left behind is from a previous run
+		spoolDirectory := filepath.Join(rootConfig.Setting.LogBasepath, "spool")
+
+		if err := os.RemoveAll(spoolDirectory); err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(spoolDirectory, 0700); err != nil {
+			return err
+		}
+
+		a.spool = quota.New(spoolDirectory, configServe.Setting.SFTPSpoolLimit*1024*1024)
+	}
+
+	if len(configServe.Setting.Seed) > 0 {
+		a.seed, err = seed.Load(configServe.Setting.Seed)
+		if err != nil {
+			return err
+		}
+		a.seed.Domain = configServe.Setting.TokenDomain
+
+		a.tokens, err = token.Open(filepath.Join(rootConfig.Setting.LogBasepath, "token"))
+		if err != nil {
+			return err
+		}
+	}
+
+	if configServe.Setting.PCAP && !configServe.Setting.Egress {
+		return errors.New("pcap requires egress - without it containers have no network")
+	}
+
+	if configServe.Setting.Egress {
+		a.egressProxy, err = a.startEgress()
+		if err != nil {
+			return err
+		}
 	}
-	ln, err := net.Listen("tcp4", addr)
 
+	if removed, err := util.RemoveOrphanedContainers(a.cleanupCtx, a.Sensor.UUID); err != nil {
+		util.Logger.WithError(err).Error("failed to sweep orphaned containers")
+	} else if removed > 0 {
+		util.Logger.Infof("Removed %d orphaned containers", removed)
+	}
+
+	listeners, err := configServe.Setting.Listeners()
 	if err != nil {
 		return err
 	}
 
-	util.Logger.Infof("Listening on %s", ln.Addr().String())
+	var trusted cidr.List
 
-	go func() {
-		_ = s.Serve(ln)
-	}()
+	if configServe.Setting.ProxyProtocol {
+		if len(configServe.Setting.ProxyTrustedCIDRs) == 0 {
+			return errors.New("proxy-protocol requires at least one proxy-trusted-cidr")
+		}
+
+		trusted, err = cidr.Parse(configServe.Setting.ProxyTrustedCIDRs)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, profile := range listeners {
+		ln, err := net.Listen(profile.Network(), profile.Address)
+
+		if err != nil {
+			return err
+		}
+
+		if configServe.Setting.ProxyProtocol {
+			ln = proxy.Listen(ln, trusted)
+		}
+
+		util.Logger.WithFields(logrus.Fields{
+			"banner":   profile.Banner,
+			"hostname": profile.Hostname,
+		}).Infof("Listening on %s", ln.Addr().String())
+
+		go func() {
+			_ = s.Serve(&profileListener{Listener: ln, profile: profile})
+		}()
+	}
 
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	log.Println("Press Ctrl+C to stop.")
 
 	sig := <-sigCh
 
+	for sig == syscall.SIGHUP {
+		if a.Access != nil {
+			if err := a.Access.Reload(); err != nil {
+				util.Logger.WithError(err).Error("failed to reload access lists")
+			} else {
+				util.Logger.Info("reloaded access lists")
+			}
+		}
+
+		sig = <-sigCh
+	}
+
 	log.Printf("Received signal %s, shutting down...", sig)
 
+	a.shutdown(s)
+
 	return nil
 }
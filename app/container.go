@@ -0,0 +1,63 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/ssh"
+	"github.com/docker/docker/api/types/container"
+
+	rootConfig "github.com/archimoebius/fishler/cli/config/root"
+	configServe "github.com/archimoebius/fishler/cli/config/serve"
+	"github.com/archimoebius/fishler/util"
+)
+
+// containerConfig is the configuration of a container started for the session
+func (a *app) containerConfig(sess ssh.Session) (*container.Config, *container.HostConfig) {
+	var workingDir = fmt.Sprintf("/home/%s", sess.User())
+
+	var appendRoot = true
+	if sess.User() == "root" {
+		workingDir = "/root"
+		appendRoot = false
+	}
+
+	createCfg := &container.Config{
+		Image:        rootConfig.Setting.DockerImagename,
+		Hostname:     listenerProfile(sess.Context()).Hostname,
+		User:         sess.User(),
+		Cmd:          nil,
+		Env:          sess.Environ(),
+		Tty:          true,
+		OpenStdin:    true,
+		AttachStderr: true,
+		AttachStdin:  true,
+		AttachStdout: true,
+		StdinOnce:    false,
+		WorkingDir:   workingDir,
+		Labels:       map[string]string{util.ContainerLabel: a.Sensor.UUID.String()},
+	}
+	hostCfg := &container.HostConfig{
+		AutoRemove:  true,
+		NetworkMode: "none",
+		DNS:         []string{},
+		DNSSearch:   []string{},
+		Privileged:  false,
+		ShmSize:     1024,
+		ConsoleSize: [2]uint{1024, 768},
+		Resources: container.Resources{
+			Memory: 1024 * 1024 * int64(configServe.Setting.DockerMemoryLimit),
+		},
+	}
+
+	a.hardening.apply(hostCfg)
+
+	if appendRoot {
+		hostCfg.ReadonlyPaths = append(hostCfg.ReadonlyPaths, "/root")
+	}
+
+	if len(configServe.Setting.Volumns) > 0 {
+		hostCfg.Binds = configServe.Setting.Volumns
+	}
+
+	return createCfg, hostCfg
+}
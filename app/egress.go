@@ -0,0 +1,220 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/ssh"
+	"github.com/sirupsen/logrus"
+
+	rootConfig "github.com/archimoebius/fishler/cli/config/root"
+	configServe "github.com/archimoebius/fishler/cli/config/serve"
+	"github.com/archimoebius/fishler/util"
+	"github.com/archimoebius/fishler/util/egress"
+	"github.com/archimoebius/fishler/util/event"
+	"github.com/archimoebius/fishler/util/pcap"
+)
+
+// egressCAPath is where the egress proxy's CA certificate is mounted in containers
+const egressCAPath = "/etc/ssl/certs/ca-proxy.pem"
+
+// egressSessions maps the session id (and container name) of each session using egress to its context
+type egressSessions struct {
+	sessions sync.Map
+}
+
+// startEgress creates the egress network and serves the proxy on its gateway, returning the proxy URL
+// handed to containers
+func (a *app) startEgress() (string, error) {
+	network, err := util.EnsureEgressNetwork(a.cleanupCtx, configServe.Setting.EgressNetwork)
+	if err != nil {
+		return "", err
+	}
+
+	proxy := egress.New(a.vault, configServe.Setting.EgressFetch, configServe.Setting.EgressMaxSize*1024*1024)
+	proxy.Resolve = a.sessionByContainerIP
+	proxy.Record = a.recordEgress
+
+	if configServe.Setting.EgressTLS {
+		proxy.CA, err = egress.LoadCA(configServe.Setting.CryptoBasepath)
+		if err != nil {
+			return "", err
+		}
+
+		a.egressCA = proxy.CA.Path
+	}
+
+	if configServe.Setting.PCAP {
+		if err := a.startCapture(network); err != nil {
+			return "", err
+		}
+	}
+
+	address := net.JoinHostPort(network.Gateway, strconv.Itoa(configServe.Setting.EgressPort))
+
+	ln, err := net.Listen("tcp4", address)
+	if err != nil {
+		return "", err
+	}
+
+	server := &http.Server{
+		Handler:           proxy,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-a.cleanupCtx.Done()
+		_ = server.Close()
+	}()
+
+	go func() {
+		_ = server.Serve(ln)
+	}()
+
+	if configServe.Setting.EgressTFTPPort > 0 {
+		tftpAddress := net.JoinHostPort(network.Gateway, strconv.Itoa(configServe.Setting.EgressTFTPPort))
+
+		conn, err := net.ListenPacket("udp4", tftpAddress)
+		if err != nil {
+			return "", err
+		}
+
+		go func() {
+			if err := proxy.ServeTFTP(a.cleanupCtx, conn); err != nil {
+				util.Logger.WithError(err).Error("egress tftp server failed")
+			}
+		}()
+
+		util.Logger.Infof("egress tftp listening on %s", tftpAddress)
+	}
+
+	util.Logger.WithFields(logrus.Fields{
+		"network": configServe.Setting.EgressNetwork,
+		"bridge":  network.Bridge,
+		"fetch":   configServe.Setting.EgressFetch,
+	}).Infof("egress proxy listening on %s", address)
+
+	return "http://" + address, nil
+}
+
+// startCapture records the traffic crossing the egress bridge into a pcap per session
+func (a *app) startCapture(network util.EgressNetwork) error {
+	recorder, err := pcap.NewRecorder(
+		filepath.Join(rootConfig.Setting.LogBasepath, "pcap"),
+		configServe.Setting.PCAPMaxSize*1024*1024,
+		time.Duration(configServe.Setting.PCAPMaxSeconds)*time.Second,
+		net.ParseIP(network.Gateway),
+	)
+	if err != nil {
+		return err
+	}
+
+	source, err := pcap.Open(network.Bridge)
+	if err != nil {
+		return err
+	}
+
+	a.pcap = recorder
+
+	go func() {
+		if err := recorder.Run(a.cleanupCtx, source); err != nil {
+			util.Logger.WithError(err).Error("packet capture failed")
+		}
+	}()
+
+	util.Logger.Infof("capturing session traffic on %s", network.Bridge)
+
+	return nil
+}
+
+// sessionByContainerIP returns the session id of the container on the egress network with the given address
+func (a *app) sessionByContainerIP(ip string) string {
+	ctx, cancel := context.WithTimeout(a.cleanupCtx, 5*time.Second)
+	defer cancel()
+
+	name, err := util.ContainerNameByIP(ctx, configServe.Setting.EgressNetwork, ip)
+	if err != nil {
+		util.Logger.WithFields(logrus.Fields{
+			"address": ip,
+			"error":   err,
+		}).Debug("no session container for address")
+	}
+
+	return name
+}
+
+// recordEgress appends the request to the session's egress log and publishes it
+func (a *app) recordEgress(request egress.Request) {
+	util.Logger.WithFields(logrus.Fields{
+		"session_id": request.SessionID,
+		"method":     request.Method,
+		"url":        request.URL,
+		"action":     request.Action,
+		"status":     request.Status,
+		"sha256":     request.SHA256,
+		"size":       request.Size,
+		"error":      request.Error,
+	}).Info("egress request")
+
+	if request.SessionID == "" {
+		return
+	}
+
+	if err := appendSessionLog(request.SessionID, "egress", request); err != nil {
+		util.Logger.WithError(err).Error("failed to write egress log")
+	}
+
+	var e *event.Event
+
+	if ctx, ok := a.egress.sessions.Load(request.SessionID); ok {
+		e = newEvent(ctx.(ssh.Context), event.KindEgress)
+	} else {
+		e = event.New(event.KindEgress)
+		e.SessionID = request.SessionID
+	}
+
+	e.Fields["method"] = request.Method
+	e.Fields["url"] = request.URL
+	e.Fields["action"] = request.Action
+	e.Fields["status"] = strconv.Itoa(request.Status)
+	e.Fields["sha256"] = request.SHA256
+	e.Fields["size"] = strconv.FormatInt(request.Size, 10)
+	a.Publish(e)
+}
+
+// sessionLogMu serialises the appends to the JSON logs kept beside each session log
+var sessionLogMu sync.Mutex
+
+// appendSessionLog writes v as a JSON line to the session's <kind> log beside its session log
+func appendSessionLog(sessionID string, kind string, v any) error {
+	sessionLogMu.Lock()
+	defer sessionLogMu.Unlock()
+
+	basepath := fmt.Sprintf("/%s/session/", rootConfig.Setting.LogBasepath)
+
+	if err := os.MkdirAll(basepath, 0750); err != nil {
+		return err
+	}
+
+	osRoot, err := os.OpenRoot(basepath)
+	if err != nil {
+		return err
+	}
+	defer osRoot.Close()
+
+	f, err := osRoot.OpenFile(sessionID+"."+kind+".log", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewEncoder(f).Encode(v)
+}
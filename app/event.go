@@ -0,0 +1,101 @@
+package app
+
+import (
+	"maps"
+	"net"
+	"strings"
+
+	"github.com/charmbracelet/ssh"
+	"github.com/sirupsen/logrus"
+
+	"github.com/archimoebius/fishler/shim"
+	"github.com/archimoebius/fishler/util"
+	"github.com/archimoebius/fishler/util/event"
+	"github.com/archimoebius/fishler/util/uplink"
+)
+
+// newEvent creates an event of kind populated from whatever the connection context knows so far
+func newEvent(ctx ssh.Context, kind event.Kind) *event.Event {
+	e := event.New(kind)
+
+	// the context is filled in as the handshake progresses - avoid the panicking accessors
+	if addr, ok := ctx.Value(ssh.ContextKeyRemoteAddr).(net.Addr); ok {
+		e.RemoteAddr = addr
+	}
+
+	if user, ok := ctx.Value(ssh.ContextKeyUser).(string); ok {
+		e.Username = user
+	}
+
+	if sessionID, ok := ctx.Value(ssh.ContextKeySessionID).(string); ok {
+		e.SessionID = sessionID
+	}
+
+	if version, ok := ctx.Value(ssh.ContextKeyClientVersion).(string); ok {
+		e.ClientVersion = version
+	}
+
+	if info, ok := ctx.Value(shim.ContextKeyHASSHInfo).(*shim.HASSHInfo); ok && info != nil {
+		e.HASSH = info.Hash
+	}
+
+	if decision := accessDecision(ctx); decision.List != "" {
+		e.Fields["access_list"] = decision.List
+	}
+
+	return e
+}
+
+// Publish hands the event to every configured sink
+func (a *app) Publish(e *event.Event) {
+	e.Fields["sensor_name"] = a.Sensor.Name
+	e.Fields["sensor_tags"] = strings.Join(a.Sensor.Tags, ",")
+
+	if a.GeoIP != nil && e.RemoteAddr != nil {
+		maps.Copy(e.Fields, a.GeoIP.Lookup(e.RemoteAddr).Fields())
+	}
+
+	a.BeamEvent(e)
+
+	if a.Webhooks != nil {
+		a.Webhooks.Dispatch(e)
+	}
+}
+
+// BeamEvent sends the event to the uplink server - if one is configured
+func (a *app) BeamEvent(e *event.Event) {
+	if a.Uplink == nil {
+		return
+	}
+
+	message := uplink.NewSSHConnectionEvent(a.ServiceUUID, e)
+
+	util.Logger.WithFields(logrus.Fields{
+		"Kind":            e.Kind,
+		"TimestampMicros": message.TimestampMicros,
+		"ServiceUuid":     message.ServiceUuid,
+		"Username":        message.Username,
+		"Password":        message.Password,
+		"SshClientName":   message.SshClientName,
+		"Hassh":           message.Hassh,
+		"SourceIp":        message.SourceIp,
+		"SourcePort":      message.SourcePort,
+	}).Debug("beaming event")
+
+	a.Uplink.Enqueue(message)
+}
+
+// geoFields returns what is known about where addr is as log fields
+func (a *app) geoFields(addr net.Addr) logrus.Fields {
+	fields := logrus.Fields{}
+
+	if a.GeoIP == nil || addr == nil {
+		return fields
+	}
+
+	for key, value := range a.GeoIP.Lookup(addr).Fields() {
+		fields[key] = value
+	}
+
+	return fields
+}
@@ -0,0 +1,207 @@
+package app
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/charmbracelet/ssh"
+	"github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+
+	rootConfig "github.com/archimoebius/fishler/cli/config/root"
+	configServe "github.com/archimoebius/fishler/cli/config/serve"
+	"github.com/archimoebius/fishler/util"
+	"github.com/archimoebius/fishler/util/event"
+	"github.com/archimoebius/fishler/util/forward"
+)
+
+// unsafeFilename matches what may not appear in a transcript name built from the client's destination
+var unsafeFilename = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// emulatedDirectTCPIP accepts a direct-tcpip channel and answers it from a canned responder, recording
+// the conversation under <log-basepath>/forward/<session-id>/ - nothing is ever dialed
+func (a *app) emulatedDirectTCPIP(srv *ssh.Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx ssh.Context) {
+	d := struct {
+		DestAddr   string
+		DestPort   uint32
+		OriginAddr string
+		OriginPort uint32
+	}{}
+
+	if err := gossh.Unmarshal(newChan.ExtraData(), &d); err != nil {
+		_ = newChan.Reject(gossh.ConnectionFailed, "error parsing forward data: "+err.Error())
+		return
+	}
+
+	if srv.LocalPortForwardingCallback == nil || !srv.LocalPortForwardingCallback(ctx, d.DestAddr, d.DestPort) {
+		_ = newChan.Reject(gossh.Prohibited, "port forwarding is disabled")
+		return
+	}
+
+	channel, requests, err := newChan.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+
+	go gossh.DiscardRequests(requests)
+
+	directory := util.GetSessionVolumnDirectory(rootConfig.Setting.LogBasepath, "forward", ctx.SessionID())
+
+	root, err := os.OpenRoot(directory)
+	if err != nil {
+		util.Logger.Error(err)
+		return
+	}
+	defer root.Close()
+
+	name := fmt.Sprintf("%s-%s_%d.log", time.Now().UTC().Format("20060102T150405.000000000"), unsafeFilename.ReplaceAllString(d.DestAddr, "_"), d.DestPort)
+
+	f, err := root.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		util.Logger.Error(err)
+		return
+	}
+	defer f.Close()
+
+	responder := forward.Select(d.DestAddr, d.DestPort, func(m forward.Message) error {
+		return a.storeMail(ctx, root, m)
+	})
+	transcript := forward.NewTranscript(channel, f, configServe.Setting.ForwardMaxSize*1024*1024)
+
+	startedAt := time.Now()
+	err = responder.Serve(transcript)
+
+	fields := logrus.Fields{
+		"address":    ctx.RemoteAddr().String(),
+		"session_id": ctx.SessionID(),
+		"host":       d.DestAddr,
+		"port":       d.DestPort,
+		"responder":  responder.Name,
+		"bytes_in":   util.ByteCountDecimal(transcript.BytesIn),
+		"bytes_out":  util.ByteCountDecimal(transcript.BytesOut),
+		"duration":   time.Since(startedAt).String(),
+		"transcript": f.Name(),
+	}
+
+	if err != nil {
+		fields["error"] = err
+	}
+
+	util.Logger.WithFields(fields).Info("emulated local port forward closed")
+}
+
+// contextKeyMailBytes holds the bytes of mail stored for the session across all of its forwards
+var contextKeyMailBytes = &struct{ name string }{"mail-bytes"}
+
+// mailBytes returns the session's count of stored mail bytes
+func mailBytes(ctx ssh.Context) *atomic.Int64 {
+	ctx.Lock()
+	defer ctx.Unlock()
+
+	if stored, ok := ctx.Value(contextKeyMailBytes).(*atomic.Int64); ok {
+		return stored
+	}
+
+	stored := &atomic.Int64{}
+	ctx.SetValue(contextKeyMailBytes, stored)
+
+	return stored
+}
+
+// storeMail writes a message caught by the SMTP responder beside the forward's transcript as <queue-id>.eml
+// - once the session has stored --forward-mail-max-size of mail the rest is deferred
+func (a *app) storeMail(ctx ssh.Context, root *os.Root, m forward.Message) error {
+	name := m.QueueID + ".eml"
+	eml := m.EML()
+
+	if limit := configServe.Setting.ForwardMailMaxSize * 1024 * 1024; limit > 0 {
+		stored := mailBytes(ctx)
+
+		if stored.Add(int64(len(eml))) > limit {
+			stored.Add(-int64(len(eml)))
+
+			util.Logger.WithFields(logrus.Fields{
+				"address":    ctx.RemoteAddr().String(),
+				"session_id": ctx.SessionID(),
+				"size":       util.ByteCountDecimal(int64(len(eml))),
+			}).Warn("smtp message refused - session mail limit reached")
+
+			return forward.ErrInsufficientStorage
+		}
+	}
+
+	err := root.WriteFile(name, eml, 0600)
+	if err != nil {
+		util.Logger.Error(err)
+		return err
+	}
+
+	util.Logger.WithFields(logrus.Fields{
+		"address":    ctx.RemoteAddr().String(),
+		"session_id": ctx.SessionID(),
+		"helo":       m.Helo,
+		"from":       m.From,
+		"recipients": len(m.To),
+		"size":       util.ByteCountDecimal(int64(len(m.Data))),
+		"path":       filepath.Join(root.Name(), name),
+	}).Info("smtp message caught")
+
+	e := newEvent(ctx, event.KindMail)
+	e.Fields["host"] = m.Hostname
+	e.Fields["helo"] = m.Helo
+	e.Fields["auth_username"] = m.Username
+	e.Fields["from"] = m.From
+	e.Fields["to"] = strings.Join(m.To, ",")
+	e.Fields["size"] = strconv.Itoa(len(m.Data))
+	e.Fields["queue_id"] = m.QueueID
+	a.Publish(e)
+
+	return nil
+}
+
+// emulatedTCPIPForward acknowledges remote forwarding requests without binding anything - no
+// forwarded-tcpip channels are ever opened back to the client
+func (a *app) emulatedTCPIPForward(ctx ssh.Context, srv *ssh.Server, req *gossh.Request) (bool, []byte) {
+	switch req.Type {
+	case "tcpip-forward":
+		var payload struct {
+			BindAddr string
+			BindPort uint32
+		}
+
+		if err := gossh.Unmarshal(req.Payload, &payload); err != nil {
+			return false, []byte{}
+		}
+
+		if srv.ReversePortForwardingCallback == nil || !srv.ReversePortForwardingCallback(ctx, payload.BindAddr, payload.BindPort) {
+			return false, []byte("port forwarding is disabled")
+		}
+
+		port := payload.BindPort
+		if port == 0 {
+			// pretend the kernel picked an ephemeral port
+			port = 32768 + rand.Uint32N(28232) // #nosec
+		}
+
+		util.Logger.WithFields(logrus.Fields{
+			"address":    ctx.RemoteAddr().String(),
+			"session_id": ctx.SessionID(),
+			"host":       payload.BindAddr,
+			"port":       strconv.FormatUint(uint64(port), 10),
+		}).Info("emulated reverse port forward")
+
+		return true, gossh.Marshal(&struct{ Port uint32 }{port})
+	case "cancel-tcpip-forward":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
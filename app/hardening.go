@@ -0,0 +1,103 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+
+	configServe "github.com/archimoebius/fishler/cli/config/serve"
+)
+
+// hardening is the container lockdown applied to every session container
+type hardening struct {
+	nanoCPUs      int64
+	pidsLimit     int64
+	ulimits       []*container.Ulimit
+	capDrop       []string
+	capAdd        []string
+	securityOpt   []string
+	readonlyPaths []string
+	readonlyRoot  bool
+	tmpfs         map[string]string
+	runtime       string
+}
+
+// newHardening validates the hardening settings - profiles are read once here rather than per session
+func newHardening() (*hardening, error) {
+	setting := configServe.Setting
+
+	h := &hardening{
+		nanoCPUs:      int64(setting.DockerCPUs * 1e9),
+		pidsLimit:     setting.DockerPidsLimit,
+		capDrop:       setting.DockerCapDrop,
+		capAdd:        setting.DockerCapAdd,
+		readonlyPaths: setting.DockerReadonlyPaths,
+		readonlyRoot:  setting.DockerReadonlyRootfs,
+		tmpfs:         make(map[string]string),
+		runtime:       setting.DockerRuntime,
+	}
+
+	for _, value := range setting.DockerUlimits {
+		ulimit, err := units.ParseUlimit(value)
+		if err != nil {
+			return nil, fmt.Errorf("docker-ulimit %q: %w", value, err)
+		}
+
+		h.ulimits = append(h.ulimits, ulimit)
+	}
+
+	if setting.DockerNoNewPrivileges {
+		h.securityOpt = append(h.securityOpt, "no-new-privileges:true")
+	}
+
+	// the docker API takes the seccomp profile itself rather than a path
+	if setting.DockerSeccompProfile != "" {
+		profile, err := os.ReadFile(setting.DockerSeccompProfile)
+		if err != nil {
+			return nil, err
+		}
+
+		h.securityOpt = append(h.securityOpt, "seccomp="+string(profile))
+	}
+
+	if setting.DockerAppArmorProfile != "" {
+		h.securityOpt = append(h.securityOpt, "apparmor="+setting.DockerAppArmorProfile)
+	}
+
+	for _, value := range setting.DockerTmpfs {
+		path, options, _ := strings.Cut(value, ":")
+
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("docker-tmpfs %q: expected an absolute path", value)
+		}
+
+		h.tmpfs[path] = options
+	}
+
+	return h, nil
+}
+
+// apply locks down hostCfg
+func (h *hardening) apply(hostCfg *container.HostConfig) {
+	hostCfg.Resources.NanoCPUs = h.nanoCPUs
+	hostCfg.Resources.Ulimits = h.ulimits
+
+	if h.pidsLimit > 0 {
+		pidsLimit := h.pidsLimit
+		hostCfg.Resources.PidsLimit = &pidsLimit
+	}
+
+	hostCfg.CapDrop = h.capDrop
+	hostCfg.CapAdd = h.capAdd
+	hostCfg.SecurityOpt = h.securityOpt
+	hostCfg.ReadonlyPaths = append([]string{}, h.readonlyPaths...)
+	hostCfg.ReadonlyRootfs = h.readonlyRoot
+	hostCfg.Runtime = h.runtime
+
+	if len(h.tmpfs) > 0 {
+		hostCfg.Tmpfs = h.tmpfs
+	}
+}
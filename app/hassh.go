@@ -0,0 +1,58 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// hasshRegistry remembers every HASSH seen (across restarts) so first sightings can be flagged
+type hasshRegistry struct {
+	mu   sync.Mutex
+	seen map[string]bool
+	file *os.File
+}
+
+func newHASSHRegistry(path string) (*hasshRegistry, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600) // #nosec
+	if err != nil {
+		return nil, err
+	}
+
+	r := &hasshRegistry{
+		seen: make(map[string]bool),
+		file: file,
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		r.seen[scanner.Text()] = true
+	}
+
+	return r, scanner.Err()
+}
+
+// Observe records hash and reports whether this is the first time it has been seen
+func (r *hasshRegistry) Observe(hash string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.seen[hash] {
+		return false
+	}
+
+	r.seen[hash] = true
+	_, _ = fmt.Fprintln(r.file, hash)
+
+	return true
+}
+
+func (r *hasshRegistry) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,66 @@
+package app
+
+import (
+	"net"
+	"time"
+
+	"github.com/charmbracelet/ssh"
+	"github.com/sirupsen/logrus"
+
+	configServe "github.com/archimoebius/fishler/cli/config/serve"
+	"github.com/archimoebius/fishler/util"
+	"github.com/archimoebius/fishler/util/limit"
+	"github.com/archimoebius/fishler/util/metrics"
+)
+
+var contextKeyAuthAttempts = &struct{ name string }{"auth-attempts"}
+
+// overLimit records that the client at addr went over the named limit and reports whether it
+// should be turned away - tarpitted clients are held for a while first, given conn they are held
+// in the pre-banner tarpit
+func (a *app) overLimit(addr net.Addr, name string, conn net.Conn) bool {
+	metrics.LimitExceeded.WithLabelValues(name, string(a.LimitAction)).Inc()
+
+	util.Logger.WithFields(logrus.Fields{
+		"address": addr.String(),
+		"limit":   name,
+		"action":  a.LimitAction,
+	}).Warn("limit exceeded")
+
+	switch a.LimitAction {
+	case limit.ActionLog:
+		return false
+	case limit.ActionTarpit:
+		if conn != nil {
+			a.tarpit(conn, "limit-"+name, tarpitBanner)
+			break
+		}
+
+		timer := time.NewTimer(time.Duration(configServe.Setting.TarpitSeconds) * time.Second)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-a.cleanupCtx.Done():
+		}
+	}
+
+	return true
+}
+
+// authAttemptAllowed counts an authentication attempt on the connection and reports whether it may proceed
+func (a *app) authAttemptAllowed(ctx ssh.Context) bool {
+	if configServe.Setting.LimitAuthAttempts <= 0 {
+		return true
+	}
+
+	attempts, _ := ctx.Value(contextKeyAuthAttempts).(int)
+	attempts++
+	ctx.SetValue(contextKeyAuthAttempts, attempts)
+
+	if attempts <= configServe.Setting.LimitAuthAttempts {
+		return true
+	}
+
+	return !a.overLimit(ctx.RemoteAddr(), "auth-attempts", nil)
+}
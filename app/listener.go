@@ -0,0 +1,43 @@
+package app
+
+import (
+	"net"
+
+	"github.com/charmbracelet/ssh"
+
+	configServe "github.com/archimoebius/fishler/cli/config/serve"
+)
+
+var contextKeyListener = &struct{ name string }{"listener"}
+
+// profileListener tags each accepted connection with the listener it arrived on
+type profileListener struct {
+	net.Listener
+	profile configServe.Listener
+}
+
+type profileConn struct {
+	net.Conn
+	profile configServe.Listener
+}
+
+func (l *profileListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &profileConn{Conn: conn, profile: l.profile}, nil
+}
+
+// listenerProfile returns the listener the connection arrived on - the ip/port listener if unknown
+func listenerProfile(ctx ssh.Context) configServe.Listener {
+	if profile, ok := ctx.Value(contextKeyListener).(configServe.Listener); ok {
+		return profile
+	}
+
+	return configServe.Listener{
+		Banner:   configServe.Setting.Banner,
+		Hostname: configServe.Setting.DockerHostname,
+	}
+}
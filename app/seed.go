@@ -0,0 +1,165 @@
+package app
+
+import (
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/ssh"
+	"github.com/sirupsen/logrus"
+
+	"github.com/archimoebius/fishler/util"
+	"github.com/archimoebius/fishler/util/seed"
+	"github.com/archimoebius/fishler/util/token"
+)
+
+// seededHomes are the FishyFS mounts seeded for sessions which are still live - every session of a
+// user shares their mount so one joining it keeps the honeytokens already written there rather than
+// rendering new ones over another attacker's
+type seededHomes struct {
+	mu    sync.Mutex
+	homes map[string]*seededHome
+}
+
+type seededHome struct {
+	sessions int
+	tokens   []token.Token
+}
+
+// seedSession applies the seed's home tree to the user's FishyFS mount at hostVolumnWorkingDir, unless
+// another session of theirs is live in it, and returns its root tree packed for the session's
+// container - nil without a seed or on failure, which is logged rather than costing the session. Call
+// release once the session ends
+func (a *app) seedSession(sess ssh.Session, hostVolumnWorkingDir string) (rootSeed []byte, release func()) {
+	if a.seed == nil {
+		return nil, func() {}
+	}
+
+	data := seed.Data{
+		User:      sess.User(),
+		Hostname:  listenerProfile(sess.Context()).Hostname,
+		SessionID: sess.Context().SessionID(),
+		Address:   sess.RemoteAddr().String(),
+	}
+
+	logger := util.Logger.WithFields(logrus.Fields{
+		"session_id": data.SessionID,
+		"username":   data.User,
+	})
+
+	release = a.seedHome(data, hostVolumnWorkingDir, logger)
+
+	var issued []token.Token
+
+	root, err := a.seed.Render(seed.TreeRoot, data, collectTokens(data, &issued))
+	if err != nil {
+		logger.WithError(err).Error("failed to seed container")
+		return nil, release
+	}
+
+	if len(root) == 0 {
+		return nil, release
+	}
+
+	packed, err := seed.Tar(root)
+	if err != nil {
+		logger.WithError(err).Error("failed to seed container")
+		return nil, release
+	}
+
+	a.recordTokens(issued)
+
+	return packed, release
+}
+
+// seedHome renders the home tree into hostVolumnWorkingDir for the first live session of the user -
+// one joining it is recorded against the tokens already there
+func (a *app) seedHome(data seed.Data, hostVolumnWorkingDir string, logger *logrus.Entry) (release func()) {
+	a.seeded.mu.Lock()
+	defer a.seeded.mu.Unlock()
+
+	if a.seeded.homes == nil {
+		a.seeded.homes = map[string]*seededHome{}
+	}
+
+	home, live := a.seeded.homes[hostVolumnWorkingDir]
+
+	if live {
+		joined := make([]token.Token, len(home.tokens))
+
+		for idx, t := range home.tokens {
+			t.SessionID = data.SessionID
+			t.Address = data.Address
+			t.Hostname = data.Hostname
+			t.Issued = time.Now().UTC()
+
+			joined[idx] = t
+		}
+
+		a.recordTokens(joined)
+	} else {
+		home = &seededHome{}
+		a.seeded.homes[hostVolumnWorkingDir] = home
+
+		var issued []token.Token
+
+		files, err := a.seed.Render(seed.TreeHome, data, collectTokens(data, &issued))
+		if err == nil {
+			err = seed.Apply(hostVolumnWorkingDir, files)
+		}
+
+		// only tokens which made it onto disk are recorded
+		if err != nil {
+			logger.WithError(err).Error("failed to seed home")
+		} else {
+			home.tokens = issued
+			a.recordTokens(issued)
+		}
+	}
+
+	home.sessions++
+
+	return func() {
+		a.seeded.mu.Lock()
+		defer a.seeded.mu.Unlock()
+
+		home.sessions--
+
+		if home.sessions == 0 {
+			delete(a.seeded.homes, hostVolumnWorkingDir)
+		}
+	}
+}
+
+// collectTokens gathers each honeytoken rendered into the session's seed into issued
+func collectTokens(data seed.Data, issued *[]token.Token) seed.IssueFunc {
+	return func(kind token.Kind, value string, p string) {
+		*issued = append(*issued, token.Token{
+			Value:     value,
+			Kind:      kind,
+			Path:      p,
+			SessionID: data.SessionID,
+			Address:   data.Address,
+			User:      data.User,
+			Hostname:  data.Hostname,
+			Issued:    time.Now().UTC(),
+		})
+	}
+}
+
+// recordTokens records the honeytokens written for a session against it
+func (a *app) recordTokens(tokens []token.Token) {
+	for _, t := range tokens {
+		util.Logger.WithFields(logrus.Fields{
+			"session_id": t.SessionID,
+			"address":    t.Address,
+			"username":   t.User,
+			"kind":       t.Kind,
+			"path":       t.Path,
+			"value":      t.Value,
+		}).Info("honeytoken issued")
+
+		if err := a.tokens.Issue(t); err != nil {
+			util.Logger.WithError(err).Error("failed to record honeytoken")
+		}
+	}
+}
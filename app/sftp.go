@@ -0,0 +1,92 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/ssh"
+	"github.com/docker/docker/api/types/network"
+
+	configServe "github.com/archimoebius/fishler/cli/config/serve"
+	"github.com/archimoebius/fishler/util"
+	"github.com/archimoebius/fishler/util/event"
+	"github.com/archimoebius/fishler/util/quota"
+	FishlerSFTP "github.com/archimoebius/fishler/util/sftp"
+)
+
+// homeDirectory is where the user's FishyFS mount appears inside the container
+func homeDirectory(user string) string {
+	if user == "root" {
+		return "/root/"
+	}
+
+	return fmt.Sprintf("/home/%s", user)
+}
+
+// quota is the tracker shared by every session writing to the FishyFS mount at hostVolumnWorkingDir
+func (a *app) quota(hostVolumnWorkingDir string) *quota.Tracker {
+	tracker, _ := a.quotas.LoadOrStore(hostVolumnWorkingDir, quota.New(hostVolumnWorkingDir, configServe.Setting.DockerDiskLimit*1024*1024))
+
+	return tracker.(*quota.Tracker)
+}
+
+// fishlerFS serves file transfers for the session from the user's FishyFS mount at hostVolumnWorkingDir
+func (a *app) fishlerFS(sess ssh.Session, hostVolumnWorkingDir string) FishlerSFTP.FishlerFS {
+	dockerVolumnWorkingDir := homeDirectory(sess.User())
+
+	return FishlerSFTP.FishlerFS{
+		Quota: a.quota(hostVolumnWorkingDir),
+		GetDockerVolumnPath: func(fs FishlerSFTP.FishlerFS, p string) (string, error) {
+			var replace = filepath.Clean(hostVolumnWorkingDir)
+
+			p = filepath.Clean(strings.ReplaceAll(filepath.Clean(p), dockerVolumnWorkingDir, replace))
+
+			if !strings.HasPrefix(p, replace) {
+				return "", errors.New("bad path")
+			}
+
+			return p, nil
+		},
+		Notify: func(fs FishlerSFTP.FishlerFS, kind event.Kind, fields map[string]string) {
+			e := newEvent(sess.Context(), kind)
+			maps.Copy(e.Fields, fields)
+			a.Publish(e)
+		},
+		Record: func(fs FishlerSFTP.FishlerFS, transfer FishlerSFTP.Transfer) {
+			a.recordTransfer(sess.Context(), transfer)
+		},
+		Lock:      &sync.Mutex{},
+		Vault:     a.vault,
+		Home:      filepath.Clean(dockerVolumnWorkingDir),
+		HostHome:  filepath.Clean(hostVolumnWorkingDir),
+		User:      sess.User(),
+		RemoteIP:  sess.RemoteAddr().String(),
+		SessionID: sess.Context().SessionID(),
+	}
+}
+
+// containerFS starts a container for the SFTP session, with the user's FishyFS mount as their home, and
+// serves its whole filesystem - call closer once the session ends
+func (a *app) containerFS(sess ssh.Session, fs FishlerSFTP.FishlerFS, hostVolumnWorkingDir string, rootSeed []byte) (cfs FishlerSFTP.ContainerFS, closer func(), err error) {
+	createCfg, hostCfg := a.containerConfig(sess)
+	password, _ := sess.Context().Value(util.ContextKeyPassword).(string)
+
+	c, err := util.StartSessionContainer(a.cleanupCtx, sess.Context().SessionID()+"-sftp", sess.User(), password, hostVolumnWorkingDir, homeDirectory(sess.User()), createCfg, hostCfg, &network.NetworkingConfig{}, rootSeed)
+	if err != nil {
+		return cfs, nil, err
+	}
+
+	// the container lives as long as the session so what is uploaded into it is counted for the session
+	return FishlerSFTP.ContainerFS{
+		FishlerFS:      fs,
+		Container:      c,
+		Context:        a.cleanupCtx,
+		MaxSize:        configServe.Setting.DockerDiskLimit * 1024 * 1024,
+		Spool:          a.spool,
+		ContainerQuota: quota.New("", configServe.Setting.DockerDiskLimit*1024*1024),
+	}, c.Close, nil
+}
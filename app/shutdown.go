@@ -0,0 +1,43 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/charmbracelet/ssh"
+
+	configServe "github.com/archimoebius/fishler/cli/config/serve"
+	"github.com/archimoebius/fishler/util"
+)
+
+// containerGrace is how long killed session containers are given to tear down their sessions
+const containerGrace = 30 * time.Second
+
+// shutdown stops accepting connections, gives open sessions the shutdown timeout to finish and then
+// kills their containers - it returns once every session handler has finished or the grace expires
+func (a *app) shutdown(s *ssh.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(configServe.Setting.ShutdownTimeout)*time.Second)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != nil {
+		util.Logger.WithError(err).Warn("sessions still open after the shutdown timeout")
+	}
+
+	// kills any running containers and releases tarpitted clients
+	a.cleanupCancel()
+
+	_ = s.Close()
+
+	drained := make(chan struct{})
+	go func() {
+		a.sessions.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		util.Logger.Info("all sessions closed")
+	case <-time.After(containerGrace):
+		util.Logger.Warn("gave up waiting on sessions to close")
+	}
+}
@@ -0,0 +1,41 @@
+package app
+
+import (
+	"context"
+	"net"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/archimoebius/fishler/util"
+	"github.com/archimoebius/fishler/util/metrics"
+	"github.com/archimoebius/fishler/util/tarpit"
+)
+
+// Tarpit hold modes - Banner before the server identification string, KEX once the client's KEXINIT is read
+var (
+	tarpitBanner = tarpit.Tarpit.Banner
+	tarpitKEX    = tarpit.Tarpit.KEX
+)
+
+// tarpit holds conn for as long as the client tolerates, recording the time it wasted
+func (a *app) tarpit(conn net.Conn, reason string, hold func(tarpit.Tarpit, context.Context, net.Conn) tarpit.Result) {
+	metrics.TarpitClients.WithLabelValues(reason).Inc()
+	metrics.TarpitActive.Inc()
+	defer metrics.TarpitActive.Dec()
+
+	util.Logger.WithFields(logrus.Fields{
+		"address": conn.RemoteAddr().String(),
+		"reason":  reason,
+	}).Info("tarpit started")
+
+	result := hold(a.Tarpit, a.cleanupCtx, conn)
+
+	metrics.TarpitSeconds.WithLabelValues(reason).Add(result.Duration.Seconds())
+
+	util.Logger.WithFields(logrus.Fields{
+		"address":  conn.RemoteAddr().String(),
+		"reason":   reason,
+		"duration": result.Duration.String(),
+		"bytes":    result.Bytes,
+	}).WithFields(a.geoFields(conn.RemoteAddr())).Info("tarpit released")
+}
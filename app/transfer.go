@@ -0,0 +1,47 @@
+package app
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/charmbracelet/ssh"
+	"github.com/sirupsen/logrus"
+
+	"github.com/archimoebius/fishler/util"
+	"github.com/archimoebius/fishler/util/event"
+	FishlerSFTP "github.com/archimoebius/fishler/util/sftp"
+)
+
+// recordTransfer appends the closed file handle to the session's transfer log and publishes it
+func (a *app) recordTransfer(ctx ssh.Context, transfer FishlerSFTP.Transfer) {
+	util.Logger.WithFields(logrus.Fields{
+		"session_id": transfer.SessionID,
+		"address":    transfer.Address,
+		"user":       transfer.User,
+		"protocol":   transfer.Protocol,
+		"direction":  transfer.Direction,
+		"path":       transfer.Path,
+		"bytes":      transfer.Bytes,
+		"size":       util.ByteCountDecimal(transfer.Size),
+		"complete":   transfer.Complete,
+		"sha256":     transfer.SHA256,
+		"duration":   transfer.Closed.Sub(transfer.Opened).Round(time.Millisecond),
+		"error":      transfer.Error,
+	}).Info("sftp transfer")
+
+	if err := appendSessionLog(transfer.SessionID, "transfer", transfer); err != nil {
+		util.Logger.WithError(err).Error("failed to write transfer log")
+	}
+
+	e := newEvent(ctx, event.KindTransfer)
+	e.Fields["protocol"] = transfer.Protocol
+	e.Fields["direction"] = transfer.Direction
+	e.Fields["path"] = transfer.Path
+	e.Fields["bytes"] = strconv.FormatInt(transfer.Bytes, 10)
+	e.Fields["size"] = strconv.FormatInt(transfer.Size, 10)
+	e.Fields["complete"] = strconv.FormatBool(transfer.Complete)
+	e.Fields["sha256"] = transfer.SHA256
+	e.Fields["opened"] = transfer.Opened.Format(time.RFC3339Nano)
+	e.Fields["closed"] = transfer.Closed.Format(time.RFC3339Nano)
+	a.Publish(e)
+}
@@ -78,6 +78,7 @@ var RootCmd = &cobra.Command{
 func init() {
 	RootCmd.AddCommand(ServeCmd)
 	RootCmd.AddCommand(ImageCmd)
+	RootCmd.AddCommand(TokenCmd)
 	RootCmd.AddCommand(DocCmd)
 
 	RootCmd.Flags().BoolP("version", "v", false, "Show the version and exit")
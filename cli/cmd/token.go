@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var TokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Trace the honeytokens issued to sessions",
+	Long:  `Honeytokens are planted in seeded files - each is recorded against the session it was issued to`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		CallPersistentPreRun(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+
+		err := cmd.Help()
+
+		if err != nil {
+			log.Fatal(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	TokenCmd.AddCommand(TokenLookupCmd)
+}
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	config "github.com/archimoebius/fishler/cli/config/root"
+	"github.com/archimoebius/fishler/util"
+	"github.com/archimoebius/fishler/util/token"
+	"github.com/spf13/cobra"
+)
+
+var TokenLookupCmd = &cobra.Command{
+	Use:   "lookup <value>",
+	Short: "Find the session a honeytoken was issued to",
+	Long:  `Find the session, address and file a honeytoken was issued in. An SSH private or public key is matched by its fingerprint - pass - to read the value from stdin.`,
+	Args:  cobra.ExactArgs(1),
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		CallPersistentPreRun(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		value := args[0]
+
+		if value == "-" {
+			b, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				util.Logger.Error(err)
+				return
+			}
+
+			value = string(b)
+		}
+
+		registry, err := token.Open(filepath.Join(config.Setting.LogBasepath, "token"))
+		if err != nil {
+			util.Logger.Error(err)
+			return
+		}
+
+		tokens, err := registry.Lookup(value)
+		if err != nil {
+			util.Logger.Error(err)
+			return
+		}
+
+		if len(tokens) == 0 {
+			util.Logger.Info("No honeytoken found for that value")
+			return
+		}
+
+		for _, t := range tokens {
+			util.Logger.Infof("Kind: %s Issued: %s Session: %s Address: %s User: %s Host: %s File: %s", t.Kind, t.Issued.Format(time.RFC3339), t.SessionID, t.Address, t.User, t.Hostname, t.Path)
+		}
+	},
+}
@@ -21,6 +21,7 @@ var initial = &setting{
 	DockerImagename:     "fishler",
 	DockerBasepath:      "docker",
 	UplinkServerAddress: "",
+	UplinkQueueSize:     1024,
 }
 
 // Create private data struct to hold setting options.
@@ -34,6 +35,7 @@ type setting struct {
 	DockerImagename     string `mapstructure:"docker-imagename" structs:"docker-imagename" env:"FISHLER_DOCKER_IMAGENAME"`
 	DockerBasepath      string `mapstructure:"docker-basepath" structs:"docker-basepath" env:"FISHLER_DOCKER_BASEPATH"`
 	UplinkServerAddress string `mapstructure:"uplink-server-address" structs:"uplink-server-address" env:"FISHLER_UPLINK_SERVER_ADDRESS"`
+	UplinkQueueSize     int    `mapstructure:"uplink-queue-size" structs:"uplink-queue-size" env:"FISHLER_UPLINK_QUEUE_SIZE"`
 }
 
 func Load() {
@@ -71,6 +73,7 @@ func CommandInit(command *cobra.Command) error {
 	command.PersistentFlags().String("docker-imagename", initial.DockerImagename, "The image user for the docker container")
 	command.PersistentFlags().String("docker-basepath", initial.DockerBasepath, "The path to the docker folder ./docker if run from the root of the project")
 	command.PersistentFlags().String("uplink-server-address", initial.UplinkServerAddress, "The uplink server address in the form IP:PORT")
+	command.PersistentFlags().Int("uplink-queue-size", initial.UplinkQueueSize, "The number of uplink events held in memory before spilling to the on-disk journal")
 	command.PersistentFlags().StringP("log-basepath", "l", initial.LogBasepath, "The base filepath where logs will be stored")
 	command.PersistentFlags().StringP("config", "c", initial.Config, ".fishler.yaml")
 	command.PersistentFlags().BoolP("debug", "d", initial.Debug, "Output debug information")
@@ -5,6 +5,7 @@ import (
 	"encoding/csv"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"slices"
 	"strings"
@@ -27,16 +28,68 @@ var initial = &setting{
 	Banner:                     "OpenSSH_8.8",
 	IP:                         "127.0.0.1",
 	Port:                       2222,
+	Listen:                     []string{},
 	DockerHostname:             "localhost",
 	CryptoBasepath:             "/opt/fishler/crypto",
 	DockerMemoryLimit:          8,
 	DockerDiskLimit:            100, // MB
+	DockerCPUs:                 1,
+	DockerPidsLimit:            256,
+	DockerUlimits:              []string{"nofile=1024:1024", "core=0:0"},
+	DockerCapDrop:              []string{"ALL"},
+	DockerCapAdd:               []string{"CHOWN", "DAC_OVERRIDE", "FOWNER"}, // needed by /fixme
+	DockerNoNewPrivileges:      true,
+	DockerSeccompProfile:       "",
+	DockerAppArmorProfile:      "",
+	DockerReadonlyRootfs:       false,
+	DockerReadonlyPaths:        []string{"/bin", "/dev", "/lib", "/media", "/mnt", "/opt", "/run", "/sbin", "/srv", "/sys", "/usr", "/var"},
+	DockerTmpfs:                []string{},
+	DockerRuntime:              "",
 	AccountFilepath:            "",
 	PasswordFilepath:           "",
 	Account:                    "",
 	Password:                   "",
 	AnyAccount:                 false,
 	NoAccount:                  false,
+	MetricsAddress:             "",
+	WebhookFilepath:            "",
+	SensorUUID:                 "",
+	SensorName:                 "",
+	SensorTags:                 []string{},
+	GeoIPCountryFilepath:       "",
+	GeoIPCityFilepath:          "",
+	GeoIPASNFilepath:           "",
+	ShutdownTimeout:            30,
+	Egress:                     false,
+	EgressNetwork:              "fishler-egress",
+	EgressPort:                 3128,
+	EgressFetch:                true,
+	EgressMaxSize:              50, // MB
+	EgressTLS:                  true,
+	EgressTFTPPort:             69,
+	SFTPContainer:              false,
+	SFTPSpoolLimit:             1024, // MB
+	Seed:                       "",
+	TokenDomain:                "internal",
+	ForwardEmulation:           false,
+	ForwardMaxSize:             1,  // MB
+	ForwardMailMaxSize:         50, // MB
+	PCAP:                       false,
+	PCAPMaxSize:                50, // MB
+	PCAPMaxSeconds:             3600,
+	ProxyProtocol:              false,
+	ProxyTrustedCIDRs:          []string{},
+	AccessFilepath:             "",
+	DenyCIDRs:                  []string{},
+	LimitConnectionsPerMinute:  0,
+	LimitSessionsPerIP:         0,
+	LimitContainers:            0,
+	LimitAuthAttempts:          0,
+	LimitAction:                "reject",
+	TarpitSeconds:              300,
+	TarpitInterval:             10,
+	TarpitHASSH:                false,
+	TarpitCIDRs:                []string{},
 }
 
 // Create private data struct to hold setting options.
@@ -47,11 +100,24 @@ type setting struct {
 	Banner                     string   `mapstructure:"banner" structs:"banner" env:"FISHLER_BANNER"`
 	DockerMemoryLimit          int      `mapstructure:"docker-memory-limit" structs:"docker-memory-limit" env:"FISHLER_DOCKER_MEMORY_LIMIT"`
 	DockerDiskLimit            int64    `mapstructure:"docker-disk-limit" structs:"docker-disk-limit" env:"FISHLER_DOCKER_DISK_LIMIT"`
+	DockerCPUs                 float64  `mapstructure:"docker-cpus" structs:"docker-cpus" env:"FISHLER_DOCKER_CPUS"`
+	DockerPidsLimit            int64    `mapstructure:"docker-pids-limit" structs:"docker-pids-limit" env:"FISHLER_DOCKER_PIDS_LIMIT"`
+	DockerUlimits              []string `mapstructure:"docker-ulimit" structs:"docker-ulimit" env:"FISHLER_DOCKER_ULIMITS"`
+	DockerCapDrop              []string `mapstructure:"docker-cap-drop" structs:"docker-cap-drop" env:"FISHLER_DOCKER_CAP_DROP"`
+	DockerCapAdd               []string `mapstructure:"docker-cap-add" structs:"docker-cap-add" env:"FISHLER_DOCKER_CAP_ADD"`
+	DockerNoNewPrivileges      bool     `mapstructure:"docker-no-new-privileges" structs:"docker-no-new-privileges" env:"FISHLER_DOCKER_NO_NEW_PRIVILEGES"`
+	DockerSeccompProfile       string   `mapstructure:"docker-seccomp-profile" structs:"docker-seccomp-profile" env:"FISHLER_DOCKER_SECCOMP_PROFILE"`
+	DockerAppArmorProfile      string   `mapstructure:"docker-apparmor-profile" structs:"docker-apparmor-profile" env:"FISHLER_DOCKER_APPARMOR_PROFILE"`
+	DockerReadonlyRootfs       bool     `mapstructure:"docker-readonly-rootfs" structs:"docker-readonly-rootfs" env:"FISHLER_DOCKER_READONLY_ROOTFS"`
+	DockerReadonlyPaths        []string `mapstructure:"docker-readonly-path" structs:"docker-readonly-path" env:"FISHLER_DOCKER_READONLY_PATHS"`
+	DockerTmpfs                []string `mapstructure:"docker-tmpfs" structs:"docker-tmpfs" env:"FISHLER_DOCKER_TMPFS"`
+	DockerRuntime              string   `mapstructure:"docker-runtime" structs:"docker-runtime" env:"FISHLER_DOCKER_RUNTIME"`
 	Volumns                    []string `mapstructure:"volumn" structs:"volumn"`
 	CryptoBasepath             string   `mapstructure:"crypto-basepath" structs:"crypto-basepath" env:"FISHLER_CRYPTO_BASEPATH"`
 	DockerHostname             string   `mapstructure:"docker-hostname" structs:"docker-hostname" env:"FISHLER_DOCKER_HOSTNAME"`
 	Port                       int      `mapstructure:"port" structs:"port" env:"FISHLER_PORT"`
 	IP                         string   `mapstructure:"ip" structs:"ip" env:"FISHLER_IP"`
+	Listen                     []string `mapstructure:"listen" structs:"listen" env:"FISHLER_LISTEN"`
 	RandomConnectionSleepCount int      `mapstructure:"random-sleep-count" structs:"random-sleep-count" env:"FISHLER_SSH_CONNECT_SLEEP_COUNT"`
 	AccountFilepath            string   `mapstructure:"account-file" structs:"account-file" env:"FISHLER_ACCOUNT_FILE"`
 	PasswordFilepath           string   `mapstructure:"password-file" structs:"password-file" env:"FISHLER_PASSWORD_FILE"`
@@ -59,6 +125,45 @@ type setting struct {
 	Password                   string   `mapstructure:"password" structs:"password" env:"FISHLER_PASSWORD"` // #nosec
 	AnyAccount                 bool     `mapstructure:"any-account" structs:"any-account" env:"FISHLER_ANY_ACCOUNT"`
 	NoAccount                  bool     `mapstructure:"no-account" structs:"no-account" env:"FISHLER_NO_ACCOUNT"`
+	MetricsAddress             string   `mapstructure:"metrics-address" structs:"metrics-address" env:"FISHLER_METRICS_ADDRESS"`
+	WebhookFilepath            string   `mapstructure:"webhook-file" structs:"webhook-file" env:"FISHLER_WEBHOOK_FILE"`
+	SensorUUID                 string   `mapstructure:"sensor-uuid" structs:"sensor-uuid" env:"FISHLER_SENSOR_UUID"`
+	SensorName                 string   `mapstructure:"sensor-name" structs:"sensor-name" env:"FISHLER_SENSOR_NAME"`
+	SensorTags                 []string `mapstructure:"sensor-tag" structs:"sensor-tag" env:"FISHLER_SENSOR_TAGS"`
+	GeoIPCountryFilepath       string   `mapstructure:"geoip-country-db" structs:"geoip-country-db" env:"FISHLER_GEOIP_COUNTRY_DB"`
+	GeoIPCityFilepath          string   `mapstructure:"geoip-city-db" structs:"geoip-city-db" env:"FISHLER_GEOIP_CITY_DB"`
+	GeoIPASNFilepath           string   `mapstructure:"geoip-asn-db" structs:"geoip-asn-db" env:"FISHLER_GEOIP_ASN_DB"`
+	Egress                     bool     `mapstructure:"egress" structs:"egress" env:"FISHLER_EGRESS"`
+	EgressNetwork              string   `mapstructure:"egress-network" structs:"egress-network" env:"FISHLER_EGRESS_NETWORK"`
+	EgressPort                 int      `mapstructure:"egress-port" structs:"egress-port" env:"FISHLER_EGRESS_PORT"`
+	EgressFetch                bool     `mapstructure:"egress-fetch" structs:"egress-fetch" env:"FISHLER_EGRESS_FETCH"`
+	EgressMaxSize              int64    `mapstructure:"egress-max-size" structs:"egress-max-size" env:"FISHLER_EGRESS_MAX_SIZE"`
+	EgressTLS                  bool     `mapstructure:"egress-tls" structs:"egress-tls" env:"FISHLER_EGRESS_TLS"`
+	EgressTFTPPort             int      `mapstructure:"egress-tftp-port" structs:"egress-tftp-port" env:"FISHLER_EGRESS_TFTP_PORT"`
+	SFTPContainer              bool     `mapstructure:"sftp-container" structs:"sftp-container" env:"FISHLER_SFTP_CONTAINER"`
+	SFTPSpoolLimit             int64    `mapstructure:"sftp-spool-limit" structs:"sftp-spool-limit" env:"FISHLER_SFTP_SPOOL_LIMIT"`
+	Seed                       string   `mapstructure:"seed" structs:"seed" env:"FISHLER_SEED"`
+	TokenDomain                string   `mapstructure:"token-domain" structs:"token-domain" env:"FISHLER_TOKEN_DOMAIN"`
+	ForwardEmulation           bool     `mapstructure:"forward-emulation" structs:"forward-emulation" env:"FISHLER_FORWARD_EMULATION"`
+	ForwardMaxSize             int64    `mapstructure:"forward-max-size" structs:"forward-max-size" env:"FISHLER_FORWARD_MAX_SIZE"`
+	ForwardMailMaxSize         int64    `mapstructure:"forward-mail-max-size" structs:"forward-mail-max-size" env:"FISHLER_FORWARD_MAIL_MAX_SIZE"`
+	PCAP                       bool     `mapstructure:"pcap" structs:"pcap" env:"FISHLER_PCAP"`
+	PCAPMaxSize                int64    `mapstructure:"pcap-max-size" structs:"pcap-max-size" env:"FISHLER_PCAP_MAX_SIZE"`
+	PCAPMaxSeconds             int      `mapstructure:"pcap-max-seconds" structs:"pcap-max-seconds" env:"FISHLER_PCAP_MAX_SECONDS"`
+	ShutdownTimeout            int      `mapstructure:"shutdown-timeout" structs:"shutdown-timeout" env:"FISHLER_SHUTDOWN_TIMEOUT"`
+	ProxyProtocol              bool     `mapstructure:"proxy-protocol" structs:"proxy-protocol" env:"FISHLER_PROXY_PROTOCOL"`
+	ProxyTrustedCIDRs          []string `mapstructure:"proxy-trusted-cidr" structs:"proxy-trusted-cidr" env:"FISHLER_PROXY_TRUSTED_CIDRS"`
+	AccessFilepath             string   `mapstructure:"access-file" structs:"access-file" env:"FISHLER_ACCESS_FILE"`
+	DenyCIDRs                  []string `mapstructure:"deny-cidr" structs:"deny-cidr" env:"FISHLER_DENY_CIDRS"`
+	LimitConnectionsPerMinute  int      `mapstructure:"limit-connections-per-minute" structs:"limit-connections-per-minute" env:"FISHLER_LIMIT_CONNECTIONS_PER_MINUTE"`
+	LimitSessionsPerIP         int      `mapstructure:"limit-sessions-per-ip" structs:"limit-sessions-per-ip" env:"FISHLER_LIMIT_SESSIONS_PER_IP"`
+	LimitContainers            int      `mapstructure:"limit-containers" structs:"limit-containers" env:"FISHLER_LIMIT_CONTAINERS"`
+	LimitAuthAttempts          int      `mapstructure:"limit-auth-attempts" structs:"limit-auth-attempts" env:"FISHLER_LIMIT_AUTH_ATTEMPTS"`
+	LimitAction                string   `mapstructure:"limit-action" structs:"limit-action" env:"FISHLER_LIMIT_ACTION"`
+	TarpitSeconds              int      `mapstructure:"tarpit-seconds" structs:"tarpit-seconds" env:"FISHLER_TARPIT_SECONDS"`
+	TarpitInterval             int      `mapstructure:"tarpit-interval" structs:"tarpit-interval" env:"FISHLER_TARPIT_INTERVAL"`
+	TarpitHASSH                bool     `mapstructure:"tarpit-hassh" structs:"tarpit-hassh" env:"FISHLER_TARPIT_HASSH"`
+	TarpitCIDRs                []string `mapstructure:"tarpit-cidr" structs:"tarpit-cidr" env:"FISHLER_TARPIT_CIDRS"`
 	accounts                   map[string][]string
 	passwords                  map[string]bool
 }
@@ -100,12 +205,25 @@ func CommandInit(command *cobra.Command) error {
 
 	command.PersistentFlags().Int("docker-memory-limit", initial.DockerMemoryLimit, "The amount of memory (in MB) that each container should get when a user obtains a session")
 	command.PersistentFlags().Int("docker-disk-limit", int(initial.DockerDiskLimit), "The amount of disk space (in MB) that each container should limit a container to")
+	command.PersistentFlags().Float64("docker-cpus", initial.DockerCPUs, "The number of CPUs each container may use - 0 is unlimited")
+	command.PersistentFlags().Int64("docker-pids-limit", initial.DockerPidsLimit, "The number of processes each container may run - 0 is unlimited")
+	command.PersistentFlags().StringArray("docker-ulimit", initial.DockerUlimits, "A NAME=SOFT[:HARD] ulimit applied to each container (repeatable)")
+	command.PersistentFlags().StringArray("docker-cap-drop", initial.DockerCapDrop, "A kernel capability dropped from each container (repeatable)")
+	command.PersistentFlags().StringArray("docker-cap-add", initial.DockerCapAdd, "A kernel capability added back to each container (repeatable)")
+	command.PersistentFlags().Bool("docker-no-new-privileges", initial.DockerNoNewPrivileges, "Stop container processes gaining privileges through setuid binaries")
+	command.PersistentFlags().String("docker-seccomp-profile", initial.DockerSeccompProfile, "The filepath to a seccomp profile (JSON) applied to each container - the docker default if not set")
+	command.PersistentFlags().String("docker-apparmor-profile", initial.DockerAppArmorProfile, "The name of a loaded AppArmor profile applied to each container")
+	command.PersistentFlags().Bool("docker-readonly-rootfs", initial.DockerReadonlyRootfs, "Mount each container's root filesystem read-only - the account files are then bind mounted over /etc")
+	command.PersistentFlags().StringArray("docker-readonly-path", initial.DockerReadonlyPaths, "A path mounted read-only in each container (repeatable)")
+	command.PersistentFlags().StringArray("docker-tmpfs", initial.DockerTmpfs, "A PATH[:OPTIONS] tmpfs mounted in each container, e.g. /tmp:rw,size=64m (repeatable)")
+	command.PersistentFlags().String("docker-runtime", initial.DockerRuntime, "An alternative OCI runtime for containers, e.g. runsc")
 
 	command.PersistentFlags().String("crypto-basepath", initial.CryptoBasepath, "The basepath to a directory which holds files: id_rsa/id_rsa.pub for the SSH server")
 	command.PersistentFlags().String("docker-hostname", initial.DockerHostname, "The hostname used in the docker container")
 	command.PersistentFlags().Int("port", initial.Port, "The port to listen on for SSH connections - if not set, will bind to a random high port")
 	command.PersistentFlags().String("ip", initial.IP, "The IP to listen on for SSH connections - if not set, will bind to 127.0.0.1")
 	command.PersistentFlags().String("banner", initial.Banner, "The banner the SSH server displays")
+	command.PersistentFlags().StringArray("listen", initial.Listen, "An ADDRESS[,banner=VERSION][,hostname=NAME] to listen on for SSH connections, e.g. [::]:22,banner=OpenSSH_9.6 (repeatable) - replaces ip/port")
 	command.PersistentFlags().Int("random-sleep-count", initial.RandomConnectionSleepCount, "If non-zero, sleep this at most this many seconds before allowing authentication to continue")
 
 	command.PersistentFlags().String("account-file", initial.AccountFilepath, "Exclusive: A file with a list of username/password combinations that are valid for the server (new-line delimited) in the form: username password - quote if space is present in either")
@@ -114,6 +232,57 @@ func CommandInit(command *cobra.Command) error {
 	command.PersistentFlags().String("password", initial.Password, "Exclusive: A password that is valid (any account) for the server")
 	command.PersistentFlags().Bool("any-account", initial.AnyAccount, "Any username/password combination will yield in successful authentication to the server")
 	command.PersistentFlags().Bool("no-account", initial.NoAccount, "No username/pasword combination will every yield in successful authentication to the server")
+	command.PersistentFlags().String("metrics-address", initial.MetricsAddress, "If set, expose Prometheus metrics on http://IP:PORT/metrics")
+	command.PersistentFlags().String("sensor-uuid", initial.SensorUUID, "The UUID identifying this sensor - if not set, one is generated on first run and stored under --crypto-basepath")
+	command.PersistentFlags().String("sensor-name", initial.SensorName, "The name identifying this sensor in logs and events - if not set, the hostname is used")
+	command.PersistentFlags().StringArray("sensor-tag", initial.SensorTags, "A tag attached to every log line and event from this sensor (repeatable)")
+
+	command.PersistentFlags().String("geoip-country-db", initial.GeoIPCountryFilepath, "If set, a MaxMind-format (mmdb) country database used to enrich source addresses")
+	command.PersistentFlags().String("geoip-city-db", initial.GeoIPCityFilepath, "If set, a MaxMind-format (mmdb) city database used to enrich source addresses")
+	command.PersistentFlags().String("geoip-asn-db", initial.GeoIPASNFilepath, "If set, a MaxMind-format (mmdb) ASN database used to enrich source addresses")
+
+	command.PersistentFlags().Bool("egress", initial.Egress, "Attach containers to an internal network whose only egress is a recording HTTP(S)/FTP proxy and TFTP server")
+	command.PersistentFlags().String("egress-network", initial.EgressNetwork, "The name of the internal docker network created for egress")
+	command.PersistentFlags().Int("egress-port", initial.EgressPort, "The port the egress proxy listens on at the network gateway")
+	command.PersistentFlags().Bool("egress-fetch", initial.EgressFetch, "Fetch and vault the payloads containers request - otherwise empty responses are faked")
+	command.PersistentFlags().Int64("egress-max-size", initial.EgressMaxSize, "The largest payload (in MB) the egress proxy will fetch")
+	command.PersistentFlags().Bool("egress-tls", initial.EgressTLS, "Intercept https:// tunnels with a sensor CA handed to containers - otherwise they are refused")
+	command.PersistentFlags().Int("egress-tftp-port", initial.EgressTFTPPort, "The UDP port a recording TFTP server listens on at the network gateway - 0 disables it")
+
+	command.PersistentFlags().Bool("sftp-container", initial.SFTPContainer, "Serve SFTP from the whole filesystem of a container started for the session instead of only the user's home")
+	command.PersistentFlags().Int64("sftp-spool-limit", initial.SFTPSpoolLimit, "The most (in MB) container SFTP transfers may hold on the host, across every session, while they are copied in or out of containers - 0 is unlimited")
+
+	command.PersistentFlags().String("seed", initial.Seed, "If set, a directory or tar of bait files applied to each session - home/ into the user's home, root/ into their container and users/<name>/ over those for that username")
+	command.PersistentFlags().String("token-domain", initial.TokenDomain, "The domain canary hostnames issued in seeded files are made under - watch its DNS to see them used")
+
+	command.PersistentFlags().Bool("forward-emulation", initial.ForwardEmulation, "Accept port forwards and answer them from canned responders instead of refusing - nothing is ever dialed or bound")
+	command.PersistentFlags().Int64("forward-max-size", initial.ForwardMaxSize, "The most (in MB) of each emulated forward's conversation recorded - 0 is unlimited")
+	command.PersistentFlags().Int64("forward-mail-max-size", initial.ForwardMailMaxSize, "The most (in MB) of mail the SMTP responder stores for a session - 0 is unlimited")
+
+	command.PersistentFlags().Bool("pcap", initial.PCAP, "With egress, capture each session container's traffic to <log-basepath>/pcap/<session-id>.pcap")
+	command.PersistentFlags().Int64("pcap-max-size", initial.PCAPMaxSize, "The largest (in MB) a session's pcap may grow - 0 is unlimited")
+	command.PersistentFlags().Int("pcap-max-seconds", initial.PCAPMaxSeconds, "The seconds of a session's traffic captured - 0 is unlimited")
+
+	command.PersistentFlags().Int("shutdown-timeout", initial.ShutdownTimeout, "The seconds open sessions are given to finish on shutdown before their containers are killed")
+
+	command.PersistentFlags().Bool("proxy-protocol", initial.ProxyProtocol, "Accept a PROXY protocol v1/v2 header from trusted proxies so the real client address is logged")
+	command.PersistentFlags().StringArray("proxy-trusted-cidr", initial.ProxyTrustedCIDRs, "A CIDR (or address) of a proxy whose PROXY protocol header is trusted (repeatable)")
+
+	command.PersistentFlags().String("access-file", initial.AccessFilepath, "The filepath to a yaml file of allow/deny lists checked before the SSH handshake - reloaded on SIGHUP")
+	command.PersistentFlags().StringArray("deny-cidr", initial.DenyCIDRs, "A CIDR (or address) whose connections are dropped before the SSH handshake (repeatable)")
+
+	command.PersistentFlags().Int("limit-connections-per-minute", initial.LimitConnectionsPerMinute, "If non-zero, the number of connections a single IP may open per minute")
+	command.PersistentFlags().Int("limit-sessions-per-ip", initial.LimitSessionsPerIP, "If non-zero, the number of concurrent sessions a single IP may hold")
+	command.PersistentFlags().Int("limit-containers", initial.LimitContainers, "If non-zero, the number of containers which may run at once across the sensor")
+	command.PersistentFlags().Int("limit-auth-attempts", initial.LimitAuthAttempts, "If non-zero, the number of authentication attempts allowed per connection")
+	command.PersistentFlags().String("limit-action", initial.LimitAction, "What to do with a client over a limit: reject, tarpit, or log")
+	command.PersistentFlags().Int("tarpit-seconds", initial.TarpitSeconds, "The most seconds a tarpitted client is held for - 0 holds it until it disconnects")
+	command.PersistentFlags().Int("tarpit-interval", initial.TarpitInterval, "The seconds between each byte/line written to a tarpitted client")
+	command.PersistentFlags().Bool("tarpit-hassh", initial.TarpitHASSH, "Tarpit clients with a blocklisted HASSH rather than closing the connection")
+	command.PersistentFlags().StringArray("tarpit-cidr", initial.TarpitCIDRs, "A CIDR (or address) whose clients are tarpitted on connect (repeatable)")
+
+	command.PersistentFlags().String("webhook-file", initial.WebhookFilepath, "If set, a yaml file of webhook targets (url, template, filters, rate-limit, retries) notified of matching events")
+
 	command.MarkFlagsOneRequired("account-file", "password-file", "account", "password", "any-account", "no-account")
 	command.MarkFlagsMutuallyExclusive("account-file", "password-file", "account", "password", "any-account", "no-account")
 
@@ -157,6 +326,74 @@ func (c *setting) String() string {
 	return litter.Sdump(cp)
 }
 
+// Listener is an address to accept SSH connections on along with the banner and container hostname
+// presented to clients connecting to it
+type Listener struct {
+	Address  string
+	Banner   string
+	Hostname string
+}
+
+// Network picks tcp4/tcp6 for a literal IP and dual-stack tcp for a wildcard or named host
+func (l Listener) Network() string {
+	host, _, _ := net.SplitHostPort(l.Address)
+
+	ip := net.ParseIP(host)
+
+	switch {
+	case ip == nil || (ip.IsUnspecified() && strings.Contains(host, ":")):
+		return "tcp"
+	case ip.To4() != nil:
+		return "tcp4"
+	default:
+		return "tcp6"
+	}
+}
+
+// Listeners returns the configured listen addresses - without any the ip and port are used
+func (c *setting) Listeners() ([]Listener, error) {
+	if len(c.Listen) == 0 {
+		return []Listener{{
+			Address:  net.JoinHostPort(c.IP, fmt.Sprint(c.Port)),
+			Banner:   c.Banner,
+			Hostname: c.DockerHostname,
+		}}, nil
+	}
+
+	listeners := make([]Listener, 0, len(c.Listen))
+
+	for _, spec := range c.Listen {
+		parts := strings.Split(spec, ",")
+
+		l := Listener{
+			Address:  strings.TrimSpace(parts[0]),
+			Banner:   c.Banner,
+			Hostname: c.DockerHostname,
+		}
+
+		if _, _, err := net.SplitHostPort(l.Address); err != nil {
+			return nil, fmt.Errorf("listen %q: %w", spec, err)
+		}
+
+		for _, option := range parts[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+
+			switch key {
+			case "banner":
+				l.Banner = value
+			case "hostname":
+				l.Hostname = value
+			default:
+				return nil, fmt.Errorf("listen %q: unknown option %q - expected banner or hostname", spec, key)
+			}
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
+
 func (c *setting) Authenticate(username, password string) bool {
 	if c.NoAccount {
 		return false
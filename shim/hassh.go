@@ -143,6 +143,7 @@ func calculateHASSH(kex, ciphers, macs, compression []string) string {
 type HASSHConnectionWrapper struct {
 	net.Conn
 	OnCapture   func(*HASSHInfo) bool
+	OnBlock     func(net.Conn) // handed the connection of a blocked client before it is closed
 	Buffer      []byte
 	Captured    bool
 	VersionRead bool
@@ -213,6 +214,10 @@ func (c *HASSHConnectionWrapper) parse() {
 
 				if c.OnCapture != nil {
 					if c.OnCapture(info) {
+						if c.OnBlock != nil {
+							c.OnBlock(c.Conn)
+						}
+
 						_ = c.Close()
 					}
 				}
@@ -0,0 +1,159 @@
+package access
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/spf13/viper"
+
+	"github.com/archimoebius/fishler/util/cidr"
+)
+
+// Action is what happens to a client matched by a list
+type Action string
+
+const (
+	ActionAllow             Action = "allow"              // accepted - once any allow list exists everyone else is dropped
+	ActionDrop              Action = "drop"               // closed before the SSH handshake
+	ActionTag               Action = "tag"                // accepted with the list name attached to its events
+	ActionNeverAuthenticate Action = "never-authenticate" // accepted but every authentication attempt fails
+)
+
+// Config is a named list as read from the access file
+type Config struct {
+	Name   string   `mapstructure:"name"`
+	Action Action   `mapstructure:"action"`
+	CIDRs  []string `mapstructure:"cidrs"`
+	Files  []string `mapstructure:"files"` // one CIDR or address per line
+}
+
+// Decision is the outcome of checking an address - List is empty when no list matched
+type Decision struct {
+	List   string
+	Action Action
+}
+
+type list struct {
+	Config
+	networks cidr.List
+}
+
+// Rules are the allow/deny lists checked in order - the first matching list decides
+type Rules struct {
+	path  string
+	deny  []string
+	mu    sync.RWMutex
+	lists []list
+	allow bool
+}
+
+// Load reads the lists from the yaml file at path (if any) and appends a drop list of the inline deny CIDRs
+//
+//	lists:
+//	  - name: scanners
+//	    action: tag
+//	    cidrs: [192.0.2.0/24]
+//	  - name: noisy
+//	    action: drop
+//	    files: [/etc/fishler/noisy.txt]
+func Load(path string, deny []string) (*Rules, error) {
+	r := &Rules{
+		path: path,
+		deny: deny,
+	}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Reload re-reads the access file and every list file - on error the current lists are kept
+func (r *Rules) Reload() error {
+	var configs []Config
+
+	if r.path != "" {
+		v := viper.New()
+		v.SetConfigFile(r.path)
+
+		if err := v.ReadInConfig(); err != nil {
+			return err
+		}
+
+		if err := v.UnmarshalKey("lists", &configs); err != nil {
+			return err
+		}
+	}
+
+	if len(r.deny) > 0 {
+		configs = append(configs, Config{
+			Name:   "deny",
+			Action: ActionDrop,
+			CIDRs:  r.deny,
+		})
+	}
+
+	lists := make([]list, 0, len(configs))
+	allow := false
+
+	for idx, cfg := range configs {
+		l, err := newList(cfg)
+		if err != nil {
+			return fmt.Errorf("access list %d (%s): %w", idx, cfg.Name, err)
+		}
+
+		allow = allow || l.Action == ActionAllow
+		lists = append(lists, l)
+	}
+
+	r.mu.Lock()
+	r.lists = lists
+	r.allow = allow
+	r.mu.Unlock()
+
+	return nil
+}
+
+func newList(cfg Config) (list, error) {
+	switch cfg.Action {
+	case ActionAllow, ActionDrop, ActionTag, ActionNeverAuthenticate:
+	default:
+		return list{}, fmt.Errorf("unknown action %q - expected one of allow, drop, tag, never-authenticate", cfg.Action)
+	}
+
+	networks, err := cidr.Parse(cfg.CIDRs)
+	if err != nil {
+		return list{}, err
+	}
+
+	for _, path := range cfg.Files {
+		fromFile, err := cidr.ReadFile(path)
+		if err != nil {
+			return list{}, err
+		}
+
+		networks = append(networks, fromFile...)
+	}
+
+	return list{Config: cfg, networks: networks}, nil
+}
+
+// Check returns the decision of the first list containing addr
+func (r *Rules) Check(addr net.Addr) Decision {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, l := range r.lists {
+		if l.networks.Contains(addr) {
+			return Decision{List: l.Name, Action: l.Action}
+		}
+	}
+
+	if r.allow {
+		return Decision{Action: ActionDrop}
+	}
+
+	return Decision{}
+}
@@ -0,0 +1,94 @@
+package access
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func addr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 22}
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.yaml")
+	noisy := filepath.Join(dir, "noisy.txt")
+
+	if err := os.WriteFile(noisy, []byte("198.51.100.0/24\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := "lists:\n" +
+		"  - name: scanners\n    action: tag\n    cidrs: [192.0.2.0/24]\n" +
+		"  - name: monitoring\n    action: never-authenticate\n    cidrs: [192.0.2.10, 203.0.113.0/24]\n" +
+		"  - name: noisy\n    action: drop\n    files: [" + noisy + "]\n"
+
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := Load(path, []string{"2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for ip, expected := range map[string]Decision{
+		"192.0.2.10":   {List: "scanners", Action: ActionTag}, // first match wins
+		"203.0.113.5":  {List: "monitoring", Action: ActionNeverAuthenticate},
+		"198.51.100.1": {List: "noisy", Action: ActionDrop},
+		"2001:db8::1":  {List: "deny", Action: ActionDrop},
+		"10.0.0.1":     {},
+	} {
+		if got := rules.Check(addr(ip)); got != expected {
+			t.Fatalf("%s: expected %+v got %+v", ip, expected, got)
+		}
+	}
+
+	// reloading picks up the new file contents
+	if err := os.WriteFile(noisy, []byte("10.0.0.0/8\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rules.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := rules.Check(addr("10.0.0.1")); got.List != "noisy" {
+		t.Fatalf("expected reloaded list to match got %+v", got)
+	}
+}
+
+func TestAllowList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.yaml")
+
+	if err := os.WriteFile(path, []byte("lists:\n  - name: lab\n    action: allow\n    cidrs: [192.0.2.0/24]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := Load(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := rules.Check(addr("192.0.2.1")); got.Action != ActionAllow {
+		t.Fatalf("expected allow got %+v", got)
+	}
+
+	if got := rules.Check(addr("10.0.0.1")); got.Action != ActionDrop {
+		t.Fatalf("expected addresses outside the allow list to be dropped got %+v", got)
+	}
+}
+
+func TestUnknownAction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.yaml")
+
+	if err := os.WriteFile(path, []byte("lists:\n  - name: x\n    action: explode\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(path, nil); err == nil {
+		t.Fatal("expected an unknown action to fail")
+	}
+}
@@ -0,0 +1,108 @@
+package cidr
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// List is a set of networks addresses are matched against
+type List []*net.IPNet
+
+// Parse parses each CIDR - a bare address is treated as a single host
+func Parse(cidrs []string) (List, error) {
+	list := make(List, 0, len(cidrs))
+
+	for _, cidr := range cidrs {
+		if ip := net.ParseIP(cidr); ip != nil {
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+
+			list = append(list, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("cidr %q: %w", cidr, err)
+		}
+
+		list = append(list, network)
+	}
+
+	return list, nil
+}
+
+// ReadFile parses a file of one CIDR or address per line - blank lines and # comments are skipped
+func ReadFile(path string) (List, error) {
+	file, err := os.Open(path) // #nosec
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var cidrs []string
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+
+		if line = strings.TrimSpace(line); line != "" {
+			cidrs = append(cidrs, line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	list, err := Parse(cidrs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return list, nil
+}
+
+// IP returns the IP of addr or nil if it has none
+func IP(addr net.Addr) net.IP {
+	if addr == nil {
+		return nil
+	}
+
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+
+	return net.ParseIP(host)
+}
+
+// Contains reports whether the IP of addr falls within any of the networks
+func (l List) Contains(addr net.Addr) bool {
+	ip := IP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range l {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
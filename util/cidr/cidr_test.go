@@ -0,0 +1,62 @@
+package cidr
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestContains(t *testing.T) {
+	list, err := Parse([]string{"198.51.100.0/24", "203.0.113.7", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for addr, expected := range map[string]bool{
+		"198.51.100.20": true,
+		"203.0.113.7":   true,
+		"203.0.113.8":   false,
+		"2001:db8::1":   true,
+		"2001:db9::1":   false,
+	} {
+		if got := list.Contains(&net.TCPAddr{IP: net.ParseIP(addr)}); got != expected {
+			t.Fatalf("%s: expected %v got %v", addr, expected, got)
+		}
+	}
+
+	if _, err := Parse([]string{"not-a-cidr"}); err == nil {
+		t.Fatal("expected an invalid cidr to fail")
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list")
+
+	if err := os.WriteFile(path, []byte("# scanners\n192.0.2.0/24\n\n203.0.113.7 # monitoring\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 2 || !list.Contains(&net.TCPAddr{IP: net.ParseIP("203.0.113.7")}) {
+		t.Fatalf("unexpected list %v", list)
+	}
+}
+
+func TestIP(t *testing.T) {
+	if ip := IP(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}); ip.String() != "192.0.2.1" {
+		t.Fatalf("unexpected ip %s", ip)
+	}
+
+	if ip := IP(&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 53}); ip.String() != "2001:db8::1" {
+		t.Fatalf("unexpected ip %s", ip)
+	}
+
+	if ip := IP(nil); ip != nil {
+		t.Fatalf("expected no ip got %s", ip)
+	}
+}
@@ -0,0 +1,197 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/sirupsen/logrus"
+
+	"github.com/archimoebius/fishler/util/metrics"
+)
+
+// ExecError is returned when a command run in a session container exits non-zero
+type ExecError struct {
+	ExitCode int
+	Stderr   string
+}
+
+func (e *ExecError) Error() string {
+	return fmt.Sprintf("exit status %d: %s", e.ExitCode, strings.TrimSpace(e.Stderr))
+}
+
+// SessionContainer is a container kept idle for a session so commands can be run in it on its behalf
+type SessionContainer struct {
+	client   *client.Client
+	ID       string
+	User     string
+	execUser string
+	profile  string
+	started  bool
+	stopKill func() bool
+}
+
+// StartSessionContainer starts an idle container for user (who logged in with password) with their home at hostVolumnWorkingDir mounted
+// at dockerVolumnWorkingDir and the seed tar (if any) unpacked at / - cancelling ctx, or Close, kills it
+func StartSessionContainer(ctx context.Context, name string, user string, password string, hostVolumnWorkingDir string, dockerVolumnWorkingDir string, createCfg *container.Config, hostCfg *container.HostConfig, networkCfg *network.NetworkingConfig, seed []byte) (*SessionContainer, error) {
+	dockerClient, err := client.NewClientWithOpts(
+		client.FromEnv,
+		client.WithAPIVersionNegotiation(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = BuildFishler(dockerClient, ctx, false)
+	if err != nil {
+		_ = dockerClient.Close()
+		return nil, err
+	}
+
+	hostCfg.Mounts = append(hostCfg.Mounts, mount.Mount{
+		ReadOnly: false,
+		Type:     mount.TypeBind,
+		Source:   hostVolumnWorkingDir,
+		Target:   dockerVolumnWorkingDir,
+	})
+
+	// the image's entrypoint is an interactive shell - keep the container alive without one
+	createCfg.Entrypoint = []string{"tail"}
+	createCfg.Cmd = []string{"-f", "/dev/null"}
+
+	profile, err := profileDir(name)
+	if err != nil {
+		_ = dockerClient.Close()
+		return nil, err
+	}
+
+	if err := mountProfile(profile, user, createCfg, hostCfg); err != nil {
+		_ = dockerClient.Close()
+		_ = os.RemoveAll(profile)
+		return nil, err
+	}
+
+	createResponse, err := dockerClient.ContainerCreate(ctx, createCfg, hostCfg, networkCfg, nil, name)
+	if err != nil {
+		_ = dockerClient.Close()
+		_ = os.RemoveAll(profile)
+		return nil, err
+	}
+
+	c := &SessionContainer{
+		client:   dockerClient,
+		ID:       createResponse.ID,
+		User:     user,
+		execUser: createCfg.User,
+		profile:  profile,
+	}
+
+	c.stopKill = context.AfterFunc(ctx, func() {
+		Logger.WithField("container", c.ID).Info("killing container for shutdown")
+		_ = dockerClient.ContainerKill(context.Background(), c.ID, "")
+	})
+
+	if err := copyProfile(ctx, dockerClient, c.ID, user, password, hostCfg, profile); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	if err := copySeed(ctx, dockerClient, c.ID, seed, hostCfg); err != nil {
+		Logger.WithError(err).Error("failed to copy seed into container")
+	}
+
+	if err := dockerClient.ContainerStart(ctx, c.ID, container.StartOptions{}); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	if err := runFixme(ctx, dockerClient, c.ID, user); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	metrics.ContainersActive.Inc()
+	c.started = true
+
+	return c, nil
+}
+
+// Exec runs cmd as the session's user feeding it stdin (if not nil) and copying its output to stdout
+func (c *SessionContainer) Exec(ctx context.Context, cmd []string, stdin io.Reader, stdout io.Writer) error {
+	execResponse, err := c.client.ContainerExecCreate(ctx, c.ID, container.ExecOptions{
+		User:         c.execUser,
+		AttachStdin:  stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          cmd,
+	})
+	if err != nil {
+		return err
+	}
+
+	hijackedResponse, err := c.client.ContainerExecAttach(ctx, execResponse.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return err
+	}
+	defer hijackedResponse.Close()
+
+	if stdin != nil {
+		go func() {
+			_, _ = io.Copy(hijackedResponse.Conn, stdin)
+			_ = hijackedResponse.CloseWrite()
+		}()
+	}
+
+	if stdout == nil {
+		stdout = io.Discard
+	}
+
+	var stderr bytes.Buffer
+
+	if _, err := stdcopy.StdCopy(stdout, &stderr, hijackedResponse.Reader); err != nil {
+		return err
+	}
+
+	inspect, err := c.client.ContainerExecInspect(ctx, execResponse.ID)
+	if err != nil {
+		return err
+	}
+
+	if inspect.ExitCode != 0 {
+		return &ExecError{ExitCode: inspect.ExitCode, Stderr: stderr.String()}
+	}
+
+	return nil
+}
+
+// Close removes the container
+func (c *SessionContainer) Close() {
+	defer c.client.Close()
+	defer os.RemoveAll(c.profile)
+
+	if c.started {
+		metrics.ContainersActive.Dec()
+	}
+
+	// already killed if ctx was cancelled
+	if !c.stopKill() {
+		return
+	}
+
+	err := c.client.ContainerRemove(context.Background(), c.ID, container.RemoveOptions{Force: true})
+	if err != nil && !cerrdefs.IsNotFound(err) {
+		Logger.WithFields(logrus.Fields{
+			"container": c.ID,
+			"error":     err,
+		}).Error("failed to kill session container")
+	}
+}
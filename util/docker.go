@@ -1,29 +1,243 @@
 package util
 
 import (
+	"archive/tar"
 	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
 	config "github.com/archimoebius/fishler/cli/config/root"
+	"github.com/archimoebius/fishler/util/metrics"
 	"github.com/ccoveille/go-safecast/v2"
 	"github.com/charmbracelet/ssh"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
 
 var ErrorContainerNameNotFound = errors.New("container name not found")
 
-func CreateRunWaitSSHContainer(hostVolumnWorkingDir string, createCfg *container.Config, hostCfg *container.HostConfig, networkCfg *network.NetworkingConfig, sshSession ssh.Session) (exitCode int64, err error) {
+// ContainerLabel marks every session container with the UUID of the sensor that started it so its
+// orphans can be found after a crash without touching those of other sensors sharing the daemon
+const ContainerLabel = "fishler"
+
+// RemoveOrphanedContainers force removes session containers left running by a previous instance of
+// the sensor
+func RemoveOrphanedContainers(ctx context.Context, sensor uuid.UUID) (int, error) {
+	dockerClient, err := client.NewClientWithOpts(
+		client.FromEnv,
+		client.WithAPIVersionNegotiation(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer dockerClient.Close()
+
+	containers, err := dockerClient.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", ContainerLabel+"="+sensor.String())),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+
+	for _, orphan := range containers {
+		err := dockerClient.ContainerRemove(ctx, orphan.ID, container.RemoveOptions{Force: true})
+		if err != nil {
+			Logger.WithFields(logrus.Fields{
+				"container": orphan.ID,
+				"error":     err,
+			}).Error("failed to remove orphaned container")
+			continue
+		}
+
+		removed++
+	}
+
+	return removed, nil
+}
+
+// profileDir is where the profile of the named session container is written when it is bind mounted
+// rather than copied in
+func profileDir(name string) (string, error) {
+	return filepath.Abs(filepath.Join(config.Setting.LogBasepath, "profile", name))
+}
+
+// mountProfile prepares dir to hold the profile and bind mounts its files over the container's
+// /etc/passwd, group and shadow - docker refuses to copy into a read-only root filesystem. The
+// container runs as the profile's uid as docker resolves a user name against the image's own
+// /etc/passwd, which lacks them. Nothing is done for a writable root filesystem.
+func mountProfile(dir string, user string, createCfg *container.Config, hostCfg *container.HostConfig) error {
+	if !hostCfg.ReadonlyRootfs {
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+
+	for _, name := range []string{"group", "passwd", "shadow"} {
+		// filled in by copyProfile once the image's distro is known
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_WRONLY, 0600) // #nosec
+		if err != nil {
+			return err
+		}
+		_ = f.Close()
+
+		hostCfg.Mounts = append(hostCfg.Mounts, mount.Mount{
+			ReadOnly: true,
+			Type:     mount.TypeBind,
+			Source:   filepath.Join(dir, name),
+			Target:   "/etc/" + name,
+		})
+	}
+
+	createCfg.User = ProfileUser(user)
+
+	return nil
+}
+
+// copyProfile installs the user's passwd, group and shadow - modelled on the image's distro - into the
+// container's /etc, or into the directory mountProfile bind mounted there
+func copyProfile(ctx context.Context, dockerClient *client.Client, containerID string, user string, password string, hostCfg *container.HostConfig, dir string) error {
+	secret, err := GetProfileSecret()
+	if err != nil {
+		return err
+	}
+
+	profile := Profile{
+		Distro:   imageDistro(ctx, dockerClient, containerID),
+		User:     user,
+		Password: password,
+		Secret:   secret,
+	}
+
+	if hostCfg.ReadonlyRootfs {
+		return WriteProfile(dir, profile)
+	}
+
+	profiletarbuffer, err := GetProfileBuffer(profile)
+	if err != nil {
+		return err
+	}
+
+	return dockerClient.CopyToContainer(ctx, containerID, "/etc/", bytes.NewReader(profiletarbuffer), container.CopyToContainerOptions{
+		AllowOverwriteDirWithFile: true,
+		CopyUIDGID:                false,
+	})
+}
+
+// imageDistro reads the os-release of the (created) container's image - on Debian /etc/os-release is a
+// link so /usr/lib/os-release is tried too
+func imageDistro(ctx context.Context, dockerClient *client.Client, containerID string) Distro {
+	for _, p := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		reader, _, err := dockerClient.CopyFromContainer(ctx, containerID, p)
+		if err != nil {
+			continue
+		}
+
+		tr := tar.NewReader(reader)
+
+		header, err := tr.Next()
+		if err != nil || header.Typeflag != tar.TypeReg {
+			_ = reader.Close()
+			continue
+		}
+
+		osRelease, err := io.ReadAll(io.LimitReader(tr, 64*1024))
+		_ = reader.Close()
+
+		if err == nil {
+			return ParseOSRelease(string(osRelease))
+		}
+	}
+
+	return DistroAlpine
+}
+
+// copySeed unpacks the session's seed tar at the container's / - a nil seed copies nothing
+func copySeed(ctx context.Context, dockerClient *client.Client, containerID string, seed []byte, hostCfg *container.HostConfig) error {
+	if seed == nil || hostCfg.ReadonlyRootfs {
+		return nil
+	}
+
+	return dockerClient.CopyToContainer(ctx, containerID, "/", bytes.NewReader(seed), container.CopyToContainerOptions{
+		AllowOverwriteDirWithFile: false,
+		CopyUIDGID:                false,
+	})
+}
+
+// containerAddress returns the IPv4 address of the (running) container on the named network
+func containerAddress(ctx context.Context, dockerClient *client.Client, containerID string, name string) string {
+	inspect, err := dockerClient.ContainerInspect(ctx, containerID)
+	if err != nil || inspect.NetworkSettings == nil {
+		return ""
+	}
+
+	if endpoint, ok := inspect.NetworkSettings.Networks[name]; ok && endpoint != nil {
+		return endpoint.IPAddress
+	}
+
+	return ""
+}
+
+// runFixme runs the image's one-shot /fixme which tidies up the container for user once it has started
+func runFixme(ctx context.Context, dockerClient *client.Client, containerID string, user string) error {
+	execResponse, err := dockerClient.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		User:         "root",
+		Tty:          true,
+		AttachStdin:  false,
+		AttachStderr: false,
+		AttachStdout: false,
+		Cmd:          []string{"/fixme", user},
+	})
+	if err != nil {
+		return err
+	}
+
+	hijackedResponse, err := dockerClient.ContainerExecAttach(
+		ctx,
+		execResponse.ID,
+		container.ExecAttachOptions{
+			Detach: false,
+			Tty:    true,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if config.Setting.Debug {
+		Logger.Info("Container Exec Init Output: ")
+		b := make([]byte, 1)
+		for {
+			_, err := hijackedResponse.Reader.Read(b)
+			fmt.Printf("%s", b)
+			if err == io.EOF {
+				break
+			}
+		}
+	}
+	hijackedResponse.Close()
+
+	return nil
+}
+
+// CreateRunWaitSSHContainer runs a session container attached to sshSession until it exits - cancelling ctx kills it.
+// started (if not nil) is handed the container's address on its network once it is running.
+func CreateRunWaitSSHContainer(ctx context.Context, hostVolumnWorkingDir string, createCfg *container.Config, hostCfg *container.HostConfig, networkCfg *network.NetworkingConfig, seed []byte, sshSession ssh.Session, started func(ip string)) (exitCode int64, err error) {
 	var dockerVolumnWorkingDir = fmt.Sprintf("/home/%s", sshSession.User())
 
 	if sshSession.User() == "root" {
@@ -39,8 +253,6 @@ func CreateRunWaitSSHContainer(hostVolumnWorkingDir string, createCfg *container
 	}
 	defer dockerClient.Close()
 
-	ctx := context.Background()
-
 	err = BuildFishler(dockerClient, ctx, false)
 	if err != nil {
 		panic(err)
@@ -58,6 +270,19 @@ func CreateRunWaitSSHContainer(hostVolumnWorkingDir string, createCfg *container
 	containerName := sshSession.Context().SessionID()
 	Logger.Debugf("Requesting container: %s\n", containerName)
 
+	profile, err := profileDir(containerName)
+	if err != nil {
+		return exitCode, err
+	}
+	defer os.RemoveAll(profile)
+
+	if err := mountProfile(profile, sshSession.User(), createCfg, hostCfg); err != nil {
+		Logger.Error(err)
+		return exitCode, err
+	}
+
+	startedAt := time.Now()
+
 	createResponse, e := dockerClient.ContainerCreate(ctx, createCfg, hostCfg, networkCfg, nil, containerName)
 	if e != nil {
 		Logger.Error(e)
@@ -67,7 +292,14 @@ func CreateRunWaitSSHContainer(hostVolumnWorkingDir string, createCfg *container
 	containerID := createResponse.ID
 	Logger.Debugf("Created ContainerID: %s\n", containerID)
 
-	defer dockerClient.ContainerKill(ctx, containerID, "")
+	// the session context may already be cancelled by the time the kill runs
+	defer dockerClient.ContainerKill(context.Background(), containerID, "")
+
+	stopKill := context.AfterFunc(ctx, func() {
+		Logger.WithField("container", containerID).Info("killing container for shutdown")
+		_ = dockerClient.ContainerKill(context.Background(), containerID, "")
+	})
+	defer stopKill()
 
 	dockerStream, err := dockerClient.ContainerAttach(
 		ctx,
@@ -85,19 +317,17 @@ func CreateRunWaitSSHContainer(hostVolumnWorkingDir string, createCfg *container
 		return exitCode, err
 	}
 
-	profiletarbuffer, e := GetProfileBuffer(sshSession.User())
+	password, _ := sshSession.Context().Value(ContextKeyPassword).(string)
+
+	e = copyProfile(ctx, dockerClient, containerID, sshSession.User(), password, hostCfg, profile)
 	if e != nil {
 		Logger.Error(e)
 		return exitCode, e
 	}
 
-	e = dockerClient.CopyToContainer(ctx, containerID, "/etc/", bytes.NewReader(profiletarbuffer), container.CopyToContainerOptions{
-		AllowOverwriteDirWithFile: true,
-		CopyUIDGID:                false,
-	})
-	if e != nil {
-		Logger.Error(e)
-		return exitCode, e
+	// a seed which can't be copied shouldn't cost the session
+	if e := copySeed(ctx, dockerClient, containerID, seed, hostCfg); e != nil {
+		Logger.WithError(e).Error("failed to copy seed into container")
 	}
 
 	e = dockerClient.ContainerStart(ctx, containerID, container.StartOptions{})
@@ -106,43 +336,22 @@ func CreateRunWaitSSHContainer(hostVolumnWorkingDir string, createCfg *container
 		return exitCode, e
 	}
 
-	execResponse, e := dockerClient.ContainerExecCreate(ctx, containerID, container.ExecOptions{
-		User:         "root",
-		Tty:          true,
-		AttachStdin:  false,
-		AttachStderr: false,
-		AttachStdout: false,
-		Cmd:          []string{"/fixme", sshSession.User()},
-	})
-	if e != nil {
-		Logger.Error(e)
-		return exitCode, e
+	metrics.ContainersActive.Inc()
+	defer metrics.ContainersActive.Dec()
+
+	if started != nil {
+		if ip := containerAddress(ctx, dockerClient, containerID, string(hostCfg.NetworkMode)); ip != "" {
+			started(ip)
+		}
 	}
 
-	hijackedResponse, e := dockerClient.ContainerExecAttach(
-		ctx,
-		execResponse.ID,
-		container.ExecAttachOptions{
-			Detach: false,
-			Tty:    true,
-		},
-	)
+	e = runFixme(ctx, dockerClient, containerID, sshSession.User())
 	if e != nil {
 		Logger.Error(e)
 		return exitCode, e
 	}
-	if config.Setting.Debug {
-		Logger.Info("Container Exec Init Output: ")
-		b := make([]byte, 1)
-		for {
-			_, err := hijackedResponse.Reader.Read(b)
-			fmt.Printf("%s", b)
-			if err == io.EOF {
-				break
-			}
-		}
-	}
-	hijackedResponse.Close()
+
+	metrics.ContainerStartSeconds.Observe(time.Since(startedAt).Seconds())
 
 	basepath := fmt.Sprintf("/%s/session/", config.Setting.LogBasepath)
 
@@ -164,7 +373,10 @@ func CreateRunWaitSSHContainer(hostVolumnWorkingDir string, createCfg *container
 		Logger.Error(err)
 		return exitCode, err
 	}
-	defer f.Close()
+	defer func() {
+		_ = f.Sync()
+		_ = f.Close()
+	}()
 
 	mw := io.MultiWriter(sshSession, f)
 
@@ -251,9 +463,12 @@ func CreateRunWaitSSHContainer(hostVolumnWorkingDir string, createCfg *container
 
 	wg.Wait()
 
-	time.Sleep(time.Minute * 10)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Minute * 10):
+	}
 
-	resultC, errC := dockerClient.ContainerWait(ctx, containerID, container.WaitConditionNotRunning)
+	resultC, errC := dockerClient.ContainerWait(context.Background(), containerID, container.WaitConditionNotRunning)
 
 	select {
 	case err = <-errC:
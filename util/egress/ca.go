@@ -0,0 +1,176 @@
+package egress
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// maxLeafs bounds the certificates kept - the cache starts over once it is reached
+const maxLeafs = 1024
+
+// Files of the CA in the directory it is loaded from
+const (
+	CACertificateFile = "egress-ca.pem"
+	CAKeyFile         = "egress-ca.key"
+)
+
+// CA signs the certificates the proxy presents for the https:// tunnels it intercepts - containers
+// are handed its certificate so their clients trust what the proxy relays
+type CA struct {
+	Path string // the PEM certificate handed to containers
+
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+
+	mu    sync.Mutex
+	leafs map[string]*tls.Certificate
+}
+
+// LoadCA loads the CA kept in dir - generating it on first use
+func LoadCA(dir string) (*CA, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	ca := &CA{
+		Path:  filepath.Join(dir, CACertificateFile),
+		leafs: map[string]*tls.Certificate{},
+	}
+
+	keyPath := filepath.Join(dir, CAKeyFile)
+
+	pair, err := tls.LoadX509KeyPair(ca.Path, keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := generateCA(ca.Path, keyPath); err != nil {
+			return nil, err
+		}
+
+		pair, err = tls.LoadX509KeyPair(ca.Path, keyPath)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("egress CA key is not ECDSA")
+	}
+
+	ca.key = key
+	ca.certificate, err = x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return ca, nil
+}
+
+func generateCA(certificatePath string, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber(),
+		Subject:               pkix.Name{CommonName: "Root CA", Organization: []string{"Internal"}},
+		NotBefore:             time.Now().Add(-24 * time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+
+	// readable by the containers it is bind mounted into
+	return os.WriteFile(certificatePath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644) // #nosec G306
+}
+
+func serialNumber() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return big.NewInt(time.Now().UnixNano())
+	}
+
+	return serial
+}
+
+// Certificate returns a certificate for host signed by the CA - made on first use and kept
+func (ca *CA) Certificate(host string) (*tls.Certificate, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if leaf, ok := ca.leafs[host]; ok && time.Now().Before(leaf.Leaf.NotAfter) {
+		return leaf, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serialNumber(),
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-24 * time.Hour),
+		NotAfter:     time.Now().AddDate(0, 3, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	certificate := &tls.Certificate{
+		Certificate: [][]byte{der, ca.certificate.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+
+	if len(ca.leafs) >= maxLeafs {
+		clear(ca.leafs)
+	}
+
+	ca.leafs[host] = certificate
+
+	return certificate, nil
+}
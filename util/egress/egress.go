@@ -0,0 +1,327 @@
+package egress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+
+	"github.com/archimoebius/fishler/util/vault"
+)
+
+const fetchTimeout = time.Minute
+
+// ErrForbiddenAddress is a destination the proxy won't connect to as it would reach the sensor, its
+// cloud metadata service or the networks behind it rather than the internet
+var ErrForbiddenAddress = errors.New("destination is not a public address")
+
+// reservedNetworks are the ranges not covered by the net.IP predicates Public checks
+var reservedNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),     // "this" network
+	mustParseCIDR("100.64.0.0/10"), // carrier-grade NAT
+	mustParseCIDR("192.0.0.0/24"),  // IETF protocol assignments
+	mustParseCIDR("198.18.0.0/15"), // benchmarking
+	mustParseCIDR("240.0.0.0/4"),   // reserved, and the broadcast address
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return network
+}
+
+// Public reports whether ip is an internet address - loopback, link-local, private, carrier-grade
+// NAT, multicast, unspecified and reserved addresses are not
+func Public(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsPrivate() || ip.IsUnspecified() {
+		return false
+	}
+
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// What the proxy did with a request
+const (
+	ActionFetched = "fetched" // retrieved from the internet, vaulted and relayed
+	ActionFaked   = "faked"   // answered with an empty response
+	ActionBlocked = "blocked" // refused
+	ActionFailed  = "failed"  // the fetch failed
+)
+
+// Request is the record of one request a session container made through the proxy
+type Request struct {
+	Timestamp time.Time `json:"timestamp"`
+	SessionID string    `json:"session_id"`
+	Source    string    `json:"source"`
+	Method    string    `json:"method"`
+	URL       string    `json:"url"`
+	UserAgent string    `json:"user_agent"`
+	Action    string    `json:"action"`
+	Status    int       `json:"status"`
+	SHA256    string    `json:"sha256,omitempty"`
+	Size      int64     `json:"size"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Proxy is the only egress session containers have - an HTTP proxy which records every request and
+// fetches and vaults GET/HEAD payloads over HTTP(S) and FTP (or fakes a response) while refusing the
+// rest. With a CA https:// tunnels are intercepted, otherwise they are refused.
+type Proxy struct {
+	Vault   *vault.Vault
+	Fetch   bool  // fetch payloads - otherwise every request is faked
+	MaxSize int64 // bytes - zero is unlimited
+	CA      *CA
+
+	Resolve func(ip string) string // maps a container address to its session id
+	Record  func(Request)
+
+	client *http.Client
+	allow  func(ip net.IP) bool // the destinations which may be dialled - Public outside of tests
+}
+
+func New(v *vault.Vault, fetch bool, maxSize int64) *Proxy {
+	p := &Proxy{
+		Vault:   v,
+		Fetch:   fetch,
+		MaxSize: maxSize,
+		allow:   Public,
+	}
+
+	dialer := p.dialer()
+
+	p.client = &http.Client{
+		Timeout: fetchTimeout,
+		// never chain through a proxy from the environment
+		Transport: &http.Transport{Proxy: nil, DialContext: dialer.DialContext},
+	}
+
+	return p
+}
+
+// dialer connects to public addresses only - the check is made on the address actually dialled, after
+// name resolution, so neither a redirect nor a name resolving to an internal address gets past it
+func (p *Proxy) dialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			if ip := net.ParseIP(host); !p.allow(ip) {
+				return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+			}
+
+			return nil
+		},
+	}
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	request := Request{
+		Timestamp: time.Now(),
+		Method:    r.Method,
+		URL:       r.URL.String(),
+		UserAgent: r.UserAgent(),
+	}
+
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		request.Source = host
+
+		if p.Resolve != nil {
+			request.SessionID = p.Resolve(host)
+		}
+	}
+
+	switch {
+	case r.Method == http.MethodConnect && p.CA != nil:
+		request.URL = "https://" + r.Host
+
+		// the requests made through the tunnel are recorded as they are served
+		if !p.intercept(w, r, &request) {
+			return
+		}
+	case r.Method == http.MethodConnect:
+		// tunnelled traffic can't be inspected or vaulted without the CA
+		request.URL = "https://" + r.Host
+		p.refuse(w, &request, http.StatusForbidden)
+	case !r.URL.IsAbs():
+		p.refuse(w, &request, http.StatusBadRequest)
+	case r.Method != http.MethodGet && r.Method != http.MethodHead:
+		// anything else could be used against a third party - record it and play along
+		p.fake(w, &request)
+	case !p.Fetch:
+		p.fake(w, &request)
+	case r.URL.Scheme == "http", r.URL.Scheme == "https":
+		p.fetchHTTP(w, r, &request)
+	case r.URL.Scheme == "ftp":
+		p.fetchFTP(w, r, &request)
+	default:
+		p.refuse(w, &request, http.StatusForbidden)
+	}
+
+	if p.Record != nil {
+		p.Record(request)
+	}
+}
+
+func (p *Proxy) refuse(w http.ResponseWriter, request *Request, status int) {
+	request.Action = ActionBlocked
+	request.Status = status
+
+	http.Error(w, http.StatusText(status), status)
+}
+
+func (p *Proxy) fake(w http.ResponseWriter, request *Request) {
+	request.Action = ActionFaked
+	request.Status = http.StatusOK
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (p *Proxy) fail(w http.ResponseWriter, request *Request, err error) {
+	if errors.Is(err, ErrForbiddenAddress) {
+		request.Error = err.Error()
+		p.refuse(w, request, http.StatusForbidden)
+		return
+	}
+
+	request.Action = ActionFailed
+	request.Status = http.StatusBadGateway
+	request.Error = err.Error()
+
+	http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+}
+
+// hopHeaders are not forwarded - see RFC 9110 section 7.6.1
+var hopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Proxy-Connection",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+func (p *Proxy) fetchHTTP(w http.ResponseWriter, r *http.Request, request *Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), fetchTimeout)
+	defer cancel()
+
+	upstream, err := http.NewRequestWithContext(ctx, r.Method, r.URL.String(), nil)
+	if err != nil {
+		p.fail(w, request, err)
+		return
+	}
+
+	upstream.Header = r.Header.Clone()
+	for _, header := range hopHeaders {
+		upstream.Header.Del(header)
+	}
+
+	resp, err := p.client.Do(upstream) // #nosec
+	if err != nil {
+		p.fail(w, request, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, header := range []string{"Content-Type", "Content-Disposition", "Last-Modified", "Etag"} {
+		if value := resp.Header.Get(header); value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+
+	p.relay(w, resp.Body, resp.StatusCode, request)
+}
+
+func (p *Proxy) fetchFTP(w http.ResponseWriter, r *http.Request, request *Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), fetchTimeout)
+	defer cancel()
+
+	host := r.URL.Host
+	if r.URL.Port() == "" {
+		host = net.JoinHostPort(r.URL.Hostname(), "21")
+	}
+
+	// the data connections of passive mode are made with the same dialer
+	conn, err := ftp.Dial(host, ftp.DialWithContext(ctx), ftp.DialWithDialer(*p.dialer()))
+	if err != nil {
+		p.fail(w, request, err)
+		return
+	}
+	defer conn.Quit()
+
+	username, password := "anonymous", "anonymous@"
+	if r.URL.User != nil {
+		username = r.URL.User.Username()
+		password, _ = r.URL.User.Password()
+	}
+
+	if err := conn.Login(username, password); err != nil {
+		p.fail(w, request, err)
+		return
+	}
+
+	path, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/"))
+	if err != nil {
+		p.fail(w, request, err)
+		return
+	}
+
+	resp, err := conn.Retr(path)
+	if err != nil {
+		p.fail(w, request, err)
+		return
+	}
+	defer resp.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	p.relay(w, resp, http.StatusOK, request)
+}
+
+// relay vaults body then serves the vaulted copy to the container
+func (p *Proxy) relay(w http.ResponseWriter, body io.Reader, status int, request *Request) {
+	sum, size, err := p.Vault.Store(body, p.MaxSize)
+	request.Size = size
+
+	if err != nil {
+		if errors.Is(err, vault.ErrTooLarge) {
+			err = errors.New("payload exceeds the size limit")
+		}
+
+		p.fail(w, request, err)
+		return
+	}
+
+	request.Action = ActionFetched
+	request.Status = status
+	request.SHA256 = sum
+
+	payload, err := os.Open(p.Vault.Path(sum))
+	if err != nil {
+		p.fail(w, request, err)
+		return
+	}
+	defer payload.Close()
+
+	w.WriteHeader(status)
+	_, _ = io.Copy(w, payload)
+}
@@ -0,0 +1,336 @@
+package egress
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/archimoebius/fishler/util/vault"
+)
+
+func newProxy(t *testing.T, fetch bool) (*Proxy, *[]Request, *http.Client) {
+	t.Helper()
+
+	v, err := vault.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var recorded []Request
+
+	p := New(v, fetch, 1024)
+	// the test origins listen on loopback
+	p.allow = func(net.IP) bool { return true }
+	p.Resolve = func(ip string) string { return "session-" + ip }
+	p.Record = func(r Request) { recorded = append(recorded, r) }
+
+	server := httptest.NewServer(p)
+	t.Cleanup(server.Close)
+
+	proxyURL, _ := url.Parse(server.URL)
+
+	return p, &recorded, &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+}
+
+func TestFetchVaults(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "#!/bin/sh\necho pwned\n")
+	}))
+	defer origin.Close()
+
+	p, recorded, client := newProxy(t, true)
+
+	resp, err := client.Get(origin.URL + "/bot.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	if string(body) != "#!/bin/sh\necho pwned\n" {
+		t.Fatalf("unexpected body %q", body)
+	}
+
+	if len(*recorded) != 1 {
+		t.Fatalf("expected one recorded request got %d", len(*recorded))
+	}
+
+	request := (*recorded)[0]
+	if request.Action != ActionFetched || request.SessionID != "session-127.0.0.1" || request.SHA256 == "" {
+		t.Fatalf("unexpected record %+v", request)
+	}
+
+	if vaulted, err := os.ReadFile(p.Vault.Path(request.SHA256)); err != nil || string(vaulted) != string(body) {
+		t.Fatalf("expected the payload to be vaulted got %q (%v)", vaulted, err)
+	}
+}
+
+func TestFakeAndBlock(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("origin should never be contacted")
+	}))
+	defer origin.Close()
+
+	_, recorded, client := newProxy(t, true)
+
+	resp, err := client.Post(origin.URL+"/c2", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || (*recorded)[0].Action != ActionFaked {
+		t.Fatalf("expected a POST to be faked got %d %+v", resp.StatusCode, (*recorded)[0])
+	}
+
+	if _, err := client.Get("https://example.invalid/"); err == nil {
+		t.Fatal("expected a CONNECT to be refused")
+	}
+
+	if (*recorded)[1].Action != ActionBlocked {
+		t.Fatalf("expected a CONNECT to be blocked got %+v", (*recorded)[1])
+	}
+}
+
+func TestTooLarge(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(make([]byte, 4096))
+	}))
+	defer origin.Close()
+
+	_, recorded, client := newProxy(t, true)
+
+	resp, err := client.Get(origin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadGateway || (*recorded)[0].Action != ActionFailed {
+		t.Fatalf("expected an oversized payload to fail got %d %+v", resp.StatusCode, (*recorded)[0])
+	}
+}
+
+func TestInternalDestinationsRefused(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("origin should never be contacted")
+	}))
+	defer origin.Close()
+
+	p, recorded, client := newProxy(t, true)
+	p.allow = Public
+
+	_, port, _ := net.SplitHostPort(origin.Listener.Addr().String())
+
+	for _, target := range []string{
+		"http://127.0.0.1:" + port + "/",
+		"http://169.254.169.254/latest/meta-data/iam/security-credentials/",
+		"http://localhost:" + port + "/",
+	} {
+		resp, err := client.Get(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("expected %s to be refused got %d", target, resp.StatusCode)
+		}
+
+		request := (*recorded)[len(*recorded)-1]
+		if request.Action != ActionBlocked || request.Error == "" {
+			t.Fatalf("expected %s to be recorded as blocked got %+v", target, request)
+		}
+	}
+
+	// a public origin redirecting inward is stopped at the dial too
+	if err := p.dialer().Control("tcp4", "10.0.0.1:80", nil); !errors.Is(err, ErrForbiddenAddress) {
+		t.Fatalf("expected a private address to be refused got %v", err)
+	}
+}
+
+func TestPublic(t *testing.T) {
+	for address, expected := range map[string]bool{
+		"93.184.215.14":    true,
+		"2606:2800::1":     true,
+		"127.0.0.1":        false,
+		"169.254.169.254":  false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"100.64.0.1":       false,
+		"224.0.0.1":        false,
+		"0.0.0.0":          false,
+		"255.255.255.255":  false,
+		"::1":              false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"::ffff:127.0.0.1": false,
+	} {
+		if got := Public(net.ParseIP(address)); got != expected {
+			t.Fatalf("expected Public(%s) to be %v", address, expected)
+		}
+	}
+}
+
+func TestInterceptTLS(t *testing.T) {
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "#!/bin/sh\necho tls\n")
+	}))
+	defer origin.Close()
+
+	v, err := vault.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca, err := LoadCA(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tunnels are recorded once they close so the records are awaited
+	recorded := make(chan Request, 2)
+
+	p := New(v, true, 1024)
+	p.CA = ca
+	p.allow = func(net.IP) bool { return true }
+	p.Resolve = func(ip string) string { return "session-" + ip }
+	p.Record = func(r Request) { recorded <- r }
+
+	// the proxy trusts the origin, the container trusts the proxy's CA
+	p.client.Transport.(*http.Transport).TLSClientConfig = origin.Client().Transport.(*http.Transport).TLSClientConfig
+
+	server := httptest.NewServer(p)
+	defer server.Close()
+
+	pem, err := os.ReadFile(ca.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(pem)
+
+	proxyURL, _ := url.Parse(server.URL)
+	transport := &http.Transport{Proxy: http.ProxyURL(proxyURL), TLSClientConfig: &tls.Config{RootCAs: roots}}
+
+	resp, err := (&http.Client{Transport: transport}).Get(origin.URL + "/bot.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	if string(body) != "#!/bin/sh\necho tls\n" {
+		t.Fatalf("unexpected body %q", body)
+	}
+
+	request := <-recorded
+	if request.Action != ActionFetched || request.URL != origin.URL+"/bot.sh" || request.SessionID != "session-127.0.0.1" {
+		t.Fatalf("unexpected record %+v", request)
+	}
+
+	// a client which doesn't trust the CA is recorded as failing the tunnel
+	transport = &http.Transport{Proxy: http.ProxyURL(proxyURL)}
+
+	if _, err := (&http.Client{Transport: transport}).Get(origin.URL + "/pinned"); err == nil {
+		t.Fatal("expected an untrusted certificate to be refused")
+	}
+
+	if request := <-recorded; request.Action != ActionFailed || request.Method != http.MethodConnect {
+		t.Fatalf("expected the failed tunnel to be recorded got %+v", request)
+	}
+}
+
+func TestTFTP(t *testing.T) {
+	v, err := vault.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorded := make(chan Request, 2)
+
+	p := New(v, true, 4096)
+	p.Record = func(r Request) { recorded <- r }
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = p.ServeTFTP(ctx, conn)
+	}()
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// exchange sends packet to addr and returns the answer along with where it came from
+	exchange := func(packet []byte, addr net.Addr) ([]byte, net.Addr) {
+		t.Helper()
+
+		if _, err := client.WriteTo(packet, addr); err != nil {
+			t.Fatal(err)
+		}
+
+		_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		answer := make([]byte, 1024)
+
+		n, from, err := client.ReadFrom(answer)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return answer[:n], from
+	}
+
+	data, server := exchange([]byte("\x00\x01bot.sh\x00octet\x00"), conn.LocalAddr())
+	if !bytes.Equal(data, []byte{0, tftpDATA, 0, 1}) {
+		t.Fatalf("expected an empty file got %v", data)
+	}
+
+	if _, err := client.WriteTo(tftpPacket(tftpACK, 1, nil), server); err != nil {
+		t.Fatal(err)
+	}
+
+	if request := <-recorded; request.Method != "RRQ" || request.Action != ActionFaked || !strings.HasSuffix(request.URL, "/bot.sh") {
+		t.Fatalf("unexpected record %+v", request)
+	}
+
+	ack, server := exchange([]byte("\x00\x02loot.tar\x00octet\x00"), conn.LocalAddr())
+	if !bytes.Equal(ack, tftpPacket(tftpACK, 0, nil)) {
+		t.Fatalf("expected the write to be accepted got %v", ack)
+	}
+
+	if ack, _ := exchange(tftpPacket(tftpDATA, 1, []byte("exfiltrated")), server); !bytes.Equal(ack, tftpPacket(tftpACK, 1, nil)) {
+		t.Fatalf("expected the block to be acknowledged got %v", ack)
+	}
+
+	request := <-recorded
+	if request.Method != "WRQ" || request.Action != ActionFetched || request.Size != int64(len("exfiltrated")) {
+		t.Fatalf("unexpected record %+v", request)
+	}
+
+	if vaulted, err := os.ReadFile(v.Path(request.SHA256)); err != nil || string(vaulted) != "exfiltrated" {
+		t.Fatalf("expected the upload to be vaulted got %q (%v)", vaulted, err)
+	}
+}
@@ -0,0 +1,146 @@
+package egress
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const handshakeTimeout = 10 * time.Second
+
+// intercept answers the CONNECT r then terminates the TLS tunnel with a certificate from the CA,
+// serving the requests made through it like any other - it reports whether the tunnel failed, which
+// leaves request to be recorded
+func (p *Proxy) intercept(w http.ResponseWriter, r *http.Request, request *Request) bool {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		p.refuse(w, request, http.StatusInternalServerError)
+		return true
+	}
+
+	conn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		p.refuse(w, request, http.StatusInternalServerError)
+		return true
+	}
+
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		_ = conn.Close()
+
+		request.Action = ActionFailed
+		request.Error = err.Error()
+		return true
+	}
+
+	target := r.URL.Hostname()
+
+	tlsConn := tls.Server(&bufferedConn{Conn: conn, reader: buffered.Reader}, &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName != "" {
+				return p.CA.Certificate(hello.ServerName)
+			}
+
+			return p.CA.Certificate(target)
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+
+	// a client which pins its certificates or ships its own CAs gives up here
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		_ = tlsConn.Close()
+
+		request.Action = ActionFailed
+		request.Status = http.StatusOK
+		request.Error = err.Error()
+		return true
+	}
+
+	host := strings.TrimSuffix(r.Host, ":443")
+
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, inner *http.Request) {
+			// the tunnel decides where the request goes whatever its Host header says
+			inner.URL.Scheme = "https"
+			inner.URL.Host = host
+
+			p.ServeHTTP(w, inner)
+		}),
+		ReadHeaderTimeout: handshakeTimeout,
+		IdleTimeout:       fetchTimeout,
+	}
+
+	_ = server.Serve(newConnListener(tlsConn))
+
+	return false
+}
+
+// bufferedConn reads what the proxy's server had already buffered before reading the connection
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// connListener accepts a single connection then blocks until it has been closed
+type connListener struct {
+	conn   net.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newConnListener(conn net.Conn) *connListener {
+	l := &connListener{closed: make(chan struct{})}
+	l.conn = &notifyConn{Conn: conn, closed: l.closed}
+
+	return l
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	var conn net.Conn
+
+	l.once.Do(func() {
+		conn = l.conn
+	})
+
+	if conn != nil {
+		return conn, nil
+	}
+
+	<-l.closed
+
+	return nil, net.ErrClosed
+}
+
+func (l *connListener) Close() error {
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// notifyConn closes closed when it is first closed
+type notifyConn struct {
+	net.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (c *notifyConn) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+	})
+
+	return c.Conn.Close()
+}
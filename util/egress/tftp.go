@@ -0,0 +1,258 @@
+package egress
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/archimoebius/fishler/util/vault"
+)
+
+// TFTP opcodes - see RFC 1350
+const (
+	tftpRRQ   = 1
+	tftpWRQ   = 2
+	tftpDATA  = 3
+	tftpACK   = 4
+	tftpERROR = 5
+)
+
+const (
+	tftpBlockSize = 512
+	tftpTimeout   = 2 * time.Second
+	tftpRetries   = 3
+	tftpDiskFull  = 3
+)
+
+// ServeTFTP answers the TFTP requests containers send to conn until ctx is done. There is nothing to
+// fetch from as a TFTP request names no host beyond the gateway it was sent to, so a read (RRQ) is
+// recorded and answered with an empty file while a write (WRQ) is received into the vault.
+func (p *Proxy) ServeTFTP(ctx context.Context, conn net.PacketConn) error {
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+	defer stop()
+
+	packet := make([]byte, 2048)
+
+	for {
+		n, addr, err := conn.ReadFrom(packet)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		opcode, filename, ok := parseTFTPRequest(packet[:n])
+		if !ok {
+			continue
+		}
+
+		go p.transferTFTP(conn.LocalAddr(), addr, opcode, filename)
+	}
+}
+
+// parseTFTPRequest returns the opcode and filename of an RRQ or WRQ
+func parseTFTPRequest(packet []byte) (uint16, string, bool) {
+	if len(packet) < 4 {
+		return 0, "", false
+	}
+
+	opcode := binary.BigEndian.Uint16(packet)
+	if opcode != tftpRRQ && opcode != tftpWRQ {
+		return 0, "", false
+	}
+
+	filename, _, ok := bytes.Cut(packet[2:], []byte{0})
+	if !ok || len(filename) == 0 {
+		return 0, "", false
+	}
+
+	return opcode, string(filename), true
+}
+
+// transferTFTP serves one request from a port of its own, as TFTP expects
+func (p *Proxy) transferTFTP(local net.Addr, remote net.Addr, opcode uint16, filename string) {
+	request := Request{
+		Timestamp: time.Now(),
+		Method:    "RRQ",
+		URL:       "tftp://" + local.String() + "/" + strings.TrimPrefix(filename, "/"),
+	}
+
+	if opcode == tftpWRQ {
+		request.Method = "WRQ"
+	}
+
+	if host, _, err := net.SplitHostPort(remote.String()); err == nil {
+		request.Source = host
+
+		if p.Resolve != nil {
+			request.SessionID = p.Resolve(host)
+		}
+	}
+
+	defer func() {
+		if p.Record != nil {
+			p.Record(request)
+		}
+	}()
+
+	localHost, _, _ := net.SplitHostPort(local.String())
+
+	conn, err := net.ListenPacket("udp", net.JoinHostPort(localHost, "0"))
+	if err != nil {
+		request.Action = ActionFailed
+		request.Error = err.Error()
+		return
+	}
+	defer conn.Close()
+
+	if opcode == tftpRRQ {
+		request.Action = ActionFaked
+
+		// a single short block is an empty file
+		if err := exchangeTFTP(conn, remote, tftpPacket(tftpDATA, 1, nil), 1); err != nil {
+			request.Error = err.Error()
+		}
+
+		return
+	}
+
+	p.receiveTFTP(conn, remote, &request)
+}
+
+// receiveTFTP acknowledges the blocks of a write, storing what is sent in the vault
+func (p *Proxy) receiveTFTP(conn net.PacketConn, remote net.Addr, request *Request) {
+	reader, writer := io.Pipe()
+
+	type stored struct {
+		sum  string
+		size int64
+		err  error
+	}
+
+	result := make(chan stored, 1)
+
+	go func() {
+		sum, size, err := p.Vault.Store(reader, p.MaxSize)
+		// stop the transfer should the vault give up early
+		_ = reader.CloseWithError(err)
+		result <- stored{sum, size, err}
+	}()
+
+	err := func() error {
+		ack := tftpPacket(tftpACK, 0, nil)
+		packet := make([]byte, 4+tftpBlockSize)
+
+		for block := uint16(1); ; block++ {
+			n, err := readTFTP(conn, remote, ack, packet, tftpDATA, block)
+			if err != nil {
+				return err
+			}
+
+			if _, err := writer.Write(packet[4:n]); err != nil {
+				_, _ = conn.WriteTo(tftpError(tftpDiskFull, "Disk full or allocation exceeded"), remote)
+				return err
+			}
+
+			ack = tftpPacket(tftpACK, block, nil)
+
+			if n-4 < tftpBlockSize {
+				_, err := conn.WriteTo(ack, remote)
+				return err
+			}
+		}
+	}()
+
+	_ = writer.CloseWithError(err)
+
+	r := <-result
+	request.Size = r.size
+
+	if err == nil {
+		err = r.err
+	}
+
+	if err != nil {
+		if errors.Is(err, vault.ErrTooLarge) {
+			err = errors.New("payload exceeds the size limit")
+		}
+
+		request.Action = ActionFailed
+		request.Error = err.Error()
+		return
+	}
+
+	request.Action = ActionFetched
+	request.SHA256 = r.sum
+}
+
+// exchangeTFTP sends packet until remote acknowledges block
+func exchangeTFTP(conn net.PacketConn, remote net.Addr, packet []byte, block uint16) error {
+	_, err := readTFTP(conn, remote, packet, make([]byte, 4+tftpBlockSize), tftpACK, block)
+	return err
+}
+
+// readTFTP sends packet, resending it on timeout, until remote answers with the expected opcode and
+// block - the answer is read into buffer and its length returned
+func readTFTP(conn net.PacketConn, remote net.Addr, packet []byte, buffer []byte, opcode uint16, block uint16) (int, error) {
+	for range tftpRetries {
+		if _, err := conn.WriteTo(packet, remote); err != nil {
+			return 0, err
+		}
+
+		deadline := time.Now().Add(tftpTimeout)
+
+		for {
+			if err := conn.SetReadDeadline(deadline); err != nil {
+				return 0, err
+			}
+
+			n, addr, err := conn.ReadFrom(buffer)
+
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+
+			if err != nil {
+				return 0, err
+			}
+
+			// strays from anyone else or of earlier blocks are ignored
+			if addr.String() != remote.String() || n < 4 {
+				continue
+			}
+
+			switch binary.BigEndian.Uint16(buffer) {
+			case tftpERROR:
+				return 0, errors.New("tftp client error: " + string(bytes.TrimRight(buffer[4:n], "\x00")))
+			case opcode:
+				if binary.BigEndian.Uint16(buffer[2:]) == block {
+					return n, nil
+				}
+			}
+		}
+	}
+
+	return 0, errors.New("tftp client timed out")
+}
+
+func tftpPacket(opcode uint16, block uint16, data []byte) []byte {
+	packet := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint16(packet, opcode)
+	binary.BigEndian.PutUint16(packet[2:], block)
+
+	return append(packet, data...)
+}
+
+func tftpError(code uint16, message string) []byte {
+	return append(tftpPacket(tftpERROR, code, []byte(message)), 0)
+}
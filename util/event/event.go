@@ -0,0 +1,52 @@
+package event
+
+import (
+	"net"
+	"time"
+)
+
+// Kind identifies a step in an attacker's lifecycle
+type Kind string
+
+const (
+	KindHASSH        Kind = "hassh"
+	KindAuth         Kind = "auth"
+	KindSessionStart Kind = "session.start"
+	KindSessionEnd   Kind = "session.end"
+	KindExec         Kind = "exec"
+	KindPortForward  Kind = "port-forward"
+	KindUpload       Kind = "sftp.upload"
+	KindEgress       Kind = "egress"
+	KindMail         Kind = "smtp.message"
+	KindTransfer     Kind = "sftp.transfer"
+)
+
+// Authentication methods as reported in Event.AuthMethod
+const (
+	AuthMethodPassword            = "password"
+	AuthMethodPublicKey           = "publickey"
+	AuthMethodKeyboardInteractive = "keyboard-interactive"
+)
+
+// Event is something of note which happened on a connection
+type Event struct {
+	Kind          Kind
+	Timestamp     time.Time
+	SessionID     string
+	RemoteAddr    net.Addr
+	Username      string
+	Password      string
+	AuthMethod    string
+	ClientVersion string
+	HASSH         string
+	Fields        map[string]string
+}
+
+// New creates an event of kind stamped with the current time
+func New(kind Kind) *Event {
+	return &Event{
+		Kind:      kind,
+		Timestamp: time.Now(),
+		Fields:    make(map[string]string),
+	}
+}
@@ -0,0 +1,118 @@
+package forward
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// httpMaxLine bounds the request line and each header line
+	httpMaxLine = 8 * 1024
+	// httpMaxHeader bounds the header lines of a request together
+	httpMaxHeader = 64 * 1024
+)
+
+// ErrLineTooLong is a line longer than a responder will buffer - the client gets no further, however
+// much it sends, without memory growing with it
+var ErrLineTooLong = errors.New("line too long")
+
+// Responder plays the far end of a forwarded connection until the client goes away - it never connects out
+type Responder struct {
+	Name  string
+	Serve func(rw io.ReadWriter) error
+}
+
+// Select picks a responder for the destination - messages sent to an SMTP port are handed to store
+func Select(host string, port uint32, store MessageFunc) Responder {
+	switch port {
+	// 465 expects TLS from the first byte so is left to the sink
+	case 25, 587, 2525:
+		return SMTP(host, store)
+	case 80, 8000, 8080, 8888:
+		return HTTP
+	default:
+		return Sink
+	}
+}
+
+// Sink accepts and discards everything sent to it
+var Sink = Responder{
+	Name: "sink",
+	Serve: func(rw io.ReadWriter) error {
+		_, err := io.Copy(io.Discard, rw)
+		return err
+	},
+}
+
+// HTTP answers every request with an empty 200
+var HTTP = Responder{
+	Name: "http",
+	Serve: func(rw io.ReadWriter) error {
+		reader := bufio.NewReaderSize(rw, httpMaxLine)
+
+		for {
+			if _, err := readLine(reader); err != nil {
+				return ignoreEOF(err)
+			}
+
+			var size int
+			var closing bool
+
+			for {
+				line, err := readLine(reader)
+				if err != nil {
+					return ignoreEOF(err)
+				}
+
+				if line == "" {
+					break
+				}
+
+				if size += len(line); size > httpMaxHeader {
+					return ErrLineTooLong
+				}
+
+				name, value, _ := strings.Cut(line, ":")
+
+				if strings.EqualFold(strings.TrimSpace(name), "Connection") && strings.EqualFold(strings.TrimSpace(value), "close") {
+					closing = true
+				}
+			}
+
+			// bodies aren't parsed - whatever is sent is read, and recorded, as the next request
+			_, err := fmt.Fprintf(rw, "HTTP/1.1 200 OK\r\nServer: nginx\r\nContent-Type: text/html\r\nContent-Length: 0\r\n\r\n")
+			if err != nil {
+				return err
+			}
+
+			if closing {
+				return nil
+			}
+		}
+	},
+}
+
+// readLine reads a line, without its line ending, failing with ErrLineTooLong once it fills r's buffer
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", ErrLineTooLong
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+func ignoreEOF(err error) error {
+	if err == io.EOF {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,99 @@
+package forward
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// conversation is a client which sends input and collects what it is sent
+type conversation struct {
+	input io.Reader
+	bytes.Buffer
+}
+
+func (c *conversation) Read(p []byte) (int, error) {
+	return c.input.Read(p)
+}
+
+func TestHTTPResponder(t *testing.T) {
+	client := &conversation{input: strings.NewReader("GET / HTTP/1.1\r\nHost: example.com\r\n\r\nGET /a HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")}
+
+	if err := HTTP.Serve(client); err != nil {
+		t.Fatal(err)
+	}
+
+	if count := strings.Count(client.String(), "HTTP/1.1 200 OK"); count != 2 {
+		t.Fatalf("expected two responses got %d: %q", count, client.String())
+	}
+}
+
+// endless is a client which sends the same byte forever
+type endless struct {
+	bytes.Buffer
+}
+
+func (e *endless) Read(p []byte) (int, error) {
+	for idx := range p {
+		p[idx] = 'x'
+	}
+
+	return len(p), nil
+}
+
+func TestHTTPResponderLineTooLong(t *testing.T) {
+	client := &endless{}
+
+	if err := HTTP.Serve(client); err != ErrLineTooLong {
+		t.Fatalf("expected the endless line to be refused got %v", err)
+	}
+
+	if client.Len() != 0 {
+		t.Fatalf("expected no response got %q", client.String())
+	}
+}
+
+func TestTranscript(t *testing.T) {
+	client := &conversation{input: strings.NewReader("EHLO spam\r\nQUIT\r\n")}
+
+	var log bytes.Buffer
+	transcript := NewTranscript(client, &log, 1024)
+
+	if err := SMTP("mail.example.com", nil).Serve(transcript); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"--- client", "EHLO spam", "--- fishler", "220 ", "221 "} {
+		if !strings.Contains(log.String(), expected) {
+			t.Fatalf("expected %q in transcript %q", expected, log.String())
+		}
+	}
+
+	if transcript.BytesIn != int64(len("EHLO spam\r\nQUIT\r\n")) {
+		t.Fatalf("unexpected bytes in %d", transcript.BytesIn)
+	}
+}
+
+func TestTranscriptLimit(t *testing.T) {
+	client := &conversation{input: strings.NewReader(strings.Repeat("x", 100))}
+
+	var log bytes.Buffer
+	transcript := NewTranscript(client, &log, 10)
+
+	if err := Sink.Serve(transcript); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Count(log.String(), "x") != 10 || transcript.BytesIn != 100 {
+		t.Fatalf("expected 10 of 100 bytes recorded got %q (%d)", log.String(), transcript.BytesIn)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	for port, expected := range map[uint32]string{25: "smtp", 587: "smtp", 465: "sink", 80: "http", 6667: "sink"} {
+		if got := Select("example.com", port, nil).Name; got != expected {
+			t.Fatalf("port %d: expected %s got %s", port, expected, got)
+		}
+	}
+}
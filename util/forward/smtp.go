@@ -0,0 +1,341 @@
+package forward
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+const (
+	// smtpMaxSize is the largest message accepted (and advertised with SIZE)
+	smtpMaxSize = 10 * 1024 * 1024
+	// smtpMaxRecipients is how many RCPT TO a transaction may carry
+	smtpMaxRecipients = 1000
+	// smtpMaxLine is the longest command line, its CRLF included (RFC 5321 4.5.3.1.4)
+	smtpMaxLine = 512
+	// smtpMaxAuthLine is the longest AUTH exchange line - credentials can take more than a command
+	smtpMaxAuthLine = 2048
+)
+
+// Message is a mail transaction accepted by the SMTP responder - it is never relayed
+type Message struct {
+	Hostname   string
+	Helo       string
+	Username   string
+	Password   string
+	From       string
+	To         []string
+	Data       []byte
+	ReceivedAt time.Time
+	QueueID    string
+}
+
+// MessageFunc stores a message accepted by the SMTP responder
+type MessageFunc func(Message) error
+
+// ErrInsufficientStorage is returned by a MessageFunc with no room left for the message - the client is
+// told to try again later rather than that the write failed
+var ErrInsufficientStorage = errors.New("insufficient storage")
+
+// EML renders the message with its envelope prepended as headers
+func (m Message) EML() []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "Return-Path: <%s>\r\n", m.From)
+	fmt.Fprintf(&b, "Received: from %s by %s with ESMTP id %s; %s\r\n", m.Helo, m.Hostname, m.QueueID, m.ReceivedAt.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "X-Fishler-Helo: %s\r\n", m.Helo)
+
+	if m.Username != "" {
+		fmt.Fprintf(&b, "X-Fishler-Auth: %s\r\n", m.Username)
+	}
+
+	fmt.Fprintf(&b, "X-Fishler-Mail-From: %s\r\n", m.From)
+
+	for _, to := range m.To {
+		fmt.Fprintf(&b, "X-Fishler-Rcpt-To: %s\r\n", to)
+	}
+
+	b.Write(m.Data)
+
+	return b.Bytes()
+}
+
+// SMTP plays a mail server named hostname which accepts every transaction, handing each message to store
+func SMTP(hostname string, store MessageFunc) Responder {
+	return Responder{
+		Name: "smtp",
+		Serve: func(rw io.ReadWriter) error {
+			session := &smtpSession{
+				hostname: hostname,
+				store:    store,
+				w:        rw,
+			}
+
+			session.buffer = bufio.NewReaderSize(rw, smtpMaxAuthLine)
+			session.reader = textproto.NewReader(session.buffer)
+
+			return ignoreEOF(session.serve())
+		},
+	}
+}
+
+type smtpSession struct {
+	hostname string
+	store    MessageFunc
+	buffer   *bufio.Reader
+	reader   *textproto.Reader // over buffer - for the DATA of a message
+	w        io.Writer
+
+	message Message
+	mail    bool
+	count   int
+}
+
+func (s *smtpSession) reply(format string, args ...any) error {
+	_, err := fmt.Fprintf(s.w, format+"\r\n", args...)
+	return err
+}
+
+// readLine reads a line of at most limit bytes - a longer one is read to its end, without being
+// buffered, and fails with ErrLineTooLong
+func (s *smtpSession) readLine(limit int) (string, error) {
+	line, err := readLine(s.buffer)
+
+	if errors.Is(err, ErrLineTooLong) {
+		for {
+			if _, err := s.buffer.ReadSlice('\n'); !errors.Is(err, bufio.ErrBufferFull) {
+				if err != nil {
+					return "", err
+				}
+
+				return "", ErrLineTooLong
+			}
+		}
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(line)+2 > limit {
+		return "", ErrLineTooLong
+	}
+
+	return line, nil
+}
+
+func (s *smtpSession) reset() {
+	s.message = Message{
+		Hostname: s.hostname,
+		Helo:     s.message.Helo,
+		Username: s.message.Username,
+		Password: s.message.Password,
+	}
+	s.mail = false
+}
+
+func (s *smtpSession) serve() error {
+	s.reset()
+
+	if err := s.reply("220 %s ESMTP Postfix", s.hostname); err != nil {
+		return err
+	}
+
+	for {
+		// AUTH may carry the initial response on its command line
+		line, err := s.readLine(smtpMaxAuthLine)
+		verb, argument, _ := strings.Cut(strings.TrimSpace(line), " ")
+		argument = strings.TrimSpace(argument)
+
+		if err == nil && len(line)+2 > smtpMaxLine && !strings.EqualFold(verb, "AUTH") {
+			err = ErrLineTooLong
+		}
+
+		if errors.Is(err, ErrLineTooLong) {
+			if err := s.reply("500 5.5.0 Error: line too long"); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			s.message.Helo = argument
+			err = s.reply("250 %s", s.hostname)
+		case "EHLO":
+			s.message.Helo = argument
+			err = s.reply("250-%s\r\n250-PIPELINING\r\n250-SIZE %d\r\n250-AUTH PLAIN LOGIN\r\n250-8BITMIME\r\n250 SMTPUTF8", s.hostname, smtpMaxSize)
+		case "AUTH":
+			err = s.auth(argument)
+		case "MAIL":
+			s.reset()
+			s.message.From = smtpPath(argument, "FROM:")
+			s.mail = true
+			err = s.reply("250 2.1.0 Ok")
+		case "RCPT":
+			switch {
+			case !s.mail:
+				err = s.reply("503 5.5.1 Error: need MAIL command")
+			case len(s.message.To) >= smtpMaxRecipients:
+				err = s.reply("452 4.5.3 Error: too many recipients")
+			default:
+				s.message.To = append(s.message.To, smtpPath(argument, "TO:"))
+				err = s.reply("250 2.1.5 Ok")
+			}
+		case "DATA":
+			err = s.data()
+		case "RSET":
+			s.reset()
+			err = s.reply("250 2.0.0 Ok")
+		case "NOOP":
+			err = s.reply("250 2.0.0 Ok")
+		case "VRFY":
+			err = s.reply("252 2.0.0 %s", argument)
+		case "QUIT":
+			_ = s.reply("221 2.0.0 Bye")
+			return nil
+		default:
+			err = s.reply("502 5.5.2 Error: command not recognized")
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// auth accepts any PLAIN or LOGIN credentials, keeping them with the messages which follow
+func (s *smtpSession) auth(argument string) error {
+	mechanism, initial, _ := strings.Cut(argument, " ")
+
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		if initial == "" {
+			if err := s.reply("334 "); err != nil {
+				return err
+			}
+
+			line, err := s.readLine(smtpMaxAuthLine)
+			if errors.Is(err, ErrLineTooLong) {
+				return s.reply("500 5.5.6 Authentication exchange line is too long")
+			}
+
+			if err != nil {
+				return err
+			}
+
+			initial = line
+		}
+
+		// authorization identity, authentication identity and password separated by NUL
+		decoded, _ := base64.StdEncoding.DecodeString(strings.TrimSpace(initial))
+		if parts := strings.SplitN(string(decoded), "\x00", 3); len(parts) == 3 {
+			s.message.Username = parts[1]
+			s.message.Password = parts[2]
+		}
+	case "LOGIN":
+		var answers []string
+
+		for _, prompt := range []string{"Username:", "Password:"} {
+			if err := s.reply("334 %s", base64.StdEncoding.EncodeToString([]byte(prompt))); err != nil {
+				return err
+			}
+
+			line, err := s.readLine(smtpMaxAuthLine)
+			if errors.Is(err, ErrLineTooLong) {
+				return s.reply("500 5.5.6 Authentication exchange line is too long")
+			}
+
+			if err != nil {
+				return err
+			}
+
+			decoded, _ := base64.StdEncoding.DecodeString(strings.TrimSpace(line))
+			answers = append(answers, string(decoded))
+		}
+
+		s.message.Username = answers[0]
+		s.message.Password = answers[1]
+	default:
+		return s.reply("504 5.5.4 Unrecognized authentication type")
+	}
+
+	return s.reply("235 2.7.0 Authentication successful")
+}
+
+func (s *smtpSession) data() error {
+	if len(s.message.To) == 0 {
+		return s.reply("503 5.5.1 Error: need RCPT command")
+	}
+
+	if err := s.reply("354 End data with <CR><LF>.<CR><LF>"); err != nil {
+		return err
+	}
+
+	dot := s.reader.DotReader()
+
+	data, err := io.ReadAll(io.LimitReader(dot, smtpMaxSize+1))
+	if err != nil {
+		return err
+	}
+
+	if len(data) > smtpMaxSize {
+		if _, err := io.Copy(io.Discard, dot); err != nil {
+			return err
+		}
+
+		s.reset()
+
+		return s.reply("552 5.3.4 Error: message file too big")
+	}
+
+	s.count++
+	s.message.Data = data
+	s.message.ReceivedAt = time.Now().UTC()
+	s.message.QueueID = fmt.Sprintf("%X%02d", s.message.ReceivedAt.UnixNano()&0xFFFFFFFFFF, s.count%100)
+
+	if s.store != nil {
+		if err := s.store(s.message); err != nil {
+			s.reset()
+
+			if errors.Is(err, ErrInsufficientStorage) {
+				return s.reply("452 4.3.1 Insufficient system storage")
+			}
+
+			return s.reply("451 4.3.0 Error: queue file write error")
+		}
+	}
+
+	queueID := s.message.QueueID
+	s.reset()
+
+	return s.reply("250 2.0.0 Ok: queued as %s", queueID)
+}
+
+// smtpPath extracts the address from a MAIL FROM:<a> or RCPT TO:<a> argument, dropping any parameters
+func smtpPath(argument string, prefix string) string {
+	if len(argument) >= len(prefix) && strings.EqualFold(argument[:len(prefix)], prefix) {
+		argument = strings.TrimSpace(argument[len(prefix):])
+	}
+
+	if strings.HasPrefix(argument, "<") {
+		if end := strings.Index(argument, ">"); end > 0 {
+			return argument[1:end]
+		}
+	}
+
+	address, _, _ := strings.Cut(argument, " ")
+
+	return address
+}
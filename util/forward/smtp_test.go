@@ -0,0 +1,139 @@
+package forward
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestSMTPTransaction(t *testing.T) {
+	client := &conversation{input: strings.NewReader(strings.Join([]string{
+		"EHLO spammer.example",
+		"AUTH PLAIN AHVzZXIAc2VjcmV0", // \x00user\x00secret
+		"MAIL FROM:<bulk@spammer.example> SIZE=100",
+		"RCPT TO:<a@victim.example>",
+		"RCPT TO:<b@victim.example>",
+		"DATA",
+		"Subject: hello",
+		"",
+		"..leading dot",
+		".",
+		"QUIT",
+		"",
+	}, "\r\n"))}
+
+	var messages []Message
+
+	err := SMTP("smtp.example.com", func(m Message) error {
+		messages = append(messages, m)
+		return nil
+	}).Serve(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(messages) != 1 {
+		t.Fatalf("expected one message got %d: %q", len(messages), client.String())
+	}
+
+	m := messages[0]
+
+	if m.Helo != "spammer.example" || m.From != "bulk@spammer.example" || strings.Join(m.To, ",") != "a@victim.example,b@victim.example" {
+		t.Fatalf("unexpected envelope %+v", m)
+	}
+
+	if m.Username != "user" || m.Password != "secret" {
+		t.Fatalf("unexpected credentials %q %q", m.Username, m.Password)
+	}
+
+	if string(m.Data) != "Subject: hello\n\n.leading dot\n" {
+		t.Fatalf("unexpected data %q", m.Data)
+	}
+
+	eml := m.EML()
+	for _, expected := range []string{"Return-Path: <bulk@spammer.example>\r\n", "X-Fishler-Rcpt-To: b@victim.example\r\n", "Subject: hello"} {
+		if !bytes.Contains(eml, []byte(expected)) {
+			t.Fatalf("expected %q in %q", expected, eml)
+		}
+	}
+
+	for _, expected := range []string{"220 smtp.example.com", "235 ", "354 ", "250 2.0.0 Ok: queued as ", "221 "} {
+		if !strings.Contains(client.String(), expected) {
+			t.Fatalf("expected %q in %q", expected, client.String())
+		}
+	}
+}
+
+func TestSMTPSequence(t *testing.T) {
+	client := &conversation{input: strings.NewReader("HELO x\r\nRCPT TO:<a@b>\r\nMAIL FROM:<>\r\nDATA\r\nQUIT\r\n")}
+
+	err := SMTP("smtp.example.com", func(m Message) error {
+		t.Fatal("no message expected")
+		return nil
+	}).Serve(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Count(client.String(), "503 ") != 2 {
+		t.Fatalf("expected RCPT before MAIL and DATA before RCPT to be refused: %q", client.String())
+	}
+}
+
+func TestSMTPPath(t *testing.T) {
+	for argument, expected := range map[string]string{
+		"FROM:<a@b.example>":                "a@b.example",
+		"from: <a@b.example> BODY=8BITMIME": "a@b.example",
+		"TO:a@b.example":                    "a@b.example",
+		"FROM:<>":                           "",
+	} {
+		prefix := "FROM:"
+		if strings.HasPrefix(argument, "TO:") {
+			prefix = "TO:"
+		}
+
+		if got := smtpPath(argument, prefix); got != expected {
+			t.Fatalf("%q: expected %q got %q", argument, expected, got)
+		}
+	}
+}
+
+func TestSMTPInsufficientStorage(t *testing.T) {
+	client := &conversation{input: strings.NewReader("HELO x\r\nMAIL FROM:<a@b>\r\nRCPT TO:<c@d>\r\nDATA\r\nspam\r\n.\r\nQUIT\r\n")}
+
+	err := SMTP("smtp.example.com", func(m Message) error {
+		return ErrInsufficientStorage
+	}).Serve(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(client.String(), "452 4.3.1 Insufficient system storage") || strings.Contains(client.String(), "queued as") {
+		t.Fatalf("expected the message to be deferred: %q", client.String())
+	}
+}
+
+func TestSMTPLineTooLong(t *testing.T) {
+	credentials := base64.StdEncoding.EncodeToString([]byte("\x00spammer\x00" + strings.Repeat("p", 700)))
+
+	client := &conversation{input: strings.NewReader(
+		"NOOP " + strings.Repeat("x", 600) + "\r\n" +
+			"NOOP " + strings.Repeat("x", 10000) + "\r\n" +
+			"AUTH PLAIN " + credentials + "\r\n" +
+			"QUIT\r\n",
+	)}
+
+	if err := SMTP("smtp.example.com", nil).Serve(client); err != nil {
+		t.Fatal(err)
+	}
+
+	if count := strings.Count(client.String(), "500 5.5.0 Error: line too long"); count != 2 {
+		t.Fatalf("expected both long lines to be refused got %d: %q", count, client.String())
+	}
+
+	// credentials may take more than a command line
+	if !strings.Contains(client.String(), "235 ") || !strings.Contains(client.String(), "221 ") {
+		t.Fatalf("expected the session to carry on: %q", client.String())
+	}
+}
@@ -0,0 +1,76 @@
+package forward
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Directions of a transcript entry
+const (
+	DirectionClient  = "client"
+	DirectionFishler = "fishler"
+)
+
+// Transcript wraps a forwarded channel writing everything read from and written to it to w - once
+// limit bytes are recorded the conversation carries on unrecorded
+type Transcript struct {
+	rw    io.ReadWriter
+	w     io.Writer
+	limit int64
+
+	mu       sync.Mutex
+	recorded int64
+
+	BytesIn  int64
+	BytesOut int64
+}
+
+func NewTranscript(rw io.ReadWriter, w io.Writer, limit int64) *Transcript {
+	return &Transcript{
+		rw:    rw,
+		w:     w,
+		limit: limit,
+	}
+}
+
+func (t *Transcript) Read(p []byte) (int, error) {
+	n, err := t.rw.Read(p)
+
+	if n > 0 {
+		t.BytesIn += int64(n)
+		t.record(DirectionClient, p[:n])
+	}
+
+	return n, err
+}
+
+func (t *Transcript) Write(p []byte) (int, error) {
+	n, err := t.rw.Write(p)
+
+	if n > 0 {
+		t.BytesOut += int64(n)
+		t.record(DirectionFishler, p[:n])
+	}
+
+	return n, err
+}
+
+func (t *Transcript) record(direction string, p []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.limit > 0 && t.recorded >= t.limit {
+		return
+	}
+
+	if t.limit > 0 && t.recorded+int64(len(p)) > t.limit {
+		p = p[:t.limit-t.recorded]
+	}
+
+	t.recorded += int64(len(p))
+
+	_, _ = fmt.Fprintf(t.w, "\n--- %s %s %d bytes\n", direction, time.Now().UTC().Format(time.RFC3339Nano), len(p))
+	_, _ = t.w.Write(p)
+}
@@ -0,0 +1,201 @@
+package geoip
+
+import (
+	"container/list"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+
+	"github.com/archimoebius/fishler/util/cidr"
+)
+
+const cacheSize = 4096
+
+// Info is what the databases know about an address - zero values mean unknown
+type Info struct {
+	Country     string
+	CountryName string
+	City        string
+	Latitude    float64
+	Longitude   float64
+	ASN         uint
+	ASOrg       string
+}
+
+// record covers the fields fishler uses from the GeoLite2/GeoIP2 Country, City and ASN databases
+type record struct {
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+	AutonomousSystemNumber       uint   `maxminddb:"autonomous_system_number"`
+	AutonomousSystemOrganization string `maxminddb:"autonomous_system_organization"`
+}
+
+// Fields returns the known values keyed as they appear in logs and events
+func (i Info) Fields() map[string]string {
+	fields := make(map[string]string)
+
+	if i.Country != "" {
+		fields["geo_country"] = i.Country
+		fields["geo_country_name"] = i.CountryName
+	}
+
+	if i.City != "" {
+		fields["geo_city"] = i.City
+	}
+
+	if i.Latitude != 0 || i.Longitude != 0 {
+		fields["geo_latitude"] = strconv.FormatFloat(i.Latitude, 'f', 4, 64)
+		fields["geo_longitude"] = strconv.FormatFloat(i.Longitude, 'f', 4, 64)
+	}
+
+	if i.ASN != 0 {
+		fields["asn"] = strconv.FormatUint(uint64(i.ASN), 10)
+		fields["asn_org"] = i.ASOrg
+	}
+
+	return fields
+}
+
+// Enricher looks addresses up in any number of mmdb databases, caching the merged result per IP
+type Enricher struct {
+	readers []*maxminddb.Reader
+	mu      sync.Mutex
+	cache   *lru
+}
+
+// Open loads each non-empty path as a MaxMind-format database
+func Open(paths ...string) (*Enricher, error) {
+	e := &Enricher{
+		cache: newLRU(cacheSize),
+	}
+
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+
+		reader, err := maxminddb.Open(path)
+		if err != nil {
+			e.Close()
+			return nil, err
+		}
+
+		e.readers = append(e.readers, reader)
+	}
+
+	return e, nil
+}
+
+// Lookup returns what is known about the IP of addr
+func (e *Enricher) Lookup(addr net.Addr) Info {
+	ip := cidr.IP(addr)
+	if ip == nil {
+		return Info{}
+	}
+
+	key := ip.String()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if info, ok := e.cache.Get(key); ok {
+		return info
+	}
+
+	var info Info
+
+	// later databases only fill in what earlier ones didn't know
+	for _, reader := range e.readers {
+		var r record
+		if err := reader.Lookup(ip, &r); err != nil {
+			continue
+		}
+
+		if info.Country == "" {
+			info.Country = r.Country.ISOCode
+			info.CountryName = r.Country.Names["en"]
+		}
+
+		if info.City == "" {
+			info.City = r.City.Names["en"]
+		}
+
+		if info.Latitude == 0 && info.Longitude == 0 {
+			info.Latitude = r.Location.Latitude
+			info.Longitude = r.Location.Longitude
+		}
+
+		if info.ASN == 0 {
+			info.ASN = r.AutonomousSystemNumber
+			info.ASOrg = r.AutonomousSystemOrganization
+		}
+	}
+
+	e.cache.Add(key, info)
+
+	return info
+}
+
+func (e *Enricher) Close() {
+	for _, reader := range e.readers {
+		_ = reader.Close()
+	}
+}
+
+// lru is a fixed size least-recently-used cache of lookups
+type lru struct {
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key  string
+	info Info
+}
+
+func newLRU(size int) *lru {
+	return &lru{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *lru) Get(key string) (Info, bool) {
+	element, ok := c.entries[key]
+	if !ok {
+		return Info{}, false
+	}
+
+	c.order.MoveToFront(element)
+
+	return element.Value.(*lruEntry).info, true
+}
+
+func (c *lru) Add(key string, info Info) {
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*lruEntry).info = info
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, info: info})
+
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
@@ -0,0 +1,42 @@
+package geoip
+
+import (
+	"net"
+	"testing"
+)
+
+func TestLRUEviction(t *testing.T) {
+	cache := newLRU(2)
+
+	cache.Add("192.0.2.1", Info{Country: "US"})
+	cache.Add("192.0.2.2", Info{Country: "DE"})
+
+	// touch the first entry so the second becomes the eviction candidate
+	if _, ok := cache.Get("192.0.2.1"); !ok {
+		t.Fatal("expected 192.0.2.1 to be cached")
+	}
+
+	cache.Add("192.0.2.3", Info{Country: "JP"})
+
+	if _, ok := cache.Get("192.0.2.2"); ok {
+		t.Fatal("expected 192.0.2.2 to be evicted")
+	}
+
+	if info, ok := cache.Get("192.0.2.1"); !ok || info.Country != "US" {
+		t.Fatalf("expected 192.0.2.1 to be retained got %v", info)
+	}
+}
+
+func TestLookupWithoutDatabases(t *testing.T) {
+	enricher, err := Open("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enricher.Close()
+
+	info := enricher.Lookup(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22})
+
+	if len(info.Fields()) != 0 {
+		t.Fatalf("expected no enrichment without databases got %v", info.Fields())
+	}
+}
@@ -0,0 +1,148 @@
+package limit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Action is what happens to a client once it goes over a limit
+type Action string
+
+const (
+	ActionReject Action = "reject"
+	ActionTarpit Action = "tarpit"
+	ActionLog    Action = "log"
+)
+
+// ParseAction validates an action name from the config
+func ParseAction(name string) (Action, error) {
+	switch action := Action(name); action {
+	case ActionReject, ActionTarpit, ActionLog:
+		return action, nil
+	default:
+		return "", fmt.Errorf("unknown limit action %q - expected one of reject, tarpit, log", name)
+	}
+}
+
+const window = time.Minute
+
+// Limiter tracks per-IP connection rates and concurrent sessions along with the sensor wide
+// container count - a limit of zero is unlimited
+type Limiter struct {
+	ConnectionsPerMinute int
+	SessionsPerIP        int
+	Containers           int
+
+	mu          sync.Mutex
+	connections map[string][]time.Time
+	sessions    map[string]int
+	containers  int
+	lastSweep   time.Time
+}
+
+func New(connectionsPerMinute, sessionsPerIP, containers int) *Limiter {
+	return &Limiter{
+		ConnectionsPerMinute: connectionsPerMinute,
+		SessionsPerIP:        sessionsPerIP,
+		Containers:           containers,
+		connections:          make(map[string][]time.Time),
+		sessions:             make(map[string]int),
+		lastSweep:            time.Now(),
+	}
+}
+
+// AllowConnection records a new connection from ip and reports whether it is within the per minute rate -
+// once ip is over the rate no further connections are recorded so a flood cannot grow its history
+func (l *Limiter) AllowConnection(ip string) bool {
+	if l.ConnectionsPerMinute <= 0 {
+		return true
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > window {
+		l.sweep(now)
+	}
+
+	recent := prune(l.connections[ip], now)
+	if len(recent) <= l.ConnectionsPerMinute {
+		recent = append(recent, now)
+	}
+	l.connections[ip] = recent
+
+	return len(recent) <= l.ConnectionsPerMinute
+}
+
+// AcquireSession reserves a session slot for ip - every successful acquire must be released
+func (l *Limiter) AcquireSession(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.SessionsPerIP > 0 && l.sessions[ip] >= l.SessionsPerIP {
+		return false
+	}
+
+	l.sessions[ip]++
+
+	return true
+}
+
+func (l *Limiter) ReleaseSession(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sessions[ip]--
+
+	if l.sessions[ip] <= 0 {
+		delete(l.sessions, ip)
+	}
+}
+
+// AcquireContainer reserves one of the sensor wide container slots - every successful acquire must be released
+func (l *Limiter) AcquireContainer() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.Containers > 0 && l.containers >= l.Containers {
+		return false
+	}
+
+	l.containers++
+
+	return true
+}
+
+func (l *Limiter) ReleaseContainer() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.containers > 0 {
+		l.containers--
+	}
+}
+
+// sweep drops IPs which haven't connected within the window - callers must hold l.mu
+func (l *Limiter) sweep(now time.Time) {
+	for ip, times := range l.connections {
+		if recent := prune(times, now); len(recent) > 0 {
+			l.connections[ip] = recent
+		} else {
+			delete(l.connections, ip)
+		}
+	}
+
+	l.lastSweep = now
+}
+
+func prune(times []time.Time, now time.Time) []time.Time {
+	idx := 0
+	for idx < len(times) && now.Sub(times[idx]) >= window {
+		idx++
+	}
+
+	return times[idx:]
+}
@@ -0,0 +1,73 @@
+package limit
+
+import "testing"
+
+func TestAllowConnection(t *testing.T) {
+	l := New(2, 0, 0)
+
+	for idx := 0; idx < 2; idx++ {
+		if !l.AllowConnection("192.0.2.1") {
+			t.Fatalf("expected connection %d to be allowed", idx)
+		}
+	}
+
+	if l.AllowConnection("192.0.2.1") {
+		t.Fatal("expected third connection within the window to be refused")
+	}
+
+	if !l.AllowConnection("192.0.2.2") {
+		t.Fatal("expected a different IP to be allowed")
+	}
+
+	for idx := 0; idx < 100; idx++ {
+		l.AllowConnection("192.0.2.1")
+	}
+
+	if recorded := len(l.connections["192.0.2.1"]); recorded != 3 {
+		t.Fatalf("expected a flood to stop being recorded got %d", recorded)
+	}
+}
+
+func TestSessionAndContainerSlots(t *testing.T) {
+	l := New(0, 1, 1)
+
+	if !l.AcquireSession("192.0.2.1") || l.AcquireSession("192.0.2.1") {
+		t.Fatal("expected exactly one session slot per IP")
+	}
+
+	l.ReleaseSession("192.0.2.1")
+
+	if !l.AcquireSession("192.0.2.1") {
+		t.Fatal("expected released session slot to be reusable")
+	}
+
+	if !l.AcquireContainer() || l.AcquireContainer() {
+		t.Fatal("expected exactly one container slot")
+	}
+
+	l.ReleaseContainer()
+
+	if !l.AcquireContainer() {
+		t.Fatal("expected released container slot to be reusable")
+	}
+}
+
+func TestUnlimited(t *testing.T) {
+	l := New(0, 0, 0)
+
+	for idx := 0; idx < 100; idx++ {
+		if !l.AllowConnection("192.0.2.1") || !l.AcquireSession("192.0.2.1") || !l.AcquireContainer() {
+			t.Fatal("expected zero limits to be unlimited")
+		}
+	}
+
+	if len(l.connections) != 0 {
+		t.Fatal("expected unlimited connections not to be recorded")
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	if _, err := ParseAction("drop"); err == nil {
+		t.Fatal("expected unknown action to fail")
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -13,6 +15,39 @@ import (
 
 var Logger *logrus.Logger
 
+// fieldsHook stamps a fixed set of fields onto every entry - it must fire before the formatting hooks
+type fieldsHook struct {
+	mu     sync.RWMutex
+	fields logrus.Fields
+}
+
+func (hook *fieldsHook) Fire(entry *logrus.Entry) error {
+	hook.mu.RLock()
+	defer hook.mu.RUnlock()
+
+	for key, value := range hook.fields {
+		if _, ok := entry.Data[key]; !ok {
+			entry.Data[key] = value
+		}
+	}
+
+	return nil
+}
+
+func (hook *fieldsHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+var globalFields = &fieldsHook{fields: logrus.Fields{}}
+
+// SetLogFields adds fields (such as the sensor identity) to every subsequent log line
+func SetLogFields(fields logrus.Fields) {
+	globalFields.mu.Lock()
+	defer globalFields.mu.Unlock()
+
+	maps.Copy(globalFields.fields, fields)
+}
+
 // FormatterHook is a hook that writes logs of specified LogLevels with a formatter to specified Writer
 type FormatterHook struct {
 	Writer    io.Writer
@@ -67,6 +102,8 @@ func SetLogger(filepath string) *logrus.Logger {
 
 	logger.ReportCaller = false
 
+	logger.AddHook(globalFields)
+
 	logger.AddHook(&FormatterHook{ // Send logs with level higher than info to systemlog
 		Writer: systemlog,
 		LogLevels: []logrus.Level{
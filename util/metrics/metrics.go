@@ -0,0 +1,151 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "fishler"
+
+var (
+	ConnectionsAccepted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "connections_accepted_total",
+		Help:      "Number of TCP connections accepted by the SSH listener",
+	})
+
+	HASSHCaptured = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "hassh_captured_total",
+		Help:      "Number of client HASSH fingerprints captured",
+	})
+
+	HASSHBlocked = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "hassh_blocked_total",
+		Help:      "Number of connections closed because their HASSH is blocklisted",
+	})
+
+	AuthAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_attempts_total",
+		Help:      "Number of authentication attempts by method and outcome",
+	}, []string{"method", "outcome"})
+
+	SessionsActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ssh_sessions_active",
+		Help:      "Number of SSH sessions (shell, exec and sftp) currently open",
+	})
+
+	ContainersActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "containers_active",
+		Help:      "Number of session containers currently running",
+	})
+
+	ContainerStartSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "container_start_seconds",
+		Help:      "Time taken from container create until the session container is ready",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	})
+
+	SFTPBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sftp_bytes_total",
+		Help:      "Number of bytes transferred over SFTP and SCP by direction (in = upload, out = download)",
+	}, []string{"direction"})
+
+	AccessMatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "access_matches_total",
+		Help:      "Number of connections matched by an access list by list and action",
+	}, []string{"list", "action"})
+
+	LimitExceeded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "limit_exceeded_total",
+		Help:      "Number of times a client went over a configured limit by limit and action taken",
+	}, []string{"limit", "action"})
+
+	TarpitActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "tarpit_clients_active",
+		Help:      "Number of clients currently held in the tarpit",
+	})
+
+	TarpitClients = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tarpit_clients_total",
+		Help:      "Number of clients tarpitted by reason",
+	}, []string{"reason"})
+
+	TarpitSeconds = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tarpit_seconds_total",
+		Help:      "Seconds of client time wasted in the tarpit by reason",
+	}, []string{"reason"})
+
+	UplinkSendFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uplink_send_failures_total",
+		Help:      "Number of events which failed to send to the uplink server",
+	})
+
+	UplinkReconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uplink_reconnects_total",
+		Help:      "Number of reconnect attempts made to the uplink server",
+	})
+)
+
+// Auth outcome label values
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// SFTP direction label values
+const (
+	DirectionIn  = "in"
+	DirectionOut = "out"
+)
+
+// Outcome maps an authentication result onto its label value
+func Outcome(authenticated bool) string {
+	if authenticated {
+		return OutcomeSuccess
+	}
+
+	return OutcomeFailure
+}
+
+// TrackFishyFSMounts exposes the number of active FishyFS mounts as reported by count
+func TrackFishyFSMounts(count func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "fishyfs_mounts_active",
+		Help:      "Number of FishyFS FUSE mounts currently active",
+	}, func() float64 {
+		return float64(count())
+	})
+}
+
+// Serve exposes the registered metrics on addr under /metrics - blocks until the listener fails
+func Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	return server.ListenAndServe()
+}
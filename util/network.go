@@ -0,0 +1,109 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+)
+
+// bridgeNameOption names the linux bridge of a docker bridge network - docker defaults to br-<id>
+const bridgeNameOption = "com.docker.network.bridge.name"
+
+// iccOption switches traffic between the containers on a docker bridge network
+const iccOption = "com.docker.network.bridge.enable_icc"
+
+// EgressNetwork is the host side of the egress network
+type EgressNetwork struct {
+	Gateway string // the host's address on the bridge - all containers can reach
+	Bridge  string // the bridge interface traffic can be captured on
+}
+
+// EnsureEgressNetwork creates the internal bridge session containers are attached to when they are allowed
+// egress - it has no route out and containers on it can't reach each other, so the host's gateway
+// address is all they can reach
+func EnsureEgressNetwork(ctx context.Context, name string) (EgressNetwork, error) {
+	dockerClient, err := client.NewClientWithOpts(
+		client.FromEnv,
+		client.WithAPIVersionNegotiation(),
+	)
+	if err != nil {
+		return EgressNetwork{}, err
+	}
+	defer dockerClient.Close()
+
+	inspect, err := dockerClient.NetworkInspect(ctx, name, network.InspectOptions{})
+	if cerrdefs.IsNotFound(err) {
+		_, err = dockerClient.NetworkCreate(ctx, name, network.CreateOptions{
+			Driver:   "bridge",
+			Internal: true,
+			Options:  map[string]string{iccOption: "false"},
+			Labels:   map[string]string{ContainerLabel: ContainerLabel},
+		})
+		if err != nil {
+			return EgressNetwork{}, err
+		}
+
+		inspect, err = dockerClient.NetworkInspect(ctx, name, network.InspectOptions{})
+	}
+
+	if err != nil {
+		return EgressNetwork{}, err
+	}
+
+	if !inspect.Internal {
+		return EgressNetwork{}, errors.New("egress network " + name + " exists but is not internal")
+	}
+
+	if inspect.Options[iccOption] != "false" {
+		return EgressNetwork{}, errors.New("egress network " + name + " exists but lets containers reach each other")
+	}
+
+	egress := EgressNetwork{
+		Bridge: inspect.Options[bridgeNameOption],
+	}
+
+	if egress.Bridge == "" && len(inspect.ID) >= 12 {
+		egress.Bridge = "br-" + inspect.ID[:12]
+	}
+
+	for _, config := range inspect.IPAM.Config {
+		if ip := net.ParseIP(config.Gateway); ip != nil && ip.To4() != nil {
+			egress.Gateway = config.Gateway
+			return egress, nil
+		}
+	}
+
+	return EgressNetwork{}, errors.New("egress network " + name + " has no IPv4 gateway")
+}
+
+// ContainerNameByIP returns the name of the container attached to the named network with the given address
+func ContainerNameByIP(ctx context.Context, name string, ip string) (string, error) {
+	dockerClient, err := client.NewClientWithOpts(
+		client.FromEnv,
+		client.WithAPIVersionNegotiation(),
+	)
+	if err != nil {
+		return "", err
+	}
+	defer dockerClient.Close()
+
+	inspect, err := dockerClient.NetworkInspect(ctx, name, network.InspectOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	for _, endpoint := range inspect.Containers {
+		address, _, _ := strings.Cut(endpoint.IPv4Address, "/")
+
+		if address == ip {
+			return endpoint.Name, nil
+		}
+	}
+
+	return "", ErrorContainerNameNotFound
+}
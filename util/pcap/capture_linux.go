@@ -0,0 +1,67 @@
+//go:build linux
+
+package pcap
+
+import (
+	"errors"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+type socket struct {
+	fd int
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// Open captures every frame crossing the named interface - it needs CAP_NET_RAW
+func Open(iface string) (Source, error) {
+	link, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ALL)))
+	if err != nil {
+		return nil, err
+	}
+
+	err = unix.Bind(fd, &unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ALL),
+		Ifindex:  link.Index,
+	})
+	if err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+
+	// wake up every second so Run can notice it has been cancelled
+	err = unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &unix.Timeval{Sec: 1})
+	if err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+
+	return &socket{fd: fd}, nil
+}
+
+func (s *socket) Read(buf []byte) (int, error) {
+	n, _, err := unix.Recvfrom(s.fd, buf, 0)
+
+	if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+func (s *socket) Close() error {
+	return unix.Close(s.fd)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package pcap
+
+import "errors"
+
+// ErrUnsupported is returned by Open where live capture isn't available
+var ErrUnsupported = errors.New("packet capture is not supported on this platform")
+
+// Open is only implemented on linux
+func Open(iface string) (Source, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,87 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// frame builds an ethernet/IPv4 frame from src to dst
+func frame(src, dst string) []byte {
+	f := make([]byte, 14+20)
+	f[12], f[13] = 0x08, 0x00
+	f[14] = 0x45
+	copy(f[14+12:], net.ParseIP(src).To4())
+	copy(f[14+16:], net.ParseIP(dst).To4())
+
+	return f
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+
+	w, err := NewWriter(&buf, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.WritePacket(time.Unix(1700000000, 5000), frame("172.18.0.2", "172.18.0.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+
+	if binary.LittleEndian.Uint32(data[0:]) != 0xa1b2c3d4 || binary.LittleEndian.Uint32(data[20:]) != LinkTypeEthernet {
+		t.Fatalf("unexpected global header %x", data[:24])
+	}
+
+	record := data[24:]
+	if binary.LittleEndian.Uint32(record[0:]) != 1700000000 || binary.LittleEndian.Uint32(record[4:]) != 5 {
+		t.Fatalf("unexpected timestamp %x", record[:8])
+	}
+
+	// truncated to the snaplen but the original length is kept
+	if binary.LittleEndian.Uint32(record[8:]) != 16 || binary.LittleEndian.Uint32(record[12:]) != 34 || len(record) != 16+16 {
+		t.Fatalf("unexpected record %x", record)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	dir := t.TempDir()
+
+	r, err := NewRecorder(dir, 100, 0, net.ParseIP("172.18.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Begin("session-a", "172.18.0.2")
+
+	for idx := 0; idx < 5; idx++ {
+		r.Packet(time.Now(), frame("172.18.0.2", "172.18.0.1"))
+		r.Packet(time.Now(), frame("172.18.0.3", "172.18.0.1"))
+	}
+
+	r.End("session-a")
+
+	if sessionID := r.session("172.18.0.2"); sessionID != "" {
+		t.Fatalf("expected the address to be forgotten once the session ended got %s", sessionID)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "session-a.pcap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the cap stops writing once 100 bytes are exceeded - the global header and two 50 byte records
+	if info.Size() != 24+2*(16+34) {
+		t.Fatalf("unexpected pcap size %d", info.Size())
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "session-b.pcap")); !os.IsNotExist(err) {
+		t.Fatal("expected no pcap for an unknown session")
+	}
+}
@@ -0,0 +1,215 @@
+package pcap
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const snaplen = 65535
+
+// Source yields captured ethernet frames - Read returns a zero length frame when nothing arrived in time
+type Source interface {
+	Read(buf []byte) (int, error)
+	Close() error
+}
+
+// Recorder splits the frames captured on the egress bridge into a pcap per session
+type Recorder struct {
+	dir         string
+	maxBytes    int64
+	maxDuration time.Duration
+	gateway     net.IP
+
+	mu       sync.Mutex
+	sessions map[string]*session // by session id - only sessions which have begun
+	byIP     map[string]string   // container address to session id
+}
+
+type session struct {
+	file    *os.File
+	writer  *Writer
+	started time.Time
+	written int64
+	full    bool
+}
+
+// NewRecorder writes <dir>/<sessionID>.pcap for each session - a cap of zero is unlimited
+func NewRecorder(dir string, maxBytes int64, maxDuration time.Duration, gateway net.IP) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	return &Recorder{
+		dir:         dir,
+		maxBytes:    maxBytes,
+		maxDuration: maxDuration,
+		gateway:     gateway,
+		sessions:    make(map[string]*session),
+		byIP:        make(map[string]string),
+	}, nil
+}
+
+// Begin starts recording the traffic of the session's container at ip - the pcap is created with its
+// first frame. It is called once the container has its address so the capture never has to look
+// one up.
+func (r *Recorder) Begin(sessionID string, ip string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.sessions[sessionID]; !ok {
+		r.sessions[sessionID] = &session{}
+	}
+
+	r.byIP[ip] = sessionID
+}
+
+// End closes the session's pcap
+func (r *Recorder) End(sessionID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if s, ok := r.sessions[sessionID]; ok && s.file != nil {
+		_ = s.file.Close()
+	}
+
+	delete(r.sessions, sessionID)
+
+	for ip, id := range r.byIP {
+		if id == sessionID {
+			delete(r.byIP, ip)
+		}
+	}
+}
+
+// Run reads frames from source until ctx is done
+func (r *Recorder) Run(ctx context.Context, source Source) error {
+	defer source.Close()
+
+	buf := make([]byte, snaplen)
+
+	for ctx.Err() == nil {
+		n, err := source.Read(buf)
+		if err != nil {
+			return err
+		}
+
+		if n > 0 {
+			r.Packet(time.Now(), buf[:n])
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range r.sessions {
+		if s.file != nil {
+			_ = s.file.Close()
+		}
+	}
+
+	return nil
+}
+
+// Packet writes the frame to the pcap of every recording session it was sent from or to
+func (r *Recorder) Packet(ts time.Time, frame []byte) {
+	src, dst := addresses(frame)
+	if src == nil {
+		return
+	}
+
+	for _, ip := range []net.IP{src, dst} {
+		if ip.Equal(r.gateway) || ip.IsMulticast() || ip.Equal(net.IPv4bcast) {
+			continue
+		}
+
+		if sessionID := r.session(ip.String()); sessionID != "" {
+			r.write(sessionID, ts, frame)
+		}
+	}
+}
+
+// session maps a container address to its recording session
+func (r *Recorder) session(ip string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.byIP[ip]
+}
+
+func (r *Recorder) write(sessionID string, ts time.Time, frame []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.sessions[sessionID]
+	if !ok || s.full {
+		return
+	}
+
+	if s.file == nil {
+		if err := s.open(filepath.Join(r.dir, sessionID+".pcap")); err != nil {
+			s.full = true
+			return
+		}
+	}
+
+	if (r.maxBytes > 0 && s.written >= r.maxBytes) || (r.maxDuration > 0 && ts.Sub(s.started) > r.maxDuration) {
+		s.full = true
+		return
+	}
+
+	n, err := s.writer.WritePacket(ts, frame)
+	s.written += int64(n)
+
+	if err != nil {
+		s.full = true
+	}
+}
+
+func (s *session) open(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600) // #nosec
+	if err != nil {
+		return err
+	}
+
+	writer, err := NewWriter(file, snaplen)
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	s.file = file
+	s.writer = writer
+	s.started = time.Now()
+
+	return nil
+}
+
+// addresses returns the IPv4/IPv6 source and destination of an ethernet frame
+func addresses(frame []byte) (net.IP, net.IP) {
+	if len(frame) < 14 {
+		return nil, nil
+	}
+
+	payload := frame[14:]
+
+	switch uint16(frame[12])<<8 | uint16(frame[13]) {
+	case 0x0800:
+		if len(payload) < 20 {
+			return nil, nil
+		}
+
+		return net.IP(payload[12:16]), net.IP(payload[16:20])
+	case 0x86dd:
+		if len(payload) < 40 {
+			return nil, nil
+		}
+
+		return net.IP(payload[8:24]), net.IP(payload[24:40])
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,52 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// LinkTypeEthernet is the only link type fishler captures
+const LinkTypeEthernet = 1
+
+// Writer writes the classic libpcap file format - see https://wiki.wireshark.org/Development/LibpcapFileFormat
+type Writer struct {
+	w       io.Writer
+	snaplen uint32
+}
+
+// NewWriter writes the global header for ethernet frames of up to snaplen bytes
+func NewWriter(w io.Writer, snaplen uint32) (*Writer, error) {
+	header := make([]byte, 24)
+
+	binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4) // microsecond timestamps
+	binary.LittleEndian.PutUint16(header[4:], 2)          // version major
+	binary.LittleEndian.PutUint16(header[6:], 4)          // version minor
+	binary.LittleEndian.PutUint32(header[8:], 0)          // thiszone
+	binary.LittleEndian.PutUint32(header[12:], 0)         // sigfigs
+	binary.LittleEndian.PutUint32(header[16:], snaplen)
+	binary.LittleEndian.PutUint32(header[20:], LinkTypeEthernet)
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &Writer{w: w, snaplen: snaplen}, nil
+}
+
+// WritePacket writes a record for the frame captured at ts - frames over the snaplen are truncated
+func (w *Writer) WritePacket(ts time.Time, frame []byte) (int, error) {
+	captured := frame
+	if uint32(len(captured)) > w.snaplen {
+		captured = captured[:w.snaplen]
+	}
+
+	record := make([]byte, 16, 16+len(captured))
+
+	binary.LittleEndian.PutUint32(record[0:], uint32(ts.Unix()))           // #nosec G115
+	binary.LittleEndian.PutUint32(record[4:], uint32(ts.Nanosecond()/1e3)) // #nosec G115
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(captured)))       // #nosec G115
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(frame)))         // #nosec G115
+
+	return w.w.Write(append(record, captured...))
+}
@@ -0,0 +1,26 @@
+package proxy
+
+import (
+	"net"
+
+	"github.com/pires/go-proxyproto"
+
+	"github.com/archimoebius/fishler/util/cidr"
+)
+
+// Listen wraps ln so connections from a trusted proxy may start with a PROXY protocol v1/v2 header - their
+// RemoteAddr is then the client the proxy accepted. Everyone else is handled as a plain connection so a
+// forged header is just a failed handshake
+func Listen(ln net.Listener, trusted cidr.List) net.Listener {
+	return &proxyproto.Listener{
+		Listener: ln,
+		Policy: func(upstream net.Addr) (proxyproto.Policy, error) {
+			// never return an error - it would fail Accept and stop the server
+			if trusted.Contains(upstream) {
+				return proxyproto.USE, nil
+			}
+
+			return proxyproto.SKIP, nil
+		},
+	}
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/archimoebius/fishler/util/cidr"
+)
+
+func accept(t *testing.T, trusted cidr.List, header string) net.Conn {
+	t.Helper()
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	client, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	if _, err := io.WriteString(client, header+"SSH-2.0-test\r\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := Listen(ln, trusted).Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+func TestTrustedProxy(t *testing.T) {
+	trusted, _ := cidr.Parse([]string{"127.0.0.0/8"})
+
+	conn := accept(t, trusted, "PROXY TCP4 192.0.2.10 127.0.0.1 40000 22\r\n")
+
+	if got := conn.RemoteAddr().String(); got != "192.0.2.10:40000" {
+		t.Fatalf("expected the proxied client address got %s", got)
+	}
+
+	banner := make([]byte, len("SSH-2.0-test\r\n"))
+	if _, err := io.ReadFull(conn, banner); err != nil || string(banner) != "SSH-2.0-test\r\n" {
+		t.Fatalf("expected the header to be consumed got %q (%v)", banner, err)
+	}
+}
+
+func TestUntrustedProxy(t *testing.T) {
+	trusted, _ := cidr.Parse([]string{"192.0.2.0/24"})
+
+	conn := accept(t, trusted, "PROXY TCP4 198.51.100.1 127.0.0.1 40000 22\r\n")
+
+	if host, _, _ := net.SplitHostPort(conn.RemoteAddr().String()); host != "127.0.0.1" {
+		t.Fatalf("expected an untrusted header to be ignored got %s", conn.RemoteAddr())
+	}
+}
@@ -0,0 +1,108 @@
+package quota
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrExceeded is returned once a write would take a directory past its limit - it is EDQUOT so SFTP
+// clients are told "disk quota exceeded" as they would be by a real server
+var ErrExceeded error = syscall.EDQUOT
+
+// RescanInterval is how stale the count may grow before the directory is walked again
+const RescanInterval = 30 * time.Second
+
+// Tracker counts the bytes held under a directory as they are written so a limit can be enforced
+// mid-transfer - the directory is walked at most every RescanInterval to pick up changes made around
+// the tracker, such as by a container with the directory bind mounted
+type Tracker struct {
+	dir   string
+	limit int64
+
+	mu      sync.Mutex
+	used    int64
+	scanned time.Time
+}
+
+// New tracks dir against limit bytes - a limit of zero is unlimited and a dir of "" is never walked,
+// counting only what the tracker is told
+func New(dir string, limit int64) *Tracker {
+	return &Tracker{
+		dir:   dir,
+		limit: limit,
+	}
+}
+
+// Dir is the directory tracked
+func (t *Tracker) Dir() string {
+	return t.dir
+}
+
+func (t *Tracker) Limit() int64 {
+	return t.limit
+}
+
+// Used is the number of bytes held
+func (t *Tracker) Used() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rescan()
+
+	return t.used
+}
+
+// Exceeded reports whether the directory is at or over its limit
+func (t *Tracker) Exceeded() bool {
+	return t.limit > 0 && t.Used() >= t.limit
+}
+
+// Grow claims n more bytes failing with ErrExceeded, and claiming nothing, if they don't fit
+func (t *Tracker) Grow(n int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rescan()
+
+	if t.limit > 0 && t.used+n > t.limit {
+		return ErrExceeded
+	}
+
+	t.used += n
+
+	return nil
+}
+
+// Shrink releases n bytes
+func (t *Tracker) Shrink(n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.used = max(t.used-n, 0)
+}
+
+func (t *Tracker) rescan() {
+	if t.dir == "" || time.Since(t.scanned) < RescanInterval {
+		return
+	}
+
+	var used int64
+
+	_ = filepath.WalkDir(t.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return nil
+		}
+
+		if info, err := entry.Info(); err == nil {
+			used += info.Size()
+		}
+
+		return nil
+	})
+
+	t.used = used
+	t.scanned = time.Now()
+}
@@ -0,0 +1,97 @@
+package quota
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, "existing"), make([]byte, 60), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tracker := New(dir, 100)
+
+	if used := tracker.Used(); used != 60 {
+		t.Fatalf("expected the existing file to be counted got %d", used)
+	}
+
+	if err := tracker.Grow(30); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tracker.Grow(20); !errors.Is(err, syscall.EDQUOT) {
+		t.Fatalf("expected the quota to be exceeded got %v", err)
+	}
+
+	if used := tracker.Used(); used != 90 {
+		t.Fatalf("expected a refused grow to claim nothing got %d", used)
+	}
+
+	tracker.Shrink(50)
+
+	if err := tracker.Grow(20); err != nil {
+		t.Fatal(err)
+	}
+
+	if tracker.Exceeded() {
+		t.Fatal("expected room to remain")
+	}
+}
+
+func TestTrackerRescan(t *testing.T) {
+	dir := t.TempDir()
+	tracker := New(dir, 100)
+
+	if tracker.Used() != 0 {
+		t.Fatal("expected an empty directory")
+	}
+
+	// written behind the tracker's back, e.g. by a container
+	if err := os.WriteFile(filepath.Join(dir, "container"), make([]byte, 100), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if tracker.Used() != 0 {
+		t.Fatal("expected the count to be reused until it is stale")
+	}
+
+	tracker.scanned = tracker.scanned.Add(-RescanInterval)
+
+	if !tracker.Exceeded() {
+		t.Fatalf("expected the rescan to find the container's write got %d", tracker.Used())
+	}
+}
+
+func TestTrackerUnlimited(t *testing.T) {
+	tracker := New(t.TempDir(), 0)
+
+	if err := tracker.Grow(1 << 40); err != nil || tracker.Exceeded() {
+		t.Fatalf("expected no limit got %v", err)
+	}
+}
+
+func TestTrackerCounter(t *testing.T) {
+	tracker := New("", 100)
+
+	if err := tracker.Grow(60); err != nil {
+		t.Fatal(err)
+	}
+
+	// with nothing to walk the count is never reset
+	tracker.scanned = time.Now().Add(-RescanInterval)
+
+	if used := tracker.Used(); used != 60 {
+		t.Fatalf("expected 60 bytes used got %d", used)
+	}
+
+	if err := tracker.Grow(60); err != ErrExceeded {
+		t.Fatalf("expected the limit to hold got %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package seed
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"strings"
+	"text/template"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/archimoebius/fishler/util/token"
+)
+
+const (
+	alphanumeric = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+	base32Upper  = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+	base64Chars  = alphanumeric + "+/"
+	hexChars     = "0123456789abcdef"
+	symbols      = "!@#$%&*?"
+)
+
+// IssueFunc is told of every honeytoken a template renders and the file it went into
+type IssueFunc func(kind token.Kind, value string, path string)
+
+// placeholderFuncs lets templates parse before the session they are rendered for is known
+var placeholderFuncs = Funcs(Data{}, "", "", nil)
+
+// Funcs are the random fake secrets a template may use, each call giving a new one - those marked
+// * are honeytokens handed to issue:
+//
+//	{{hex 32}}              32 hex characters
+//	{{alnum 20}}            20 letters and digits
+//	{{uuid}}                a random UUID
+//	{{password}}            * a password someone might pick
+//	{{awsAccessKeyID}}      * an AKIA... access key id
+//	{{awsSecretAccessKey}}  * a 40 character secret access key
+//	{{apiToken}}            * a ghp_... API token
+//	{{sshPrivateKey}}       * an OpenSSH ed25519 private key for user@hostname
+//	{{canaryHostname}}      * a host under domain
+//	{{databaseURL}}         * a postgres:// URL with a password on a canary host
+func Funcs(data Data, domain string, p string, issue IssueFunc) template.FuncMap {
+	issued := func(kind token.Kind, value string) string {
+		if issue != nil {
+			issue(kind, value, p)
+		}
+
+		return value
+	}
+
+	canaryHostname := func() string {
+		return issued(token.KindHostname, pick("db", "git", "vault", "backup", "jenkins", "prod")+"-"+random(hexChars, 6)+"."+domain)
+	}
+
+	return template.FuncMap{
+		"hex": func(n int) string {
+			return random(hexChars, n)
+		},
+		"alnum": func(n int) string {
+			return random(alphanumeric, n)
+		},
+		"uuid": func() string {
+			return uuid.NewString()
+		},
+		"password": func() string {
+			return issued(token.KindPassword, password())
+		},
+		"awsAccessKeyID": func() string {
+			return issued(token.KindAWSAccessKeyID, "AKIA"+random(base32Upper, 16))
+		},
+		"awsSecretAccessKey": func() string {
+			return issued(token.KindAWSSecretAccessKey, random(base64Chars, 40))
+		},
+		"apiToken": func() string {
+			return issued(token.KindAPIToken, "ghp_"+random(alphanumeric, 36))
+		},
+		"sshPrivateKey": func() (string, error) {
+			key, fingerprint, err := sshPrivateKey(data.User + "@" + data.Hostname)
+			if err != nil {
+				return "", err
+			}
+
+			issued(token.KindSSHKey, fingerprint)
+
+			return key, nil
+		},
+		"canaryHostname": canaryHostname,
+		"databaseURL": func() string {
+			u := url.URL{
+				Scheme: "postgres",
+				User:   url.UserPassword(pick("app", "postgres", "admin"), issued(token.KindPassword, password())),
+				Host:   canaryHostname() + ":5432",
+				Path:   "/" + pick("production", "app", "customers", "billing"),
+			}
+
+			return issued(token.KindDatabaseURL, u.String())
+		},
+	}
+}
+
+// random is n characters picked from chars
+func random(chars string, n int) string {
+	var b strings.Builder
+
+	for range n {
+		b.WriteString(pick(strings.Split(chars, "")...))
+	}
+
+	return b.String()
+}
+
+// pick is one of choices at random
+func pick(choices ...string) string {
+	idx, err := rand.Int(rand.Reader, big.NewInt(int64(len(choices))))
+	if err != nil {
+		panic(err)
+	}
+
+	return choices[idx.Int64()]
+}
+
+// password is a capitalised word, some digits and a symbol - the way people satisfy password rules
+func password() string {
+	word := pick("summer", "winter", "dragon", "monkey", "shadow", "master", "sunshine", "football", "welcome", "company", "server", "backup")
+
+	return strings.ToUpper(word[:1]) + word[1:] + random("0123456789", 4) + random(symbols, 1)
+}
+
+// sshPrivateKey is a new OpenSSH private key and the fingerprint of its public half
+func sshPrivateKey(comment string) (string, string, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+
+	block, err := ssh.MarshalPrivateKey(private, comment)
+	if err != nil {
+		return "", "", err
+	}
+
+	publicKey, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return "", "", err
+	}
+
+	return string(pem.EncodeToMemory(block)), ssh.FingerprintSHA256(publicKey), nil
+}
@@ -0,0 +1,311 @@
+package seed
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Trees of a seed - users/<name>/<tree> is layered over <tree> for that username
+const (
+	TreeHome = "home"
+	TreeRoot = "root"
+)
+
+// TemplateSuffix marks a file rendered with Data before it is applied, the suffix is dropped
+const TemplateSuffix = ".tmpl"
+
+// Data is what a seed template is rendered with
+type Data struct {
+	User      string
+	Hostname  string
+	SessionID string
+	Address   string
+}
+
+// File is a file or directory of a rendered seed
+type File struct {
+	Name    string
+	Mode    fs.FileMode
+	ModTime time.Time
+	Body    []byte
+}
+
+// Seed is a tree of bait files applied to each session, loaded from a directory or a (gzipped) tar -
+// canary hostnames are made under Domain
+type Seed struct {
+	Domain  string
+	entries []entry
+}
+
+type entry struct {
+	name     string
+	mode     fs.FileMode
+	modTime  time.Time
+	body     []byte
+	template *template.Template
+}
+
+// Load reads the seed at p - a directory, or a tar when named .tar, .tar.gz or .tgz
+func Load(p string) (*Seed, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Seed{Domain: "internal"}
+
+	if info.IsDir() {
+		err = s.loadDir(p)
+	} else {
+		err = s.loadTar(p)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("seed %s: %w", p, err)
+	}
+
+	return s, nil
+}
+
+func (s *Seed) loadDir(p string) error {
+	return fs.WalkDir(os.DirFS(p), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || name == "." {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		var body []byte
+
+		if info.Mode().IsRegular() {
+			body, err = os.ReadFile(path.Join(p, name)) // #nosec
+			if err != nil {
+				return err
+			}
+		}
+
+		return s.add(name, info.Mode(), info.ModTime(), body)
+	})
+}
+
+func (s *Seed) loadTar(p string) error {
+	file, err := os.Open(p) // #nosec
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+
+	switch {
+	case strings.HasSuffix(p, ".tar.gz"), strings.HasSuffix(p, ".tgz"):
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+
+		r = gz
+	case !strings.HasSuffix(p, ".tar"):
+		return errors.New("expected a directory, .tar, .tar.gz or .tgz")
+	}
+
+	tr := tar.NewReader(r)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+
+		if err := s.add(header.Name, header.FileInfo().Mode(), header.ModTime, body); err != nil {
+			return err
+		}
+	}
+}
+
+// add keeps the directories and regular files of the seed - anything else is skipped
+func (s *Seed) add(name string, mode fs.FileMode, modTime time.Time, body []byte) error {
+	name = path.Clean(strings.TrimPrefix(name, "./"))
+
+	if !fs.ValidPath(name) || name == "." {
+		return fmt.Errorf("bad path %q", name)
+	}
+
+	if !mode.IsDir() && !mode.IsRegular() {
+		return nil
+	}
+
+	e := entry{
+		name:    name,
+		mode:    mode,
+		modTime: modTime,
+		body:    body,
+	}
+
+	if mode.IsRegular() && strings.HasSuffix(name, TemplateSuffix) {
+		t, err := template.New(name).Funcs(placeholderFuncs).Parse(string(body))
+		if err != nil {
+			return err
+		}
+
+		e.name = strings.TrimSuffix(name, TemplateSuffix)
+		e.template = t
+	}
+
+	s.entries = append(s.entries, e)
+
+	return nil
+}
+
+// Render renders the tree (TreeHome or TreeRoot) for data.User, their own files replacing those of
+// the same name, handing each honeytoken to issue (if not nil) - directories are ordered before what
+// they hold
+func (s *Seed) Render(tree string, data Data, issue IssueFunc) ([]File, error) {
+	entries := map[string]entry{}
+
+	for _, prefix := range []string{tree + "/", path.Join("users", data.User, tree) + "/"} {
+		for _, e := range s.entries {
+			if name, ok := strings.CutPrefix(e.name, prefix); ok {
+				entries[name] = e
+			}
+		}
+	}
+
+	// where the file lands as the attacker sees it
+	location := "/"
+	if tree == TreeHome {
+		location = "~/"
+	}
+
+	rendered := make([]File, 0, len(entries))
+
+	for name, e := range entries {
+		f := File{
+			Name:    name,
+			Mode:    e.mode,
+			ModTime: e.modTime,
+			Body:    e.body,
+		}
+
+		if e.template != nil {
+			t, err := e.template.Clone()
+			if err != nil {
+				return nil, err
+			}
+
+			var b bytes.Buffer
+
+			if err := t.Funcs(Funcs(data, s.Domain, location+name, issue)).Execute(&b, data); err != nil {
+				return nil, err
+			}
+
+			f.Body = b.Bytes()
+		}
+
+		rendered = append(rendered, f)
+	}
+
+	slices.SortFunc(rendered, func(a, b File) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return rendered, nil
+}
+
+// Apply writes the files under dir, replacing any already there
+func Apply(dir string, files []File) error {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	for _, f := range files {
+		if f.Mode.IsDir() {
+			if err := root.MkdirAll(f.Name, f.Mode.Perm()); err != nil {
+				return err
+			}
+		} else {
+			if err := root.MkdirAll(path.Dir(f.Name), 0755); err != nil {
+				return err
+			}
+
+			if err := root.WriteFile(f.Name, f.Body, f.Mode.Perm()); err != nil {
+				return err
+			}
+		}
+
+		if err := root.Chmod(f.Name, f.Mode.Perm()); err != nil {
+			return err
+		}
+
+		if err := root.Chtimes(f.Name, f.ModTime, f.ModTime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Tar packs the files for copying into a container
+func Tar(files []File) ([]byte, error) {
+	var buf bytes.Buffer
+
+	tw := tar.NewWriter(&buf)
+
+	for _, f := range files {
+		header := &tar.Header{
+			Name:     f.Name,
+			Mode:     int64(f.Mode.Perm()),
+			ModTime:  f.ModTime,
+			Typeflag: tar.TypeReg,
+			Size:     int64(len(f.Body)),
+		}
+
+		if f.Mode.IsDir() {
+			header.Name += "/"
+			header.Typeflag = tar.TypeDir
+			header.Size = 0
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+
+		if !f.Mode.IsDir() {
+			if _, err := tw.Write(f.Body); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
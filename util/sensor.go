@@ -0,0 +1,121 @@
+package util
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	config "github.com/archimoebius/fishler/cli/config/serve"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Sensor identifies this fishler install to anything collecting its events
+type Sensor struct {
+	UUID uuid.UUID
+	Name string
+	Tags []string
+}
+
+// Fields returns the sensor identity as log fields
+func (s Sensor) Fields() logrus.Fields {
+	return logrus.Fields{
+		"sensor_uuid": s.UUID.String(),
+		"sensor_name": s.Name,
+		"sensor_tags": strings.Join(s.Tags, ","),
+	}
+}
+
+// GetSensor returns the configured sensor identity - generating and persisting a UUID on first run
+func GetSensor() (Sensor, error) {
+	sensor := Sensor{
+		Name: config.Setting.SensorName,
+		Tags: config.Setting.SensorTags,
+	}
+
+	if sensor.Name == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return sensor, err
+		}
+		sensor.Name = hostname
+	}
+
+	id, err := getSensorUUID()
+	if err != nil {
+		return sensor, err
+	}
+	sensor.UUID = id
+
+	return sensor, nil
+}
+
+func GetSensorUUIDPath() string {
+	err := os.MkdirAll(config.Setting.CryptoBasepath, 0750)
+	if err != nil {
+		Logger.Fatal(err)
+	}
+
+	return fmt.Sprintf("%s/sensor_uuid", config.Setting.CryptoBasepath)
+}
+
+func getSensorUUID() (uuid.UUID, error) {
+	if config.Setting.SensorUUID != "" {
+		return uuid.Parse(config.Setting.SensorUUID)
+	}
+
+	data, err := os.ReadFile(GetSensorUUIDPath())
+
+	if err == nil {
+		return uuid.Parse(strings.TrimSpace(string(data)))
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return uuid.Nil, err
+	}
+
+	id := uuid.New()
+
+	if err := os.WriteFile(GetSensorUUIDPath(), []byte(id.String()+"\n"), 0600); err != nil {
+		return uuid.Nil, err
+	}
+
+	return id, nil
+}
+
+func GetProfileSecretPath() string {
+	err := os.MkdirAll(config.Setting.CryptoBasepath, 0750)
+	if err != nil {
+		Logger.Fatal(err)
+	}
+
+	return fmt.Sprintf("%s/profile_secret", config.Setting.CryptoBasepath)
+}
+
+// GetProfileSecret returns the key the generated account profiles are picked with - generated and
+// persisted on first run so no two sensors hand out the same /etc/shadow
+func GetProfileSecret() ([]byte, error) {
+	secret, err := os.ReadFile(GetProfileSecretPath())
+
+	if err == nil {
+		return secret, nil
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	secret = make([]byte, 32)
+
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(GetProfileSecretPath(), secret, 0600); err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
@@ -0,0 +1,48 @@
+package util
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestGetSensor(t *testing.T) {
+	first, err := GetSensor()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.UUID == uuid.Nil {
+		t.Fatal("expected a generated sensor UUID")
+	}
+
+	second, err := GetSensor()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.UUID != second.UUID {
+		t.Fatalf("expected the sensor UUID to persist got %s and %s", first.UUID, second.UUID)
+	}
+}
+
+func TestGetProfileSecret(t *testing.T) {
+	first, err := GetProfileSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(first) != 32 {
+		t.Fatalf("expected a generated 32 byte secret got %d bytes", len(first))
+	}
+
+	second, err := GetProfileSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(first, second) {
+		t.Fatal("expected the profile secret to persist")
+	}
+}
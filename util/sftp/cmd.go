@@ -3,55 +3,74 @@ package sftp
 import (
 	"errors"
 	"os"
+	"time"
 
 	"github.com/pkg/sftp"
+
+	"github.com/archimoebius/fishler/util"
 )
 
 func (fs FishlerFS) Filecmd(request *sftp.Request) error {
 	fs.logInfo(request, "sftp filecmd")
 
-	p, err := fs.GetDockerVolumnPath(fs, request.Filepath)
+	// FishyFS has no links of either kind - refused as a filesystem without them would be
+	switch request.Method {
+	case "Symlink", "Link":
+		return sftp.ErrSSHFxOpUnsupported
+	}
+
+	root, p, err := fs.root(request.Filepath)
 	if err != nil {
 		fs.logError(request, "sftp filecmd error", err)
 		return sftp.ErrSSHFxNoSuchFile
 	}
+	defer root.Close()
 
 	var target string = ""
 
 	if request.Target != "" {
-		target, err = fs.GetDockerVolumnPath(fs, request.Target)
+		target, err = fs.hostName(request.Target)
 		if err != nil {
 			fs.logError(request, "sftp filecmd error", err)
-			return sftp.ErrSSHFxOpUnsupported
+			return sftp.ErrSSHFxPermissionDenied
 		}
 	}
 
 	switch request.Method {
 	case "Setstat":
-		var mode os.FileMode = 0644
-
-		if request.Attributes().FileMode().Perm() != 0000 {
-			mode = request.Attributes().FileMode().Perm()
-		}
-
-		if request.Attributes().FileMode().IsDir() {
-			mode = 0755
+		return fs.setstat(request, root, p)
+	case "Rename":
+		// unlike rename(2) an SFTP rename never replaces an existing file
+		if _, err := root.Lstat(target); err == nil {
+			return sftp.ErrSSHFxFailure
 		}
 
-		if err := os.Chmod(p, mode); err != nil {
+		if err := root.Rename(p, target); err != nil {
 			fs.logError(request, "sftp filecmd error", err)
-			return sftp.ErrSSHFxFailure
+			return statusError(err)
 		}
+
 		return nil
-	case "Rename":
-		if err := os.Rename(p, target); err != nil {
+	case "PosixRename":
+		// a regular file replaced gives its space back - unless it is the file being renamed
+		var replaced int64
+
+		if info, err := root.Lstat(target); err == nil && info.Mode().IsRegular() {
+			if source, err := root.Lstat(p); err == nil && !os.SameFile(source, info) {
+				replaced = info.Size()
+			}
+		}
+
+		if err := root.Rename(p, target); err != nil {
 			fs.logError(request, "sftp filecmd error", err)
-			return sftp.ErrSSHFxFailure
+			return statusError(err)
 		}
 
+		fs.Quota.Shrink(replaced)
+
 		return nil
 	case "Remove":
-		info, err := os.Stat(p)
+		info, err := root.Lstat(p)
 		if err != nil {
 			if os.IsNotExist(err) {
 				return sftp.ErrSSHFxNoSuchFile
@@ -64,16 +83,20 @@ func (fs FishlerFS) Filecmd(request *sftp.Request) error {
 			return sftp.ErrSSHFxFailure
 		}
 
-		if err := os.Remove(p); err != nil {
+		if err := root.Remove(p); err != nil {
 			fs.logError(request, "sftp filecmd error", err)
-			return sftp.ErrSSHFxFailure
+			return statusError(err)
+		}
+
+		if info.Mode().IsRegular() {
+			fs.Quota.Shrink(info.Size())
 		}
 
 		return sftp.ErrSSHFxOk
 
 	case "Rmdir":
 
-		info, err := os.Stat(p)
+		info, err := root.Lstat(p)
 		if err != nil {
 			if os.IsNotExist(err) {
 				return sftp.ErrSSHFxNoSuchFile
@@ -86,23 +109,24 @@ func (fs FishlerFS) Filecmd(request *sftp.Request) error {
 			return sftp.ErrSSHFxFailure
 		}
 
-		if err := os.RemoveAll(p); err != nil {
+		// like rmdir(2) only an empty directory is removed
+		if err := root.Remove(p); err != nil {
 			fs.logError(request, "sftp filecmd error", err)
-			return sftp.ErrSSHFxFailure
+			return statusError(err)
 		}
 
 		return sftp.ErrSSHFxOk
 
 	case "Mkdir":
-		if err := os.MkdirAll(p, 0750); err != nil {
-			fs.logError(request, "sftp filecmd error", err)
-			return sftp.ErrSSHFxFailure
+		var mode os.FileMode = 0755
+
+		if request.AttrFlags().Permissions {
+			mode = request.Attributes().FileMode().Perm()
 		}
 
-		return nil
-	case "Symlink":
-		if err := os.Symlink(p, target); err != nil {
-			return sftp.ErrSSHFxFailure
+		if err := root.Mkdir(p, mode); err != nil {
+			fs.logError(request, "sftp filecmd error", err)
+			return statusError(err)
 		}
 
 		return nil
@@ -111,3 +135,121 @@ func (fs FishlerFS) Filecmd(request *sftp.Request) error {
 		return sftp.ErrSSHFxOpUnsupported
 	}
 }
+
+// PosixRename is Rename replacing any existing file
+func (fs FishlerFS) PosixRename(request *sftp.Request) error {
+	return fs.Filecmd(request)
+}
+
+// setstat applies whichever of the mode, ownership, times and size the client sent
+func (fs FishlerFS) setstat(request *sftp.Request, root *os.Root, p string) error {
+	flags := request.AttrFlags()
+	attributes := request.Attributes()
+
+	if flags.Size {
+		info, err := root.Stat(p)
+		if err != nil {
+			fs.logError(request, "sftp filecmd error", err)
+			return statusError(err)
+		}
+
+		grow := int64(attributes.Size) - info.Size() // #nosec
+
+		if grow > 0 {
+			if err := fs.Quota.Grow(grow); err != nil {
+				fs.logError(request, "sftp filecmd error", err)
+				return err
+			}
+		}
+
+		if err := truncate(root, p, int64(attributes.Size)); err != nil { // #nosec
+			if grow > 0 {
+				fs.Quota.Shrink(grow)
+			}
+
+			fs.logError(request, "sftp filecmd error", err)
+			return statusError(err)
+		}
+
+		if grow < 0 {
+			fs.Quota.Shrink(-grow)
+		}
+	}
+
+	if flags.Permissions {
+		// as the user a real server runs as, setuid and setgid bits can't be set on what is bind
+		// mounted into the container
+		mode := attributes.FileMode() & (os.ModePerm | os.ModeSticky)
+
+		if err := root.Chmod(p, mode); err != nil {
+			fs.logError(request, "sftp filecmd error", err)
+			return statusError(err)
+		}
+	}
+
+	if flags.UidGid {
+		// the user may only give files to themselves
+		if id := uint32(util.ProfileID(fs.User)); attributes.UID != id || attributes.GID != id { // #nosec G115 -- 0 or 1000
+			fs.logError(request, "sftp filecmd error", os.ErrPermission)
+			return sftp.ErrSSHFxPermissionDenied
+		}
+
+		if err := root.Lchown(p, int(attributes.UID), int(attributes.GID)); err != nil {
+			fs.logError(request, "sftp filecmd error", err)
+			return statusError(err)
+		}
+	}
+
+	if flags.Acmodtime {
+		if err := root.Chtimes(p, time.Unix(int64(attributes.Atime), 0), time.Unix(int64(attributes.Mtime), 0)); err != nil {
+			fs.logError(request, "sftp filecmd error", err)
+			return statusError(err)
+		}
+	}
+
+	return nil
+}
+
+// truncate is os.Truncate within root
+func truncate(root *os.Root, name string, size int64) error {
+	file, err := root.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	if err := file.Truncate(size); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// StatVFS reports the user's disk quota as the filesystem's size
+func (fs FishlerFS) StatVFS(request *sftp.Request) (*sftp.StatVFS, error) {
+	fs.logInfo(request, "sftp statvfs")
+
+	if fs.Quota.Limit() == 0 {
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+
+	return statVFS(fs.Quota.Used(), fs.Quota.Limit()), nil
+}
+
+func statVFS(used int64, limit int64) *sftp.StatVFS {
+	const blockSize = 4096
+
+	free := max(limit-used, 0)
+
+	return &sftp.StatVFS{
+		Bsize:   blockSize,
+		Frsize:  blockSize,
+		Blocks:  uint64(limit / blockSize), // #nosec
+		Bfree:   uint64(free / blockSize),  // #nosec
+		Bavail:  uint64(free / blockSize),  // #nosec
+		Files:   1 << 20,
+		Ffree:   1 << 19,
+		Favail:  1 << 19,
+		Namemax: 255,
+	}
+}
@@ -0,0 +1,280 @@
+package sftp
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// attributes marshals the SETSTAT attribute values (each a big-endian uint32) pkg/sftp hands the handler
+func attributes(values ...uint32) []byte {
+	var b []byte
+
+	for _, v := range values {
+		b = append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+
+	return b
+}
+
+func TestSymlink(t *testing.T) {
+	fs, _, _ := testFS(t)
+
+	// FishyFS has no links of either kind
+	for _, method := range []string{"Symlink", "Link"} {
+		err := fs.Filecmd(&sftp.Request{Method: method, Filepath: "/root/a", Target: "/root/link"})
+		if err != sftp.ErrSSHFxOpUnsupported {
+			t.Fatalf("%s: expected unsupported got %v", method, err)
+		}
+	}
+}
+
+func TestSymlinkEscape(t *testing.T) {
+	fs, home, _ := testFS(t)
+
+	outside := filepath.Join(t.TempDir(), "passwd")
+	if err := os.WriteFile(outside, []byte("root:x:0:0::/root:/bin/sh\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(outside, filepath.Join(home, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the link itself can be inspected
+	lister, err := fs.Filelist(&sftp.Request{Method: "Readlink", Filepath: "/root/link"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infos := make([]os.FileInfo, 1)
+	if _, err := lister.ListAt(infos, 0); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+
+	if infos[0].Name() != outside {
+		t.Fatalf("unexpected readlink %q", infos[0].Name())
+	}
+
+	lister, err = fs.Lstat(&sftp.Request{Method: "Lstat", Filepath: "/root/link"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := lister.ListAt(infos, 0); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+
+	if infos[0].Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("expected a link got %v", infos[0].Mode())
+	}
+
+	// but nothing follows it out of the home
+	if _, err := fs.Fileread(&sftp.Request{Method: "Get", Filepath: "/root/link"}); err == nil {
+		t.Fatal("expected reading through the link to fail")
+	}
+
+	if _, err := fs.Filelist(&sftp.Request{Method: "Stat", Filepath: "/root/link"}); err == nil {
+		t.Fatal("expected stat through the link to fail")
+	}
+
+	if _, err := fs.Filewrite(&sftp.Request{Method: "Put", Filepath: "/root/link", Flags: 0x2 | 0x8 | 0x10}); err == nil {
+		t.Fatal("expected writing through the link to fail")
+	}
+
+	err = fs.Filecmd(&sftp.Request{Method: "Setstat", Filepath: "/root/link", Flags: 0x1 | 0x4, Attrs: attributes(0, 0, 0777)})
+	if err == nil {
+		t.Fatal("expected setstat through the link to fail")
+	}
+
+	info, err := os.Stat(outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Size() == 0 || info.Mode().Perm() != 0600 {
+		t.Fatalf("the file outside the home was changed %v %v", info.Size(), info.Mode())
+	}
+}
+
+func TestRename(t *testing.T) {
+	fs, home, _ := testFS(t)
+
+	for _, name := range []string{"a", "b"} {
+		if err := os.WriteFile(filepath.Join(home, name), []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := fs.Filecmd(&sftp.Request{Method: "Rename", Filepath: "/root/a", Target: "/root/b"}); err != sftp.ErrSSHFxFailure {
+		t.Fatalf("expected rename onto an existing file to fail got %v", err)
+	}
+
+	if used := fs.Quota.Used(); used != 2 {
+		t.Fatalf("expected 2 bytes used got %d", used)
+	}
+
+	if err := fs.PosixRename(&sftp.Request{Method: "PosixRename", Filepath: "/root/a", Target: "/root/b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if used := fs.Quota.Used(); used != 1 {
+		t.Fatalf("expected the replaced file to be given back got %d", used)
+	}
+
+	if content, _ := os.ReadFile(filepath.Join(home, "b")); string(content) != "a" {
+		t.Fatalf("expected b to be replaced got %q", content)
+	}
+}
+
+func TestSetstat(t *testing.T) {
+	fs, home, _ := testFS(t)
+
+	if err := os.WriteFile(filepath.Join(home, "f"), []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// size, permissions and times
+	const flags = 0x1 | 0x4 | 0x8
+
+	err := fs.Filecmd(&sftp.Request{
+		Method:   "Setstat",
+		Filepath: "/root/f",
+		Flags:    flags,
+		Attrs:    attributes(0, 2, 0640, 1600000000, 1600000000),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(home, "f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Size() != 2 || info.Mode().Perm() != 0640 || !info.ModTime().Equal(time.Unix(1600000000, 0)) {
+		t.Fatalf("unexpected stat %v %v %v", info.Size(), info.Mode(), info.ModTime())
+	}
+}
+
+func TestSetstatPrivileges(t *testing.T) {
+	fs, home, _ := testFS(t)
+
+	if err := os.WriteFile(filepath.Join(home, "f"), []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// permissions alone
+	if err := fs.Filecmd(&sftp.Request{Method: "Setstat", Filepath: "/root/f", Flags: 0x4, Attrs: attributes(0o4755)}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(home, "f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode()&os.ModeSetuid != 0 || info.Mode().Perm() != 0755 {
+		t.Fatalf("expected the setuid bit to be dropped got %v", info.Mode())
+	}
+
+	// ownership alone - the test user is root
+	err = fs.Filecmd(&sftp.Request{Method: "Setstat", Filepath: "/root/f", Flags: 0x2, Attrs: attributes(1000, 1000)})
+	if err != sftp.ErrSSHFxPermissionDenied {
+		t.Fatalf("expected giving the file away to be denied got %v", err)
+	}
+}
+
+func TestOpenFlags(t *testing.T) {
+	fs, home, _ := testFS(t)
+
+	if err := os.WriteFile(filepath.Join(home, "f"), []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// append without truncation - SSH_FXF_WRITE|SSH_FXF_APPEND
+	w, err := fs.Filewrite(&sftp.Request{Method: "Put", Filepath: "/root/f", Flags: 0x2 | 0x4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.WriteAt([]byte(" world"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = w.(io.Closer).Close()
+
+	if content, _ := os.ReadFile(filepath.Join(home, "f")); string(content) != "hello world" {
+		t.Fatalf("expected the write to be appended got %q", content)
+	}
+
+	// exclusive create of an existing file - SSH_FXF_WRITE|SSH_FXF_CREAT|SSH_FXF_EXCL
+	if _, err := fs.Filewrite(&sftp.Request{Method: "Put", Filepath: "/root/f", Flags: 0x2 | 0x8 | 0x20}); err != sftp.ErrSSHFxFailure {
+		t.Fatalf("expected an exclusive create to fail got %v", err)
+	}
+
+	// writing a missing file without SSH_FXF_CREAT
+	if _, err := fs.Filewrite(&sftp.Request{Method: "Put", Filepath: "/root/missing", Flags: 0x2}); err != sftp.ErrSSHFxNoSuchFile {
+		t.Fatalf("expected no such file got %v", err)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	fs, _, _ := testFS(t)
+
+	w, err := fs.Filewrite(&sftp.Request{Method: "Put", Filepath: "/root/big", Flags: 0x2 | 0x8 | 0x10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.(io.Closer).Close()
+
+	chunk := make([]byte, 1<<20)
+
+	for idx := range 4 {
+		if _, err := w.WriteAt(chunk, int64(idx)<<20); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// rewriting what is already there is free
+	if _, err := w.WriteAt(chunk, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.WriteAt(chunk[:1], 4<<20); !errors.Is(err, syscall.EDQUOT) {
+		t.Fatalf("expected the write past the quota to be refused mid-transfer got %v", err)
+	}
+
+	if err := fs.Filecmd(&sftp.Request{Method: "Remove", Filepath: "/root/big"}); err != sftp.ErrSSHFxOk {
+		t.Fatal(err)
+	}
+
+	if fs.Quota.Used() != 0 {
+		t.Fatalf("expected the removal to be credited got %d", fs.Quota.Used())
+	}
+}
+
+func TestStatVFS(t *testing.T) {
+	fs, home, _ := testFS(t)
+
+	if err := os.WriteFile(filepath.Join(home, "f"), make([]byte, 1<<20), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// the tracker is fresh so counts the file on first use
+
+	stat, err := fs.StatVFS(&sftp.Request{Method: "StatVFS", Filepath: "/root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stat.TotalSpace() != 4<<20 || stat.FreeSpace() != 3<<20 {
+		t.Fatalf("unexpected statvfs %+v", stat)
+	}
+}
@@ -0,0 +1,616 @@
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/sirupsen/logrus"
+
+	"github.com/archimoebius/fishler/util"
+	"github.com/archimoebius/fishler/util/event"
+	"github.com/archimoebius/fishler/util/quota"
+)
+
+// statFormat is the stat(1) format parsed by parseStat - the name comes last as it may hold spaces
+const statFormat = "%f %s %Y %u %g %n"
+
+// ErrTooLarge is returned when a transfer exceeds ContainerFS.MaxSize
+var ErrTooLarge = errors.New("file too large")
+
+// Runner runs commands in the session's container as the session's user
+type Runner interface {
+	Exec(ctx context.Context, cmd []string, stdin io.Reader, stdout io.Writer) error
+}
+
+// ContainerFS serves SFTP from the whole filesystem of a container started for the session - every
+// operation runs as the user so permissions are those of a real server and uploads are vaulted as they land
+//
+// Transfers are spooled on the host, in the directory Spool tracks, while they are copied in or out
+// of the container. Uploads into the user's home are charged to their quota, those anywhere else in
+// the container to ContainerQuota
+type ContainerFS struct {
+	FishlerFS
+	Container      Runner
+	Context        context.Context
+	MaxSize        int64
+	Spool          *quota.Tracker
+	ContainerQuota *quota.Tracker
+}
+
+// spool creates a spool file charged to fs.Spool
+func (fs ContainerFS) spool() (*spoolFile, error) {
+	file, err := os.CreateTemp(fs.Spool.Dir(), "fishler-sftp-*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &spoolFile{countingFile: countingFile{file}, quota: fs.Spool}, nil
+}
+
+// tracker is what an upload to p is charged to - the home's tracker sees what is on disk there when
+// it walks it, the container's only what it was told so is kept apart
+func (fs ContainerFS) tracker(p string) *quota.Tracker {
+	if _, err := fs.hostName(p); err == nil {
+		return fs.Quota
+	}
+
+	return fs.ContainerQuota
+}
+
+// homeSize is the size of the regular file p in the user's home - zero for anything else
+func (fs ContainerFS) homeSize(p string) int64 {
+	root, name, err := fs.root(p)
+	if err != nil {
+		return 0
+	}
+	defer root.Close()
+
+	info, err := root.Lstat(name)
+	if err != nil || !info.Mode().IsRegular() {
+		return 0
+	}
+
+	return info.Size()
+}
+
+func (fs ContainerFS) exec(cmd []string, stdin io.Reader, stdout io.Writer) error {
+	return fs.Container.Exec(fs.Context, cmd, stdin, stdout)
+}
+
+// containerError maps a failed command onto the SFTP status a real server would send
+func containerError(err error) error {
+	var execErr *util.ExecError
+
+	if errors.As(err, &execErr) {
+		switch {
+		case strings.Contains(execErr.Stderr, "No such file"):
+			return sftp.ErrSSHFxNoSuchFile
+		case strings.Contains(execErr.Stderr, "Permission denied"),
+			strings.Contains(execErr.Stderr, "Operation not permitted"),
+			strings.Contains(execErr.Stderr, "Read-only file system"):
+			return sftp.ErrSSHFxPermissionDenied
+		}
+	}
+
+	return sftp.ErrSSHFxFailure
+}
+
+func (fs ContainerFS) Filecmd(request *sftp.Request) error {
+	fs.logInfo(request, "sftp filecmd")
+
+	var cmds [][]string
+
+	switch request.Method {
+	case "Setstat":
+		flags := request.AttrFlags()
+		attributes := request.Attributes()
+
+		if flags.Permissions {
+			cmds = append(cmds, []string{"chmod", strconv.FormatUint(uint64(attributes.FileMode().Perm()), 8), "--", request.Filepath})
+		}
+
+		if flags.Size {
+			cmds = append(cmds, []string{"truncate", "-s", strconv.FormatUint(attributes.Size, 10), "--", request.Filepath})
+		}
+
+		if flags.UidGid {
+			cmds = append(cmds, []string{"chown", "-h", fmt.Sprintf("%d:%d", attributes.UID, attributes.GID), "--", request.Filepath})
+		}
+
+		if flags.Acmodtime {
+			cmds = append(cmds, []string{"touch", "-c", "-d", attributes.ModTime().UTC().Format(time.DateTime), "--", request.Filepath})
+		}
+	case "Rename":
+		// unlike rename(2) an SFTP rename never replaces an existing file
+		cmds = append(cmds, []string{"sh", "-c", `if [ -e "$2" ] || [ -L "$2" ]; then echo "$2: File exists" >&2; exit 1; fi; mv -f -- "$1" "$2"`, "sh", request.Filepath, request.Target})
+	case "PosixRename":
+		cmds = append(cmds, []string{"mv", "-f", "--", request.Filepath, request.Target})
+	case "Remove":
+		cmds = append(cmds, []string{"rm", "--", request.Filepath})
+	case "Rmdir":
+		cmds = append(cmds, []string{"rmdir", "--", request.Filepath})
+	case "Mkdir":
+		mode := "755"
+
+		if request.AttrFlags().Permissions {
+			mode = strconv.FormatUint(uint64(request.Attributes().FileMode().Perm()), 8)
+		}
+
+		cmds = append(cmds, []string{"mkdir", "-m", mode, "--", request.Filepath})
+	case "Symlink":
+		// Filepath is what the link points at and Target the link itself
+		cmds = append(cmds, []string{"ln", "-s", "--", request.Filepath, request.Target})
+	case "Link":
+		cmds = append(cmds, []string{"ln", "--", request.Filepath, request.Target})
+	default:
+		fs.logError(request, "sftp filecmd error", errors.New("unknown SFTP method requested"))
+		return sftp.ErrSSHFxOpUnsupported
+	}
+
+	for _, cmd := range cmds {
+		if err := fs.exec(cmd, nil, nil); err != nil {
+			fs.logError(request, "sftp filecmd error", err)
+			return containerError(err)
+		}
+	}
+
+	return nil
+}
+
+func (fs ContainerFS) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
+	fs.logInfo(request, "sftp filelist")
+
+	switch request.Method {
+	case "List":
+		// the trailing slash lists the target of a link to a directory
+		directory := strings.TrimSuffix(request.Filepath, "/") + "/"
+
+		infos, err := fs.stat([]string{"find", directory, "-mindepth", "1", "-maxdepth", "1", "-exec", "stat", "-c", statFormat, "{}", "+"})
+		if err != nil {
+			fs.logError(request, "sftp filelist error", err)
+			return nil, containerError(err)
+		}
+
+		return ListerAt(infos), nil
+	case "Stat":
+		infos, err := fs.stat([]string{"stat", "-L", "-c", statFormat, "--", request.Filepath})
+		if err != nil {
+			fs.logError(request, "sftp filelist error", err)
+			return nil, containerError(err)
+		}
+
+		return ListerAt(infos), nil
+	case "Readlink":
+		var target bytes.Buffer
+
+		if err := fs.exec([]string{"readlink", "--", request.Filepath}, nil, &target); err != nil {
+			fs.logError(request, "sftp filelist error", err)
+			return nil, containerError(err)
+		}
+
+		return ListerAt([]os.FileInfo{linkInfo(strings.TrimSuffix(target.String(), "\n"))}), nil
+	default:
+	}
+
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// Lstat is Stat without following a final symbolic link
+func (fs ContainerFS) Lstat(request *sftp.Request) (sftp.ListerAt, error) {
+	fs.logInfo(request, "sftp filelist")
+
+	infos, err := fs.stat([]string{"stat", "-c", statFormat, "--", request.Filepath})
+	if err != nil {
+		fs.logError(request, "sftp filelist error", err)
+		return nil, containerError(err)
+	}
+
+	return ListerAt(infos), nil
+}
+
+func (fs ContainerFS) stat(cmd []string) ([]os.FileInfo, error) {
+	var out bytes.Buffer
+
+	if err := fs.exec(cmd, nil, &out); err != nil {
+		return nil, err
+	}
+
+	var infos []os.FileInfo
+
+	for line := range strings.SplitSeq(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+
+		info, err := parseStat(line)
+		if err != nil {
+			continue
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+func (fs ContainerFS) Fileread(request *sftp.Request) (io.ReaderAt, error) {
+	fs.logInfo(request, "sftp read")
+
+	spool, err := fs.spool()
+	if err != nil {
+		fs.logError(request, "sftp read error", err)
+		return nil, sftp.ErrSSHFxFailure
+	}
+
+	err = fs.exec([]string{"cat", "--", request.Filepath}, nil, &limitWriter{w: spool, limit: fs.MaxSize})
+	if err != nil {
+		_ = spool.Close()
+		fs.logError(request, "sftp read error", err)
+		return nil, containerError(err)
+	}
+
+	return &auditedFile{
+		fileHandle: spool,
+		audit:      fs.audit("sftp", DirectionDownload, request.Filepath),
+		digest:     func() (string, int64, error) { return digestFile(spool.File) },
+	}, nil
+}
+
+func (fs ContainerFS) Filewrite(request *sftp.Request) (io.WriterAt, error) {
+	return fs.open(request)
+}
+
+// OpenFile opens a file for both reading and writing
+func (fs ContainerFS) OpenFile(request *sftp.Request) (sftp.WriterAtReaderAt, error) {
+	return fs.open(request)
+}
+
+func (fs ContainerFS) open(request *sftp.Request) (sftp.WriterAtReaderAt, error) {
+	pflags := request.Pflags()
+
+	// open the file as the user up front so errors come back on open - the redirects create the file
+	// and > truncates it
+	script := `: >> "$1"`
+
+	if pflags.Trunc {
+		script = `: > "$1"`
+	}
+
+	// noclobber makes > fail on an existing file
+	if pflags.Excl {
+		script = `set -C; : > "$1"`
+	}
+
+	if !pflags.Creat {
+		script = `[ -e "$1" ] || { echo "$1: No such file or directory" >&2; exit 1; }; ` + script
+	}
+
+	var truncated int64
+
+	if pflags.Trunc {
+		truncated = fs.homeSize(request.Filepath)
+	}
+
+	if err := fs.exec([]string{"sh", "-c", script, "sh", request.Filepath}, nil, nil); err != nil {
+		fs.logError(request, "sftp write error", err)
+		return nil, containerError(err)
+	}
+
+	fs.Quota.Shrink(truncated)
+
+	spool, err := fs.spool()
+	if err != nil {
+		fs.logError(request, "sftp write error", err)
+		return nil, sftp.ErrSSHFxFailure
+	}
+
+	// without truncation the upload resumes, or appends to, what is already there
+	if !pflags.Trunc {
+		err := fs.exec([]string{"cat", "--", request.Filepath}, nil, &limitWriter{w: spool, limit: fs.MaxSize})
+		if err != nil {
+			_ = spool.Close()
+			fs.logError(request, "sftp write error", err)
+			return nil, containerError(err)
+		}
+	}
+
+	upload := &containerUpload{
+		spoolFile: spool,
+		fs:        fs,
+		quota:     fs.tracker(request.Filepath),
+		request:   request,
+		append:    pflags.Append,
+		size:      spool.size,
+	}
+
+	fs.logInfo(request, "sftp write")
+
+	return &auditedFile{
+		fileHandle: upload,
+		audit:      fs.audit("sftp", DirectionUpload, request.Filepath),
+		digest:     func() (string, int64, error) { return digestFile(spool.File) },
+	}, nil
+}
+
+// PosixRename is Rename replacing any existing file
+func (fs ContainerFS) PosixRename(request *sftp.Request) error {
+	return fs.Filecmd(request)
+}
+
+// StatVFS reports the container filesystem holding the path
+func (fs ContainerFS) StatVFS(request *sftp.Request) (*sftp.StatVFS, error) {
+	fs.logInfo(request, "sftp statvfs")
+
+	var out bytes.Buffer
+
+	if err := fs.exec([]string{"stat", "-f", "-c", "%S %b %f %a %c %d %l", "--", request.Filepath}, nil, &out); err != nil {
+		fs.logError(request, "sftp statvfs error", err)
+		return nil, containerError(err)
+	}
+
+	var numbers [7]uint64
+
+	fields := strings.Fields(out.String())
+	if len(fields) != len(numbers) {
+		return nil, sftp.ErrSSHFxFailure
+	}
+
+	for idx, field := range fields {
+		number, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return nil, sftp.ErrSSHFxFailure
+		}
+
+		numbers[idx] = number
+	}
+
+	return &sftp.StatVFS{
+		Bsize:   numbers[0],
+		Frsize:  numbers[0],
+		Blocks:  numbers[1],
+		Bfree:   numbers[2],
+		Bavail:  numbers[3],
+		Files:   numbers[4],
+		Ffree:   numbers[5],
+		Favail:  numbers[5],
+		Namemax: numbers[6],
+	}, nil
+}
+
+// containerUpload spools an upload then vaults it and copies it into the container once closed - what
+// the upload grows the file by is charged to quota as it is written
+type containerUpload struct {
+	*spoolFile
+	fs      ContainerFS
+	quota   *quota.Tracker
+	request *sftp.Request
+	append  bool
+
+	mu      sync.Mutex
+	size    int64
+	charged int64
+}
+
+func (u *containerUpload) WriteAt(b []byte, off int64) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.append {
+		off = u.size
+	}
+
+	if u.fs.MaxSize > 0 && off+int64(len(b)) > u.fs.MaxSize {
+		u.fs.logError(u.request, "sftp write error", ErrTooLarge)
+		return 0, ErrTooLarge
+	}
+
+	n, size, err := writeCharged(u.spoolFile, u.quota, u.size, b, off)
+	u.charged += size - u.size
+	u.size = size
+
+	return n, err
+}
+
+func (u *containerUpload) Close() (err error) {
+	defer u.spoolFile.Close()
+
+	// the file never made it into the container
+	defer func() {
+		if err != nil {
+			u.quota.Shrink(u.charged)
+		}
+	}()
+
+	info, err := u.File.Stat()
+	if err != nil {
+		return err
+	}
+
+	fields := map[string]string{
+		"path":   u.request.Filepath,
+		"method": u.request.Method,
+		"size":   strconv.FormatInt(info.Size(), 10),
+	}
+
+	if u.fs.Vault != nil {
+		if sum, _, err := u.fs.Vault.Store(io.NewSectionReader(u.File, 0, info.Size()), 0); err == nil {
+			fields["sha256"] = sum
+		} else {
+			u.fs.logError(u.request, "sftp vault error", err)
+		}
+	}
+
+	err = u.fs.exec([]string{"sh", "-c", `cat > "$1"`, "sh", u.request.Filepath}, io.NewSectionReader(u.File, 0, info.Size()), nil)
+	if err != nil {
+		u.fs.logError(u.request, "sftp write error", err)
+		return containerError(err)
+	}
+
+	util.Logger.WithFields(logrus.Fields{
+		"address": u.fs.RemoteIP,
+		"user":    u.fs.User,
+		"rpath":   u.request.Filepath,
+		"size":    util.ByteCountDecimal(info.Size()),
+		"sha256":  fields["sha256"],
+	}).Info("sftp upload")
+
+	if u.fs.Notify != nil {
+		u.fs.Notify(u.fs.FishlerFS, event.KindUpload, fields)
+	}
+
+	return nil
+}
+
+// spoolFile is a temporary file, charged to quota as it grows, which is removed once closed
+type spoolFile struct {
+	countingFile
+	quota *quota.Tracker
+
+	mu   sync.Mutex
+	size int64
+}
+
+// Write appends what the container sends without counting it as transferred
+func (f *spoolFile) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, size, err := writeCharged(f.File, f.quota, f.size, b, f.size)
+	f.size = size
+
+	return n, err
+}
+
+func (f *spoolFile) WriteAt(b []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, size, err := writeCharged(f.countingFile, f.quota, f.size, b, off)
+	f.size = size
+
+	return n, err
+}
+
+func (f *spoolFile) Close() error {
+	err := f.File.Close()
+	_ = os.Remove(f.File.Name())
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.quota.Shrink(f.size)
+	f.size = 0
+
+	return err
+}
+
+// limitWriter fails once more than limit bytes are written - a limit of zero is unlimited
+type limitWriter struct {
+	w       io.Writer
+	limit   int64
+	written int64
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if l.limit > 0 && l.written+int64(len(p)) > l.limit {
+		return 0, ErrTooLarge
+	}
+
+	n, err := l.w.Write(p)
+	l.written += int64(n)
+
+	return n, err
+}
+
+// containerFileInfo describes a file in the container as reported by stat(1)
+type containerFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+	uid     uint32
+	gid     uint32
+}
+
+func (i *containerFileInfo) Name() string       { return i.name }
+func (i *containerFileInfo) Size() int64        { return i.size }
+func (i *containerFileInfo) Mode() os.FileMode  { return i.mode }
+func (i *containerFileInfo) ModTime() time.Time { return i.modTime }
+func (i *containerFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *containerFileInfo) Sys() any           { return nil }
+func (i *containerFileInfo) Uid() uint32        { return i.uid }
+func (i *containerFileInfo) Gid() uint32        { return i.gid }
+
+// parseStat parses a line of stat -c statFormat output
+func parseStat(line string) (*containerFileInfo, error) {
+	fields := strings.SplitN(line, " ", 6)
+	if len(fields) != 6 {
+		return nil, errors.New("malformed stat line")
+	}
+
+	var numbers [5]uint64
+
+	for idx, base := range []int{16, 10, 10, 10, 10} {
+		number, err := strconv.ParseUint(fields[idx], base, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		numbers[idx] = number
+	}
+
+	name := path.Base(fields[5])
+
+	return &containerFileInfo{
+		name:    name,
+		mode:    unixMode(uint32(numbers[0])),    // #nosec
+		size:    int64(numbers[1]),               // #nosec
+		modTime: time.Unix(int64(numbers[2]), 0), // #nosec
+		uid:     uint32(numbers[3]),              // #nosec
+		gid:     uint32(numbers[4]),              // #nosec
+	}, nil
+}
+
+// unixMode converts a raw st_mode into an os.FileMode
+func unixMode(m uint32) os.FileMode {
+	mode := os.FileMode(m & 0777)
+
+	switch m & 0170000 {
+	case 0040000:
+		mode |= os.ModeDir
+	case 0120000:
+		mode |= os.ModeSymlink
+	case 0010000:
+		mode |= os.ModeNamedPipe
+	case 0140000:
+		mode |= os.ModeSocket
+	case 0020000:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case 0060000:
+		mode |= os.ModeDevice
+	}
+
+	if m&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+
+	if m&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+
+	if m&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+
+	return mode
+}
@@ -0,0 +1,252 @@
+package sftp
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+
+	"github.com/archimoebius/fishler/util"
+	"github.com/archimoebius/fishler/util/quota"
+)
+
+// recorder is a container which records the commands run in it, answering with canned output
+type recorder struct {
+	cmds   [][]string
+	stdin  []string
+	output string
+	err    error
+}
+
+func (r *recorder) Exec(ctx context.Context, cmd []string, stdin io.Reader, stdout io.Writer) error {
+	r.cmds = append(r.cmds, cmd)
+
+	if stdin != nil {
+		b, _ := io.ReadAll(stdin)
+		r.stdin = append(r.stdin, string(b))
+	}
+
+	// a failing stdout fails the command as SessionContainer.Exec does
+	if stdout != nil {
+		if _, err := io.WriteString(stdout, r.output); err != nil {
+			return err
+		}
+	}
+
+	return r.err
+}
+
+func testContainerFS(t *testing.T, r *recorder) ContainerFS {
+	fs, _, _ := testFS(t)
+
+	return ContainerFS{
+		FishlerFS:      fs,
+		Container:      r,
+		Context:        context.Background(),
+		MaxSize:        16,
+		Spool:          quota.New(t.TempDir(), 32),
+		ContainerQuota: quota.New("", 32),
+	}
+}
+
+func TestParseStat(t *testing.T) {
+	info, err := parseStat("41ed 4096 1700000000 0 0 /etc/ssl certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Name() != "ssl certs" || !info.IsDir() || info.Mode().Perm() != 0755 || info.ModTime().Unix() != 1700000000 {
+		t.Fatalf("unexpected info %+v", info)
+	}
+
+	info, err = parseStat("a1ff 12 1700000000 1000 1000 /bin/sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode()&os.ModeSymlink == 0 || info.Uid() != 1000 {
+		t.Fatalf("unexpected info %+v", info)
+	}
+
+	if _, err := parseStat("garbage"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestContainerSymlink(t *testing.T) {
+	r := &recorder{}
+	fs := testContainerFS(t, r)
+
+	err := fs.Filecmd(&sftp.Request{Method: "Symlink", Filepath: "../etc/passwd", Target: "/tmp/link"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(r.cmds[0], []string{"ln", "-s", "--", "../etc/passwd", "/tmp/link"}) {
+		t.Fatalf("unexpected command %q", r.cmds[0])
+	}
+}
+
+func TestContainerError(t *testing.T) {
+	r := &recorder{err: &util.ExecError{ExitCode: 1, Stderr: "cat: can't open '/etc/shadow': Permission denied"}}
+	fs := testContainerFS(t, r)
+
+	if _, err := fs.Fileread(&sftp.Request{Method: "Get", Filepath: "/etc/shadow"}); err != sftp.ErrSSHFxPermissionDenied {
+		t.Fatalf("expected permission denied got %v", err)
+	}
+}
+
+func TestContainerUpload(t *testing.T) {
+	r := &recorder{}
+	fs := testContainerFS(t, r)
+
+	w, err := fs.Filewrite(&sftp.Request{Method: "Put", Filepath: "/tmp/bot.sh"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.WriteAt([]byte("hello"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.WriteAt([]byte(strings.Repeat("x", 16)), 5); err != ErrTooLarge {
+		t.Fatalf("expected the upload to be capped got %v", err)
+	}
+
+	if err := w.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.stdin) != 1 || r.stdin[0] != "hello" {
+		t.Fatalf("unexpected upload %q", r.stdin)
+	}
+
+	if _, err := os.Stat(fs.Vault.Path("2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContainerQuota(t *testing.T) {
+	r := &recorder{}
+	fs := testContainerFS(t, r)
+	fs.ContainerQuota = quota.New("", 8)
+
+	w, err := fs.Filewrite(&sftp.Request{Method: "Put", Filepath: "/tmp/bot.sh", Flags: 0x2 | 0x8 | 0x10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.WriteAt([]byte("hello"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// uploads outside the home are charged to the container
+	if used := fs.ContainerQuota.Used(); used != 5 || fs.Quota.Used() != 0 {
+		t.Fatalf("expected 5 bytes charged to the container got %d", used)
+	}
+
+	if _, err := w.WriteAt([]byte("world"), 5); err != quota.ErrExceeded {
+		t.Fatalf("expected the quota to be exceeded got %v", err)
+	}
+
+	if used := fs.Spool.Used(); used != 5 {
+		t.Fatalf("expected 5 bytes spooled got %d", used)
+	}
+
+	// the container refuses the copy so the charge is given back
+	r.err = &util.ExecError{Stderr: "sh: /tmp/bot.sh: Permission denied"}
+
+	if err := w.(io.Closer).Close(); err == nil {
+		t.Fatal("expected the copy into the container to fail")
+	}
+
+	if used := fs.ContainerQuota.Used(); used != 0 {
+		t.Fatalf("expected the charge to be given back got %d", used)
+	}
+
+	if used := fs.Spool.Used(); used != 0 {
+		t.Fatalf("expected the spool to be released got %d", used)
+	}
+}
+
+func TestContainerHomeQuota(t *testing.T) {
+	r := &recorder{}
+	fs := testContainerFS(t, r)
+
+	home, err := fs.GetDockerVolumnPath(fs.FishlerFS, "/root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(home, "notes"), []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if used := fs.Quota.Used(); used != 5 {
+		t.Fatalf("expected the home to hold 5 bytes got %d", used)
+	}
+
+	// the container truncates the file through the bind mount
+	w, err := fs.Filewrite(&sftp.Request{Method: "Put", Filepath: "/root/notes", Flags: 0x2 | 0x8 | 0x10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Truncate(filepath.Join(home, "notes"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if used := fs.Quota.Used(); used != 0 {
+		t.Fatalf("expected the truncated file to be given back got %d", used)
+	}
+
+	if _, err := w.WriteAt([]byte("hi"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if used := fs.Quota.Used(); used != 2 || fs.ContainerQuota.Used() != 0 {
+		t.Fatalf("expected the upload into the home charged to it got %d", used)
+	}
+
+	if err := w.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContainerSpoolLimit(t *testing.T) {
+	r := &recorder{output: strings.Repeat("x", 12)}
+	fs := testContainerFS(t, r)
+	fs.Spool = quota.New(t.TempDir(), 20)
+
+	first, err := fs.Fileread(&sftp.Request{Method: "Get", Filepath: "/etc/passwd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the spool is shared by every transfer in flight
+	if _, err := fs.Fileread(&sftp.Request{Method: "Get", Filepath: "/etc/passwd"}); err == nil {
+		t.Fatal("expected the second download to overflow the spool")
+	}
+
+	if err := first.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := fs.Fileread(&sftp.Request{Method: "Get", Filepath: "/etc/passwd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := second.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if entries, _ := os.ReadDir(fs.Spool.Dir()); len(entries) != 0 {
+		t.Fatalf("expected the spool to be emptied got %d files", len(entries))
+	}
+}
@@ -0,0 +1,28 @@
+package sftp
+
+import (
+	"os"
+
+	"github.com/archimoebius/fishler/util/metrics"
+)
+
+// countingFile tallies the bytes moved through an open file into the SFTP metrics
+type countingFile struct {
+	*os.File
+}
+
+func (f countingFile) ReadAt(b []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(b, off)
+
+	metrics.SFTPBytes.WithLabelValues(metrics.DirectionOut).Add(float64(n))
+
+	return n, err
+}
+
+func (f countingFile) WriteAt(b []byte, off int64) (int, error) {
+	n, err := f.File.WriteAt(b, off)
+
+	metrics.SFTPBytes.WithLabelValues(metrics.DirectionIn).Add(float64(n))
+
+	return n, err
+}
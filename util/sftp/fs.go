@@ -1,20 +1,79 @@
 package sftp
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
 	"sync"
 
 	"github.com/pkg/sftp"
 	"github.com/sirupsen/logrus"
 
 	"github.com/archimoebius/fishler/util"
+	"github.com/archimoebius/fishler/util/event"
+	"github.com/archimoebius/fishler/util/quota"
+	"github.com/archimoebius/fishler/util/vault"
 )
 
 type FishlerFS struct {
 	GetDockerVolumnPath func(fs FishlerFS, p string) (string, error)
-	HasDiskSpace        func(fs FishlerFS) bool
+	Quota               *quota.Tracker
+	Notify              func(fs FishlerFS, kind event.Kind, fields map[string]string)
+	Record              func(fs FishlerFS, transfer Transfer)
 	Lock                *sync.Mutex
+	Vault               *vault.Vault
+	Home                string // the user's home as the client sees it
+	HostHome            string // the user's FishyFS mount every host path is resolved under
 	User                string
 	RemoteIP            string
+	SessionID           string
+}
+
+// statusError maps a filesystem error onto the SFTP status a real server would send - passing the error
+// itself on would leak the host path
+func statusError(err error) error {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return sftp.ErrSSHFxNoSuchFile
+	case errors.Is(err, os.ErrPermission):
+		return sftp.ErrSSHFxPermissionDenied
+	default:
+		return sftp.ErrSSHFxFailure
+	}
+}
+
+// errOutsideHome is a path which resolves outside the user's home
+var errOutsideHome = errors.New("path is outside the home")
+
+// hostName translates p to a name relative to the user's home on the host
+func (fs FishlerFS) hostName(p string) (string, error) {
+	hostPath, err := fs.GetDockerVolumnPath(fs, p)
+	if err != nil {
+		return "", err
+	}
+
+	name, err := filepath.Rel(fs.HostHome, hostPath)
+	if err != nil || !filepath.IsLocal(name) {
+		return "", errOutsideHome
+	}
+
+	return name, nil
+}
+
+// root opens the user's home and returns p relative to it - every host operation goes through the
+// root so a symbolic link in the home can't reach the rest of the host
+func (fs FishlerFS) root(p string) (*os.Root, string, error) {
+	name, err := fs.hostName(p)
+	if err != nil {
+		return nil, "", err
+	}
+
+	root, err := os.OpenRoot(fs.HostHome)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return root, name, nil
 }
 
 func (fs FishlerFS) logError(request *sftp.Request, msg string, err error) {
@@ -37,3 +96,14 @@ func (fs FishlerFS) logInfo(request *sftp.Request, msg string) {
 		"method":  request.Method,
 	}).Info(msg)
 }
+
+func (fs FishlerFS) notify(request *sftp.Request, kind event.Kind) {
+	if fs.Notify == nil {
+		return
+	}
+
+	fs.Notify(fs, kind, map[string]string{
+		"path":   request.Filepath,
+		"method": request.Method,
+	})
+}
@@ -2,16 +2,19 @@ package sftp
 
 import (
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/pkg/sftp"
 )
 
 func (fs FishlerFS) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
-	p, err := fs.GetDockerVolumnPath(fs, request.Filepath)
+	root, p, err := fs.root(request.Filepath)
 	if err != nil {
 		fs.logError(request, "sftp filelist error", err)
 		return nil, sftp.ErrSSHFxNoSuchFile
 	}
+	defer root.Close()
 
 	fs.logInfo(request, "sftp filelist")
 
@@ -19,11 +22,11 @@ func (fs FishlerFS) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
 	case "List":
 		var filesinfo []os.FileInfo
 
-		files, err := os.ReadDir(p)
+		files, err := readDir(root, p)
 
 		if err != nil {
 			fs.logError(request, "sftp filelist error", err)
-			return nil, sftp.ErrSSHFxFailure
+			return nil, statusError(err)
 		}
 
 		for _, file := range files {
@@ -37,19 +40,60 @@ func (fs FishlerFS) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
 
 		return ListerAt(filesinfo), nil
 	case "Stat":
-		s, err := os.Stat(p)
+		s, err := root.Stat(p)
 
-		if os.IsNotExist(err) {
-			fs.logError(request, "sftp filelist error", err)
-			return nil, sftp.ErrSSHFxNoSuchFile
-		} else if err != nil {
+		if err != nil {
 			fs.logError(request, "sftp filelist error", err)
-			return nil, sftp.ErrSSHFxFailure
+			return nil, statusError(err)
 		}
 
 		return ListerAt([]os.FileInfo{s}), nil
+	case "Readlink":
+		// links hold container paths so are returned untouched
+		target, err := root.Readlink(p)
+		if err != nil {
+			fs.logError(request, "sftp filelist error", err)
+			return nil, statusError(err)
+		}
+
+		return ListerAt([]os.FileInfo{linkInfo(target)}), nil
 	default:
 	}
 
 	return nil, sftp.ErrSSHFxOpUnsupported
 }
+
+// Lstat is Stat without following a final symbolic link
+func (fs FishlerFS) Lstat(request *sftp.Request) (sftp.ListerAt, error) {
+	root, p, err := fs.root(request.Filepath)
+	if err != nil {
+		fs.logError(request, "sftp filelist error", err)
+		return nil, sftp.ErrSSHFxNoSuchFile
+	}
+	defer root.Close()
+
+	fs.logInfo(request, "sftp filelist")
+
+	s, err := root.Lstat(p)
+	if err != nil {
+		fs.logError(request, "sftp filelist error", err)
+		return nil, statusError(err)
+	}
+
+	return ListerAt([]os.FileInfo{s}), nil
+}
+
+// readDir is os.ReadDir within root
+func readDir(root *os.Root, name string) ([]os.DirEntry, error) {
+	dir, err := root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	entries, err := dir.ReadDir(-1)
+
+	slices.SortFunc(entries, func(a, b os.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+
+	return entries, err
+}
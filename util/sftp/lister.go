@@ -18,3 +18,8 @@ func (l ListerAt) ListAt(f []os.FileInfo, offset int64) (int, error) {
 		return n, nil
 	}
 }
+
+// linkInfo carries a symbolic link's target as its name, as Readlink replies expect
+func linkInfo(target string) os.FileInfo {
+	return &containerFileInfo{name: target}
+}
@@ -8,22 +8,27 @@ import (
 )
 
 func (fs FishlerFS) Fileread(request *sftp.Request) (io.ReaderAt, error) {
-	p, err := fs.GetDockerVolumnPath(fs, request.Filepath)
+	root, p, err := fs.root(request.Filepath)
 	if err != nil {
 		return nil, sftp.ErrSSHFxNoSuchFile
 	}
+	defer root.Close()
 
 	fs.Lock.Lock()
 	defer fs.Lock.Unlock()
 
-	if _, err := os.Stat(p); os.IsNotExist(err) {
+	if _, err := root.Stat(p); os.IsNotExist(err) {
 		return nil, sftp.ErrSSHFxNoSuchFile
 	}
 
-	file, err := os.Open(p) // #nosec
+	file, err := root.Open(p)
 	if err != nil {
 		return nil, sftp.ErrSSHFxFailure
 	}
 
-	return file, nil
+	return &auditedFile{
+		fileHandle: countingFile{file},
+		audit:      fs.audit("sftp", DirectionDownload, request.Filepath),
+		digest:     func() (string, int64, error) { return digestFile(file) },
+	}, nil
 }
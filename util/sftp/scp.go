@@ -0,0 +1,517 @@
+package sftp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/archimoebius/fishler/util"
+	"github.com/archimoebius/fishler/util/event"
+	"github.com/archimoebius/fishler/util/metrics"
+)
+
+// ErrSCPProtocol is returned when the client breaks the scp protocol
+var ErrSCPProtocol = errors.New("scp protocol error")
+
+// IsSCP reports whether an exec command is the remote end of an scp transfer (scp -t or scp -f)
+func IsSCP(command []string) bool {
+	if len(command) < 2 || path.Base(command[0]) != "scp" {
+		return false
+	}
+
+	options, _ := scpOptions(command[1:])
+
+	return options.sink != options.source
+}
+
+type scpFlags struct {
+	sink      bool
+	source    bool
+	recursive bool
+	preserve  bool
+	directory bool
+}
+
+func scpOptions(args []string) (scpFlags, []string) {
+	var flags scpFlags
+
+	for idx, arg := range args {
+		if arg == "--" {
+			return flags, args[idx+1:]
+		}
+
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			return flags, args[idx:]
+		}
+
+		for _, flag := range arg[1:] {
+			switch flag {
+			case 't':
+				flags.sink = true
+			case 'f':
+				flags.source = true
+			case 'r':
+				flags.recursive = true
+			case 'p':
+				flags.preserve = true
+			case 'd':
+				flags.directory = true
+			}
+		}
+	}
+
+	return flags, nil
+}
+
+// SCP serves the remote end of an scp transfer over rw - command is the exec request, e.g. scp -t /tmp
+func (fs FishlerFS) SCP(rw io.ReadWriter, command []string) error {
+	if !IsSCP(command) {
+		return ErrSCPProtocol
+	}
+
+	flags, paths := scpOptions(command[1:])
+	reader := bufio.NewReader(rw)
+
+	if flags.sink {
+		if len(paths) != 1 {
+			scpReply(rw, 2, "scp: ambiguous target")
+			return ErrSCPProtocol
+		}
+
+		return fs.scpSink(reader, rw, fs.scpPath(paths[0]), flags)
+	}
+
+	return fs.scpSource(reader, rw, paths, flags)
+}
+
+// scpPath resolves a path given to scp against the user's home
+func (fs FishlerFS) scpPath(p string) string {
+	if p == "" || p == "~" {
+		return fs.Home
+	}
+
+	p = strings.TrimPrefix(p, "~/")
+
+	if !path.IsAbs(p) {
+		p = path.Join(fs.Home, p)
+	}
+
+	return path.Clean(p)
+}
+
+// scpReply sends an acknowledgement - 0 with no message - a warning (1) or a fatal error (2)
+func scpReply(w io.Writer, code byte, message string) {
+	if code == 0 {
+		_, _ = w.Write([]byte{0})
+		return
+	}
+
+	_, _ = fmt.Fprintf(w, "%c%s\n", code, message)
+}
+
+// scpAck reads the other side's acknowledgement
+func scpAck(r *bufio.Reader) error {
+	code, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	if code == 0 {
+		return nil
+	}
+
+	message, _ := r.ReadString('\n')
+
+	return fmt.Errorf("%w: %s", ErrSCPProtocol, strings.TrimSpace(message))
+}
+
+func (fs FishlerFS) logSCP(msg string, p string, fields logrus.Fields) {
+	util.Logger.WithFields(logrus.Fields{
+		"address": fs.RemoteIP,
+		"user":    fs.User,
+		"rpath":   p,
+		"method":  "scp",
+	}).WithFields(fields).Info(msg)
+}
+
+func (fs FishlerFS) scpSink(r *bufio.Reader, w io.Writer, target string, flags scpFlags) error {
+	var failed error
+
+	// the directory each received file lands in - the target itself may name the file
+	directories := []string{target}
+
+	scpReply(w, 0, "")
+
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return failed
+		} else if err != nil {
+			return err
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return ErrSCPProtocol
+		}
+
+		current := directories[len(directories)-1]
+
+		switch line[0] {
+		case 'T':
+			// times aren't preserved
+			scpReply(w, 0, "")
+		case 'C', 'D':
+			mode, size, name, err := scpHeader(line[1:])
+			if err != nil {
+				scpReply(w, 2, "scp: "+err.Error())
+				return err
+			}
+
+			destination := path.Join(current, name)
+
+			// the first file or directory received may be given the target's name
+			if len(directories) == 1 && !fs.scpIsDir(current) && !flags.directory {
+				destination = current
+			}
+
+			if line[0] == 'D' {
+				if !flags.recursive {
+					scpReply(w, 2, "scp: received directory without -r")
+					return ErrSCPProtocol
+				}
+
+				if err := fs.scpMkdir(destination, mode); err != nil {
+					failed = err
+					scpReply(w, 1, fmt.Sprintf("scp: %s: %v", destination, err))
+					continue
+				}
+
+				directories = append(directories, destination)
+				scpReply(w, 0, "")
+
+				continue
+			}
+
+			if err := fs.scpReceive(r, w, destination, mode, size); err != nil {
+				if errors.Is(err, ErrSCPProtocol) || errors.Is(err, io.ErrUnexpectedEOF) {
+					return err
+				}
+
+				failed = err
+			}
+		case 'E':
+			if len(directories) == 1 {
+				scpReply(w, 2, "scp: unexpected end of directory")
+				return ErrSCPProtocol
+			}
+
+			directories = directories[:len(directories)-1]
+			scpReply(w, 0, "")
+		case 1, 2:
+			fs.logSCP("scp client error", current, logrus.Fields{"error": line[1:]})
+		default:
+			scpReply(w, 2, "scp: protocol error")
+			return ErrSCPProtocol
+		}
+	}
+}
+
+// scpHeader parses the "<mode> <size> <name>" of a C or D line
+func scpHeader(header string) (os.FileMode, int64, string, error) {
+	parts := strings.SplitN(header, " ", 3)
+	if len(parts) != 3 {
+		return 0, 0, "", fmt.Errorf("%w: bad header", ErrSCPProtocol)
+	}
+
+	mode, err := strconv.ParseUint(parts[0], 8, 32)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("%w: bad mode", ErrSCPProtocol)
+	}
+
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, 0, "", fmt.Errorf("%w: bad size", ErrSCPProtocol)
+	}
+
+	name := parts[2]
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return 0, 0, "", fmt.Errorf("%w: unexpected filename: %s", ErrSCPProtocol, name)
+	}
+
+	return os.FileMode(mode).Perm(), size, name, nil
+}
+
+func (fs FishlerFS) scpIsDir(p string) bool {
+	root, name, err := fs.root(p)
+	if err != nil {
+		return false
+	}
+	defer root.Close()
+
+	info, err := root.Stat(name)
+
+	return err == nil && info.IsDir()
+}
+
+func (fs FishlerFS) scpMkdir(p string, mode os.FileMode) error {
+	root, name, err := fs.root(p)
+	if err != nil {
+		return os.ErrPermission
+	}
+	defer root.Close()
+
+	fs.logSCP("scp mkdir", p, nil)
+
+	return root.MkdirAll(name, mode|0700)
+}
+
+// scpReceive accepts one file - errors known before its data is sent are reported so the client skips it
+func (fs FishlerFS) scpReceive(r *bufio.Reader, w io.Writer, p string, mode os.FileMode, size int64) (err error) {
+	root, name, err := fs.root(p)
+	if err != nil {
+		scpReply(w, 1, fmt.Sprintf("scp: %s: Permission denied", p))
+		return os.ErrPermission
+	}
+	defer root.Close()
+
+	fs.Lock.Lock()
+	defer fs.Lock.Unlock()
+
+	var replaced int64
+
+	if info, err := root.Stat(name); err == nil && info.Mode().IsRegular() {
+		replaced = info.Size()
+	}
+
+	// the whole file is claimed up front as scp declares its size
+	if err := fs.Quota.Grow(size - replaced); err != nil {
+		scpReply(w, 1, fmt.Sprintf("scp: %s: Disk quota exceeded", p))
+		return err
+	}
+
+	if err := root.MkdirAll(filepath.Dir(name), 0750); err != nil {
+		fs.Quota.Shrink(size - replaced)
+		scpReply(w, 1, fmt.Sprintf("scp: %s: %v", p, err))
+		return err
+	}
+
+	file, err := root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode|0600)
+	if err != nil {
+		fs.Quota.Shrink(size - replaced)
+		scpReply(w, 1, fmt.Sprintf("scp: %s: %v", p, err))
+		return err
+	}
+
+	scpReply(w, 0, "")
+
+	audit := fs.audit("scp", DirectionUpload, p)
+	defer func() {
+		audit.conclude(err, func() (string, int64, error) { return fs.digestPath(p) })
+	}()
+
+	written, err := io.Copy(file, io.LimitReader(r, size))
+	metrics.SFTPBytes.WithLabelValues(metrics.DirectionIn).Add(float64(written))
+	audit.add(written, 0)
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil && written != size {
+		err = io.ErrUnexpectedEOF
+	}
+
+	// the file was truncated so holds only what arrived
+	if err != nil {
+		fs.Quota.Shrink(size - written)
+		return err
+	}
+
+	if err := scpAck(r); err != nil {
+		return err
+	}
+
+	fields := map[string]string{
+		"path":   p,
+		"method": "scp",
+		"size":   strconv.FormatInt(size, 10),
+	}
+
+	if fs.Vault != nil {
+		if sum, err := fs.vaultFile(root, name); err == nil {
+			fields["sha256"] = sum
+		} else {
+			fs.logSCP("scp vault error", p, logrus.Fields{"error": err})
+		}
+	}
+
+	fs.logSCP("scp write", p, logrus.Fields{"size": util.ByteCountDecimal(size), "sha256": fields["sha256"]})
+
+	if fs.Notify != nil {
+		fs.Notify(fs, event.KindUpload, fields)
+	}
+
+	scpReply(w, 0, "")
+
+	return nil
+}
+
+func (fs FishlerFS) vaultFile(root *os.Root, name string) (string, error) {
+	file, err := root.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	sum, _, err := fs.Vault.Store(file, 0)
+
+	return sum, err
+}
+
+func (fs FishlerFS) scpSource(r *bufio.Reader, w io.Writer, paths []string, flags scpFlags) error {
+	var failed error
+
+	// the sink speaks first
+	if err := scpAck(r); err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		err := fs.scpSend(r, w, fs.scpPath(p), flags)
+		if errors.Is(err, ErrSCPProtocol) || errors.Is(err, io.EOF) {
+			return err
+		}
+
+		if err != nil {
+			failed = err
+		}
+	}
+
+	return failed
+}
+
+func (fs FishlerFS) scpSend(r *bufio.Reader, w io.Writer, p string, flags scpFlags) (err error) {
+	root, name, err := fs.root(p)
+	if err != nil {
+		scpReply(w, 1, fmt.Sprintf("scp: %s: No such file or directory", p))
+		return os.ErrNotExist
+	}
+	defer root.Close()
+
+	info, err := root.Stat(name)
+	if err != nil {
+		scpReply(w, 1, fmt.Sprintf("scp: %s: No such file or directory", p))
+		return err
+	}
+
+	if flags.preserve {
+		_, _ = fmt.Fprintf(w, "T%d 0 %d 0\n", info.ModTime().Unix(), info.ModTime().Unix())
+
+		if err := scpAck(r); err != nil {
+			return err
+		}
+	}
+
+	if info.IsDir() {
+		if !flags.recursive {
+			scpReply(w, 1, fmt.Sprintf("scp: %s: not a regular file", p))
+			return os.ErrInvalid
+		}
+
+		entries, err := readDir(root, name)
+		if err != nil {
+			scpReply(w, 1, fmt.Sprintf("scp: %s: %v", p, err))
+			return err
+		}
+
+		_, _ = fmt.Fprintf(w, "D%04o 0 %s\n", info.Mode().Perm(), path.Base(p))
+
+		if err := scpAck(r); err != nil {
+			return err
+		}
+
+		var failed error
+
+		for _, entry := range entries {
+			err := fs.scpSend(r, w, path.Join(p, entry.Name()), flags)
+			if errors.Is(err, ErrSCPProtocol) || errors.Is(err, io.EOF) {
+				return err
+			}
+
+			if err != nil {
+				failed = err
+			}
+		}
+
+		_, _ = fmt.Fprintf(w, "E\n")
+
+		if err := scpAck(r); err != nil {
+			return err
+		}
+
+		return failed
+	}
+
+	if !info.Mode().IsRegular() {
+		scpReply(w, 1, fmt.Sprintf("scp: %s: not a regular file", p))
+		return os.ErrInvalid
+	}
+
+	fs.Lock.Lock()
+	defer fs.Lock.Unlock()
+
+	file, err := root.Open(name)
+	if err != nil {
+		scpReply(w, 1, fmt.Sprintf("scp: %s: %v", p, err))
+		return err
+	}
+	defer file.Close()
+
+	_, _ = fmt.Fprintf(w, "C%04o %d %s\n", info.Mode().Perm(), info.Size(), path.Base(p))
+
+	if err := scpAck(r); err != nil {
+		return err
+	}
+
+	audit := fs.audit("scp", DirectionDownload, p)
+	defer func() {
+		audit.conclude(err, func() (string, int64, error) { return digestFile(file) })
+	}()
+
+	sent, err := io.Copy(w, io.LimitReader(file, info.Size()))
+	metrics.SFTPBytes.WithLabelValues(metrics.DirectionOut).Add(float64(sent))
+	audit.add(sent, 0)
+
+	if err != nil {
+		return err
+	}
+
+	// a file which shrank while being sent is padded so the stream stays in step
+	if sent < info.Size() {
+		if _, err := io.CopyN(w, zeroReader{}, info.Size()-sent); err != nil {
+			return err
+		}
+	}
+
+	scpReply(w, 0, "")
+
+	fs.logSCP("scp read", p, logrus.Fields{"size": util.ByteCountDecimal(info.Size())})
+
+	return scpAck(r)
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
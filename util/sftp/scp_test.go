@@ -0,0 +1,190 @@
+package sftp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/archimoebius/fishler/util"
+	"github.com/archimoebius/fishler/util/event"
+	"github.com/archimoebius/fishler/util/quota"
+	"github.com/archimoebius/fishler/util/vault"
+)
+
+func TestMain(m *testing.M) {
+	util.Logger = logrus.New()
+	util.Logger.SetOutput(io.Discard)
+
+	os.Exit(m.Run())
+}
+
+// transfer is an scp client which sends scripted input and collects what it is sent
+type transfer struct {
+	input *strings.Reader
+	bytes.Buffer
+}
+
+func (t *transfer) Read(p []byte) (int, error) {
+	return t.input.Read(p)
+}
+
+func testFS(t *testing.T) (FishlerFS, string, *[]map[string]string) {
+	home := t.TempDir()
+	uploads := &[]map[string]string{}
+
+	v, err := vault.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return FishlerFS{
+		GetDockerVolumnPath: func(fs FishlerFS, p string) (string, error) {
+			if p != "/root" && !strings.HasPrefix(p, "/root/") {
+				return "", errors.New("bad path")
+			}
+
+			return filepath.Join(home, strings.TrimPrefix(p, "/root")), nil
+		},
+		Quota: quota.New(home, 4<<20),
+		Notify: func(fs FishlerFS, kind event.Kind, fields map[string]string) {
+			*uploads = append(*uploads, fields)
+		},
+		Lock:     &sync.Mutex{},
+		Vault:    v,
+		Home:     "/root",
+		HostHome: home,
+		User:     "root",
+	}, home, uploads
+}
+
+func TestIsSCP(t *testing.T) {
+	for command, expected := range map[string]bool{
+		"scp -t /tmp":         true,
+		"/usr/bin/scp -rf a":  true,
+		"scp -v -p -t -- x":   true,
+		"scp a b":             false,
+		"scp -t -f x":         false,
+		"bash -c scp -t /tmp": false,
+	} {
+		if got := IsSCP(strings.Fields(command)); got != expected {
+			t.Fatalf("%q: expected %v got %v", command, expected, got)
+		}
+	}
+}
+
+func TestSCPUpload(t *testing.T) {
+	fs, home, uploads := testFS(t)
+
+	client := &transfer{input: strings.NewReader("T0 0 0 0\nC0755 5 bot.sh\nhello\x00D0755 0 kit\nC0644 3 a\nabc\x00E\n")}
+
+	if err := fs.SCP(client, []string{"scp", "-r", "-p", "-t", "."}); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, err := os.ReadFile(filepath.Join(home, "bot.sh")); err != nil || string(content) != "hello" {
+		t.Fatalf("unexpected bot.sh %q %v", content, err)
+	}
+
+	if content, err := os.ReadFile(filepath.Join(home, "kit", "a")); err != nil || string(content) != "abc" {
+		t.Fatalf("unexpected kit/a %q %v", content, err)
+	}
+
+	if client.String() != strings.Repeat("\x00", 8) {
+		t.Fatalf("unexpected acknowledgements %q", client.String())
+	}
+
+	if len(*uploads) != 2 || (*uploads)[0]["sha256"] != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Fatalf("unexpected uploads %v", *uploads)
+	}
+
+	if _, err := os.Stat(fs.Vault.Path((*uploads)[0]["sha256"])); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSCPUploadRename(t *testing.T) {
+	fs, home, _ := testFS(t)
+
+	client := &transfer{input: strings.NewReader("C0644 2 local\nhi\x00")}
+
+	if err := fs.SCP(client, []string{"scp", "-t", "/root/remote"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, err := os.ReadFile(filepath.Join(home, "remote")); err != nil || string(content) != "hi" {
+		t.Fatalf("unexpected remote %q %v", content, err)
+	}
+}
+
+func TestSCPUploadShort(t *testing.T) {
+	fs, home, _ := testFS(t)
+
+	// 4 MB declared, 2 bytes sent before the client goes away
+	client := &transfer{input: strings.NewReader("C0644 4000000 big\nhi")}
+
+	if err := fs.SCP(client, []string{"scp", "-t", "/root"}); err == nil {
+		t.Fatal("expected the short upload to fail")
+	}
+
+	if content, err := os.ReadFile(filepath.Join(home, "big")); err != nil || string(content) != "hi" {
+		t.Fatalf("unexpected big %q %v", content, err)
+	}
+
+	if used := fs.Quota.Used(); used != 2 {
+		t.Fatalf("expected only what arrived to stay claimed got %d", used)
+	}
+}
+
+func TestSCPUploadOutside(t *testing.T) {
+	fs, _, uploads := testFS(t)
+
+	// the client skips the file's data once it is refused
+	client := &transfer{input: strings.NewReader("C0644 2 x\n")}
+
+	if err := fs.SCP(client, []string{"scp", "-t", "/etc/x"}); err == nil {
+		t.Fatal("expected an error writing outside the home")
+	}
+
+	if !strings.Contains(client.String(), "\x01scp: /etc/x: Permission denied\n") || len(*uploads) != 0 {
+		t.Fatalf("unexpected reply %q", client.String())
+	}
+}
+
+func TestSCPDownload(t *testing.T) {
+	fs, home, _ := testFS(t)
+
+	if err := os.WriteFile(filepath.Join(home, "secret"), []byte("hunter2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &transfer{input: strings.NewReader(strings.Repeat("\x00", 3))}
+
+	if err := fs.SCP(client, []string{"scp", "-f", "secret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if client.String() != "C0600 7 secret\nhunter2\x00" {
+		t.Fatalf("unexpected transfer %q", client.String())
+	}
+}
+
+func TestSCPDownloadMissing(t *testing.T) {
+	fs, _, _ := testFS(t)
+
+	client := &transfer{input: strings.NewReader("\x00")}
+
+	if err := fs.SCP(client, []string{"scp", "-f", "/root/missing"}); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if client.String() != "\x01scp: /root/missing: No such file or directory\n" {
+		t.Fatalf("unexpected reply %q", client.String())
+	}
+}
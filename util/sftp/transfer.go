@@ -0,0 +1,238 @@
+package sftp
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Directions of a Transfer
+const (
+	DirectionUpload   = "upload"
+	DirectionDownload = "download"
+)
+
+// Transfer is the audit record of one file handle, produced when it is closed
+type Transfer struct {
+	SessionID string    `json:"session_id"`
+	Address   string    `json:"address"`
+	User      string    `json:"user"`
+	Protocol  string    `json:"protocol"`
+	Direction string    `json:"direction"`
+	Path      string    `json:"path"`
+	Opened    time.Time `json:"opened"`
+	Closed    time.Time `json:"closed"`
+	Bytes     int64     `json:"bytes"`
+	Size      int64     `json:"size"`
+	Complete  bool      `json:"complete"`
+	SHA256    string    `json:"sha256,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// transferAudit accumulates a Transfer over the life of a file handle
+type transferAudit struct {
+	fs FishlerFS
+
+	mu       sync.Mutex
+	transfer Transfer
+	moved    byteRanges
+	err      error
+	done     bool
+}
+
+func (fs FishlerFS) audit(protocol string, direction string, p string) *transferAudit {
+	return &transferAudit{
+		fs: fs,
+		transfer: Transfer{
+			SessionID: fs.SessionID,
+			Address:   fs.RemoteIP,
+			User:      fs.User,
+			Protocol:  protocol,
+			Direction: direction,
+			Path:      p,
+			Opened:    time.Now().UTC(),
+		},
+	}
+}
+
+// add counts n bytes moved at off
+func (a *transferAudit) add(n int64, off int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.transfer.Bytes += n
+	a.moved = a.moved.add(off, off+n)
+}
+
+// fail keeps the first error the transfer ran into
+func (a *transferAudit) fail(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.err == nil {
+		a.err = err
+	}
+}
+
+// finish hands the record to FishlerFS.Record once - a download is complete when every byte of the
+// file was read, whatever order they were read in, an upload when it closed cleanly
+func (a *transferAudit) finish(sum string, size int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.done {
+		return
+	}
+
+	a.done = true
+
+	a.transfer.Closed = time.Now().UTC()
+	a.transfer.Size = size
+	a.transfer.SHA256 = sum
+	a.transfer.Complete = a.err == nil
+
+	if a.transfer.Direction == DirectionDownload {
+		a.transfer.Complete = a.transfer.Complete && a.moved.covers(size)
+	}
+
+	if a.err != nil {
+		a.transfer.Error = a.err.Error()
+	}
+
+	if a.fs.Record != nil {
+		a.fs.Record(a.fs, a.transfer)
+	}
+}
+
+// conclude fails the transfer on err then finishes it with the digest of the final content
+func (a *transferAudit) conclude(err error, digest func() (string, int64, error)) {
+	sum, size, digestErr := digest()
+
+	if err == nil {
+		err = digestErr
+	}
+
+	if err != nil {
+		a.fail(err)
+	}
+
+	a.finish(sum, size)
+}
+
+// byteRanges are the half-open ranges of a file moved, sorted and merged
+type byteRanges [][2]int64
+
+func (r byteRanges) add(start int64, end int64) byteRanges {
+	if end <= start {
+		return r
+	}
+
+	merged := make(byteRanges, 0, len(r)+1)
+
+	for _, span := range r {
+		if span[1] < start || span[0] > end {
+			merged = append(merged, span)
+			continue
+		}
+
+		start = min(start, span[0])
+		end = max(end, span[1])
+	}
+
+	merged = append(merged, [2]int64{start, end})
+
+	slices.SortFunc(merged, func(a, b [2]int64) int { return cmp.Compare(a[0], b[0]) })
+
+	return merged
+}
+
+// covers reports whether every byte of a file of size bytes was moved
+func (r byteRanges) covers(size int64) bool {
+	return size == 0 || len(r) > 0 && r[0][0] == 0 && r[0][1] >= size
+}
+
+// fileHandle is what the request server is handed for an open file
+type fileHandle interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+}
+
+// auditedFile records the transfer through a file handle, digesting the content before the handle
+// is closed as closing a spool removes it
+type auditedFile struct {
+	fileHandle
+	audit  *transferAudit
+	digest func() (string, int64, error)
+}
+
+func (f *auditedFile) ReadAt(b []byte, off int64) (int, error) {
+	n, err := f.fileHandle.ReadAt(b, off)
+	f.audit.add(int64(n), off)
+
+	return n, err
+}
+
+func (f *auditedFile) WriteAt(b []byte, off int64) (int, error) {
+	n, err := f.fileHandle.WriteAt(b, off)
+	f.audit.add(int64(n), off)
+
+	if err != nil {
+		f.audit.fail(err)
+	}
+
+	return n, err
+}
+
+// TransferError is called by the request server for a handle still open when the session ends
+func (f *auditedFile) TransferError(err error) {
+	f.audit.fail(err)
+}
+
+func (f *auditedFile) Close() error {
+	sum, size, digestErr := f.digest()
+
+	err := f.fileHandle.Close()
+	f.audit.conclude(err, func() (string, int64, error) { return sum, size, digestErr })
+
+	return err
+}
+
+// digestFile hashes the content of an open file without moving its offset
+func digestFile(file *os.File) (string, int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+
+	hash := sha256.New()
+
+	size, err := io.Copy(hash, io.NewSectionReader(file, 0, info.Size()))
+	if err != nil {
+		return "", size, err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// digestPath hashes the content of the file at p in the user's home
+func (fs FishlerFS) digestPath(p string) (string, int64, error) {
+	root, name, err := fs.root(p)
+	if err != nil {
+		return "", 0, err
+	}
+	defer root.Close()
+
+	file, err := root.Open(name)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	return digestFile(file)
+}
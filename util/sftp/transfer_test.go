@@ -0,0 +1,209 @@
+package sftp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+func recordTransfers(fs *FishlerFS) *[]Transfer {
+	transfers := &[]Transfer{}
+
+	fs.Record = func(fs FishlerFS, transfer Transfer) {
+		*transfers = append(*transfers, transfer)
+	}
+
+	return transfers
+}
+
+func checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestTransferDownload(t *testing.T) {
+	fs, home, _ := testFS(t)
+	transfers := recordTransfers(&fs)
+
+	content := []byte("root:x:0:0:root:/root:/bin/sh\n")
+
+	if err := os.WriteFile(filepath.Join(home, "passwd"), content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, whole := range []bool{true, false} {
+		reader, err := fs.Fileread(&sftp.Request{Method: "Get", Filepath: "/root/passwd"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b := make([]byte, 4)
+
+		if whole {
+			b = make([]byte, len(content))
+		}
+
+		if _, err := reader.ReadAt(b, 0); err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+
+		if err := reader.(io.Closer).Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(*transfers) != 2 {
+		t.Fatalf("expected 2 transfers got %d", len(*transfers))
+	}
+
+	for idx, transfer := range *transfers {
+		if transfer.Direction != DirectionDownload || transfer.Path != "/root/passwd" || transfer.SHA256 != checksum(content) || transfer.Size != int64(len(content)) {
+			t.Fatalf("unexpected transfer %+v", transfer)
+		}
+
+		if transfer.Complete != (idx == 0) {
+			t.Fatalf("transfer %d complete %v", idx, transfer.Complete)
+		}
+	}
+
+	if (*transfers)[1].Bytes != 4 {
+		t.Fatalf("expected 4 bytes got %d", (*transfers)[1].Bytes)
+	}
+}
+
+func TestTransferDownloadTail(t *testing.T) {
+	fs, home, _ := testFS(t)
+	transfers := recordTransfers(&fs)
+
+	content := []byte("root:x:0:0:root:/root:/bin/sh\n")
+
+	if err := os.WriteFile(filepath.Join(home, "passwd"), content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	half := int64(len(content) / 2)
+
+	// only the tail, then the whole file out of order
+	for _, offsets := range [][]int64{{half}, {half, 0}} {
+		reader, err := fs.Fileread(&sftp.Request{Method: "Get", Filepath: "/root/passwd"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, off := range offsets {
+			b := make([]byte, int64(len(content))-half)
+
+			if _, err := reader.ReadAt(b, off); err != nil && err != io.EOF {
+				t.Fatal(err)
+			}
+		}
+
+		if err := reader.(io.Closer).Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(*transfers) != 2 {
+		t.Fatalf("expected 2 transfers got %d", len(*transfers))
+	}
+
+	if (*transfers)[0].Complete {
+		t.Fatalf("expected reading only the tail to be incomplete %+v", (*transfers)[0])
+	}
+
+	if !(*transfers)[1].Complete {
+		t.Fatalf("expected reading every byte out of order to be complete %+v", (*transfers)[1])
+	}
+}
+
+func TestByteRanges(t *testing.T) {
+	var r byteRanges
+
+	for _, span := range [][2]int64{{10, 20}, {30, 40}, {0, 5}, {5, 10}, {20, 30}, {25, 35}} {
+		r = r.add(span[0], span[1])
+	}
+
+	if len(r) != 1 || r[0] != [2]int64{0, 40} {
+		t.Fatalf("expected one merged range got %v", r)
+	}
+
+	if !r.covers(40) || r.covers(41) {
+		t.Fatalf("unexpected coverage of %v", r)
+	}
+
+	if r = (byteRanges{}).add(1, 10); r.covers(10) {
+		t.Fatal("expected a range missing the first byte not to cover the file")
+	}
+}
+
+func TestTransferUpload(t *testing.T) {
+	fs, home, _ := testFS(t)
+	transfers := recordTransfers(&fs)
+
+	writer, err := fs.Filewrite(&sftp.Request{Method: "Put", Filepath: "/root/payload", Flags: 0x2 | 0x8 | 0x10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := []byte("#!/bin/sh\nid\n")
+
+	if _, err := writer.WriteAt(content, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*transfers) != 1 {
+		t.Fatalf("expected 1 transfer got %d", len(*transfers))
+	}
+
+	transfer := (*transfers)[0]
+
+	if transfer.Direction != DirectionUpload || !transfer.Complete || transfer.Bytes != int64(len(content)) || transfer.SHA256 != checksum(content) {
+		t.Fatalf("unexpected transfer %+v", transfer)
+	}
+
+	if transfer.Closed.Before(transfer.Opened) {
+		t.Fatalf("closed %v before opened %v", transfer.Closed, transfer.Opened)
+	}
+
+	if _, err := os.Stat(filepath.Join(home, "payload")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransferInterrupted(t *testing.T) {
+	fs, _, _ := testFS(t)
+	transfers := recordTransfers(&fs)
+
+	writer, err := fs.Filewrite(&sftp.Request{Method: "Put", Filepath: "/root/partial", Flags: 0x2 | 0x8})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := writer.WriteAt([]byte("half"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// the request server reports the session ending before it closes the handle
+	writer.(interface{ TransferError(error) }).TransferError(errors.New("connection lost"))
+
+	_ = writer.(io.Closer).Close()
+	_ = writer.(io.Closer).Close()
+
+	if len(*transfers) != 1 {
+		t.Fatalf("expected 1 transfer got %d", len(*transfers))
+	}
+
+	if transfer := (*transfers)[0]; transfer.Complete || transfer.Error != "connection lost" {
+		t.Fatalf("unexpected transfer %+v", transfer)
+	}
+}
@@ -1,62 +1,151 @@
 package sftp
 
 import (
-	"errors"
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/pkg/sftp"
+
+	"github.com/archimoebius/fishler/util/event"
+	"github.com/archimoebius/fishler/util/quota"
 )
 
 func (fs FishlerFS) Filewrite(request *sftp.Request) (io.WriterAt, error) {
-	p, err := fs.GetDockerVolumnPath(fs, request.Filepath)
+	return fs.open(request)
+}
+
+// OpenFile opens a file for both reading and writing
+func (fs FishlerFS) OpenFile(request *sftp.Request) (sftp.WriterAtReaderAt, error) {
+	return fs.open(request)
+}
+
+// openFlags converts the client's open flags - an append handle is opened without O_APPEND as
+// WriteAt refuses those, writes are sent to the end of the file instead
+func openFlags(pflags sftp.FileOpenFlags) int {
+	flags := os.O_WRONLY
+
+	if pflags.Read {
+		flags = os.O_RDWR
+	}
+
+	if pflags.Creat {
+		flags |= os.O_CREATE
+	}
+
+	if pflags.Trunc {
+		flags |= os.O_TRUNC
+	}
+
+	if pflags.Excl {
+		flags |= os.O_EXCL
+	}
+
+	return flags
+}
+
+func (fs FishlerFS) open(request *sftp.Request) (sftp.WriterAtReaderAt, error) {
+	root, p, err := fs.root(request.Filepath)
 	if err != nil {
 		fs.logError(request, "sftp write error", err)
 		return nil, sftp.ErrSSHFxNoSuchFile
 	}
+	defer root.Close()
 
-	if !fs.HasDiskSpace(fs) {
-		fs.logError(request, "sftp write error", errors.New("out of disk space"))
-		// https://tools.ietf.org/html/draft-ietf-secsh-filexfer-13#section-9.1 > sshFxQuotaExceeded...
-		return nil, sftp.ErrSSHFxFailure
+	if fs.Quota.Exceeded() {
+		fs.logError(request, "sftp write error", quota.ErrExceeded)
+		return nil, quota.ErrExceeded
 	}
 
 	fs.Lock.Lock()
 	defer fs.Lock.Unlock()
 
-	stat, statErr := os.Stat(p)
+	pflags := request.Pflags()
 
-	if os.IsNotExist(statErr) {
-		if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
-			fs.logError(request, "sftp write error", err)
-			return nil, sftp.ErrSSHFxFailure
-		}
+	var size int64
+
+	if stat, err := root.Stat(p); err == nil && stat.IsDir() {
+		return nil, sftp.ErrSSHFxFailure
+	} else if err == nil {
+		size = stat.Size()
+	}
 
-		file, err := os.Create(p) // #nosec
-		if err != nil {
+	if pflags.Creat {
+		if err := root.MkdirAll(filepath.Dir(p), 0750); err != nil {
 			fs.logError(request, "sftp write error", err)
 			return nil, sftp.ErrSSHFxFailure
 		}
+	}
 
-		return file, nil
+	file, err := root.OpenFile(p, openFlags(pflags), 0644)
+	if err != nil {
+		fs.logError(request, "sftp write error", err)
+		return nil, statusError(err)
 	}
 
-	if statErr != nil {
-		return nil, sftp.ErrSSHFxFailure
+	if pflags.Trunc {
+		fs.Quota.Shrink(size)
+		size = 0
 	}
 
-	if stat.IsDir() {
-		return nil, sftp.ErrSSHFxOpUnsupported
+	fs.logInfo(request, "sftp write")
+	fs.notify(request, event.KindUpload)
+
+	return &auditedFile{
+		fileHandle: &trackedFile{
+			countingFile: countingFile{file},
+			quota:        fs.Quota,
+			size:         size,
+			append:       pflags.Append,
+		},
+		audit:  fs.audit("sftp", DirectionUpload, request.Filepath),
+		digest: func() (string, int64, error) { return fs.digestPath(request.Filepath) },
+	}, nil
+}
+
+// trackedFile charges each write which grows the file to the quota, refusing it once the quota is
+// exceeded - an append handle sends every write to the end of the file whatever offset the client gave
+type trackedFile struct {
+	countingFile
+	quota  *quota.Tracker
+	append bool
+
+	mu   sync.Mutex
+	size int64
+}
+
+func (f *trackedFile) WriteAt(b []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.append {
+		off = f.size
 	}
 
-	file, err := os.Create(p) // #nosec
-	if err != nil {
-		fs.logError(request, "sftp write error", err)
-		return nil, sftp.ErrSSHFxFailure
+	n, size, err := writeCharged(f.countingFile, f.quota, f.size, b, off)
+	f.size = size
+
+	return n, err
+}
+
+// writeCharged writes b at off to a file of size bytes, charging whatever grows it to q, and returns
+// the file's new size
+func writeCharged(w io.WriterAt, q *quota.Tracker, size int64, b []byte, off int64) (int, int64, error) {
+	grow := off + int64(len(b)) - size
+
+	if grow > 0 {
+		if err := q.Grow(grow); err != nil {
+			return 0, size, err
+		}
 	}
 
-	fs.logInfo(request, "sftp write")
+	n, err := w.WriteAt(b, off)
+
+	// give back what was claimed but not written
+	if written := off + int64(n); grow > 0 && written < size+grow {
+		q.Shrink(size + grow - max(written, size))
+	}
 
-	return file, nil
+	return n, max(size, off+int64(n)), err
 }
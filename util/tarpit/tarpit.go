@@ -0,0 +1,93 @@
+package tarpit
+
+import (
+	"context"
+	"math/rand/v2"
+	"net"
+	"time"
+)
+
+// Tarpit holds clients by writing to them as slowly as they will tolerate
+type Tarpit struct {
+	Interval    time.Duration // time between writes
+	MaxDuration time.Duration // zero holds the client until it disconnects
+}
+
+// Result is what a client cost itself while tarpitted
+type Result struct {
+	Duration time.Duration
+	Bytes    int
+}
+
+// Banner dribbles an endless pre-banner at conn - RFC 4253 lets a server send other lines before its
+// identification string so clients keep reading them (see https://github.com/skeeto/endlessh)
+func (t Tarpit) Banner(ctx context.Context, conn net.Conn) Result {
+	return t.drip(ctx, conn, nil, func() []byte {
+		return bannerLine()
+	})
+}
+
+// KEX drip-feeds a key exchange reply one byte at a time - the client has sent its KEXINIT and waits on
+// a packet whose announced length is never reached
+func (t Tarpit) KEX(ctx context.Context, conn net.Conn) Result {
+	// uint32 packet_length, byte padding_length - the largest packet clients commonly accept
+	header := []byte{0x00, 0x03, 0xff, 0xfc, 0x04}
+
+	return t.drip(ctx, conn, header, func() []byte {
+		return []byte{byte(rand.IntN(256))} // #nosec
+	})
+}
+
+func (t Tarpit) drip(ctx context.Context, conn net.Conn, first []byte, next func() []byte) (result Result) {
+	started := time.Now()
+
+	defer func() {
+		result.Duration = time.Since(started)
+	}()
+
+	if t.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.MaxDuration)
+		defer cancel()
+	}
+
+	interval := t.Interval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for chunk := first; ; chunk = next() {
+		if len(chunk) > 0 {
+			_ = conn.SetWriteDeadline(time.Now().Add(interval))
+
+			n, err := conn.Write(chunk)
+			result.Bytes += n
+
+			if err != nil {
+				return result
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return result
+		case <-ticker.C:
+		}
+	}
+}
+
+const bannerAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// bannerLine returns a short random line which can't be mistaken for an identification string
+func bannerLine() []byte {
+	line := make([]byte, 3+rand.IntN(29), 34) // #nosec
+
+	for idx := range line {
+		line[idx] = bannerAlphabet[rand.IntN(len(bannerAlphabet))] // #nosec
+	}
+
+	return append(line, '\r', '\n')
+}
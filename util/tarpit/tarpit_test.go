@@ -0,0 +1,57 @@
+package tarpit
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBanner(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	done := make(chan Result, 1)
+
+	go func() {
+		done <- Tarpit{Interval: time.Millisecond, MaxDuration: 50 * time.Millisecond}.Banner(context.Background(), server)
+	}()
+
+	reader := bufio.NewReader(client)
+
+	for idx := 0; idx < 3; idx++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.HasPrefix(line, "SSH-") || !strings.HasSuffix(line, "\r\n") {
+			t.Fatalf("unexpected banner line %q", line)
+		}
+	}
+
+	_ = client.Close()
+
+	result := <-done
+	if result.Bytes == 0 || result.Duration == 0 {
+		t.Fatalf("expected time and bytes to be recorded got %+v", result)
+	}
+}
+
+func TestKEXHeader(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	go Tarpit{Interval: time.Millisecond, MaxDuration: 50 * time.Millisecond}.KEX(context.Background(), server)
+
+	header := make([]byte, 5)
+	if _, err := client.Read(header); err != nil {
+		t.Fatal(err)
+	}
+
+	if header[0] != 0x00 || header[1] != 0x03 {
+		t.Fatalf("unexpected packet header %x", header)
+	}
+}
@@ -0,0 +1,120 @@
+package token
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Kind is the sort of secret a honeytoken poses as
+type Kind string
+
+const (
+	KindAWSAccessKeyID     Kind = "aws-access-key-id"
+	KindAWSSecretAccessKey Kind = "aws-secret-access-key"
+	KindAPIToken           Kind = "api-token"
+	KindSSHKey             Kind = "ssh-key"
+	KindDatabaseURL        Kind = "database-url"
+	KindHostname           Kind = "hostname"
+	KindPassword           Kind = "password"
+)
+
+// Token is a honeytoken and the session it was issued to - an SSH key is recorded by the SHA256
+// fingerprint of its public half
+type Token struct {
+	Value     string    `json:"value"`
+	Kind      Kind      `json:"kind"`
+	Path      string    `json:"path"`
+	SessionID string    `json:"session_id"`
+	Address   string    `json:"address"`
+	User      string    `json:"user"`
+	Hostname  string    `json:"hostname"`
+	Issued    time.Time `json:"issued"`
+}
+
+// Registry is the append-only record of every honeytoken issued
+type Registry struct {
+	path string
+	mu   sync.Mutex
+}
+
+func Open(dir string) (*Registry, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	return &Registry{path: filepath.Join(dir, "issued.log")}, nil
+}
+
+// Issue records the token
+func (r *Registry) Issue(t Token) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewEncoder(f).Encode(t)
+}
+
+// Lookup returns every issue of value - which may also be an SSH private or public key
+func (r *Registry) Lookup(value string) ([]Token, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	value = Normalize(value)
+
+	f, err := os.Open(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var found []Token
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		var t Token
+
+		if err := json.Unmarshal(scanner.Bytes(), &t); err != nil {
+			continue
+		}
+
+		if t.Value == value {
+			found = append(found, t)
+		}
+	}
+
+	return found, scanner.Err()
+}
+
+// Normalize turns an SSH key into the fingerprint it is recorded by, anything else is trimmed
+func Normalize(value string) string {
+	value = strings.TrimSpace(value)
+
+	if key, err := ssh.ParsePrivateKey([]byte(value)); err == nil {
+		return ssh.FingerprintSHA256(key.PublicKey())
+	}
+
+	if key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(value)); err == nil {
+		return ssh.FingerprintSHA256(key)
+	}
+
+	return value
+}
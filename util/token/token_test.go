@@ -0,0 +1,70 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestLookup(t *testing.T) {
+	registry, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tokens, err := registry.Lookup("AKIAEXAMPLE"); err != nil || len(tokens) != 0 {
+		t.Fatalf("expected nothing from an empty registry got %v %v", tokens, err)
+	}
+
+	for _, issued := range []Token{
+		{Value: "AKIAEXAMPLE", Kind: KindAWSAccessKeyID, SessionID: "one", Address: "192.0.2.1:4000"},
+		{Value: "ghp_example", Kind: KindAPIToken, SessionID: "one", Address: "192.0.2.1:4000"},
+		{Value: "AKIAEXAMPLE", Kind: KindAWSAccessKeyID, SessionID: "two", Address: "198.51.100.7:5000"},
+	} {
+		issued.Issued = time.Now()
+
+		if err := registry.Issue(issued); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tokens, err := registry.Lookup("  AKIAEXAMPLE\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tokens) != 2 || tokens[0].SessionID != "one" || tokens[1].Address != "198.51.100.7:5000" {
+		t.Fatalf("unexpected tokens %+v", tokens)
+	}
+}
+
+func TestNormalizeSSHKey(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(private, "root@web01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fingerprint := ssh.FingerprintSHA256(publicKey)
+
+	if value := Normalize(string(pem.EncodeToMemory(block))); value != fingerprint {
+		t.Fatalf("expected the private key's fingerprint %s got %q", fingerprint, value)
+	}
+
+	if value := Normalize(string(ssh.MarshalAuthorizedKey(publicKey))); value != fingerprint {
+		t.Fatalf("expected the public key's fingerprint %s got %q", fingerprint, value)
+	}
+}
@@ -0,0 +1,45 @@
+package uplink
+
+import (
+	pb "github.com/ArchiMoebius/uplink/pkg/gen/v1"
+	"github.com/sirupsen/logrus"
+
+	"github.com/archimoebius/fishler/util"
+	"github.com/archimoebius/fishler/util/event"
+)
+
+var authMethods = map[string]pb.AuthMethod{
+	event.AuthMethodPassword:            pb.AuthMethod_AUTH_METHOD_PASSWORD,
+	event.AuthMethodPublicKey:           pb.AuthMethod_AUTH_METHOD_PUBLICKEY,
+	event.AuthMethodKeyboardInteractive: pb.AuthMethod_AUTH_METHOD_KEYBOARD_INTERACTIVE,
+}
+
+// NewSSHConnectionEvent converts e into the uplink wire format - the event kind and fields travel as extension fields
+func NewSSHConnectionEvent(serviceUUID []byte, e *event.Event) *pb.SSHConnectionEvent {
+	message := &pb.SSHConnectionEvent{
+		TimestampMicros: e.Timestamp.UnixMicro(),
+		ServiceUuid:     serviceUUID,
+		SessionUuid:     []byte(e.SessionID),
+		Username:        []byte(e.Username),
+		Password:        []byte(e.Password),
+		SshClientName:   e.ClientVersion,
+		Hassh:           []byte(e.HASSH),
+	}
+
+	if method, ok := authMethods[e.AuthMethod]; ok {
+		message.AuthMethods = []pb.AuthMethod{method}
+	}
+
+	if e.RemoteAddr != nil {
+		if err := util.ParseNetAddr(e.RemoteAddr, message); err != nil {
+			util.Logger.WithFields(logrus.Fields{
+				"address": e.RemoteAddr.String(),
+				"error":   err,
+			}).Error("failed to parse source address")
+		}
+	}
+
+	SetExtension(message, string(e.Kind), e.Fields)
+
+	return message
+}
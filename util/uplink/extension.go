@@ -0,0 +1,116 @@
+package uplink
+
+import (
+	"errors"
+	"maps"
+	"slices"
+
+	pb "github.com/ArchiMoebius/uplink/pkg/gen/v1"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Extension fields carried alongside the published SSHConnectionEvent schema. Collectors built
+// against a schema which declares
+//
+//	string event_type = 100;
+//	map<string, string> attributes = 101;
+//
+// decode them natively - older collectors skip them as unknown fields.
+const (
+	fieldEventType  protowire.Number = 100
+	fieldAttributes protowire.Number = 101
+)
+
+// SetExtension attaches the event type and attributes to event as extension fields
+func SetExtension(event *pb.SSHConnectionEvent, eventType string, attributes map[string]string) {
+	var raw []byte
+
+	raw = protowire.AppendTag(raw, fieldEventType, protowire.BytesType)
+	raw = protowire.AppendString(raw, eventType)
+
+	// sorted so identical events encode identically
+	for _, key := range slices.Sorted(maps.Keys(attributes)) {
+		var entry []byte
+
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, key)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendString(entry, attributes[key])
+
+		raw = protowire.AppendTag(raw, fieldAttributes, protowire.BytesType)
+		raw = protowire.AppendBytes(raw, entry)
+	}
+
+	event.ProtoReflect().SetUnknown(raw)
+}
+
+// Extension reads back the event type and attributes written by SetExtension
+func Extension(event *pb.SSHConnectionEvent) (eventType string, attributes map[string]string, err error) {
+	raw := event.ProtoReflect().GetUnknown()
+	attributes = make(map[string]string)
+
+	for len(raw) > 0 {
+		number, wireType, n := protowire.ConsumeTag(raw)
+		if n < 0 {
+			return "", nil, protowire.ParseError(n)
+		}
+		raw = raw[n:]
+
+		if wireType != protowire.BytesType || (number != fieldEventType && number != fieldAttributes) {
+			n = protowire.ConsumeFieldValue(number, wireType, raw)
+			if n < 0 {
+				return "", nil, protowire.ParseError(n)
+			}
+			raw = raw[n:]
+			continue
+		}
+
+		value, n := protowire.ConsumeBytes(raw)
+		if n < 0 {
+			return "", nil, protowire.ParseError(n)
+		}
+		raw = raw[n:]
+
+		if number == fieldEventType {
+			eventType = string(value)
+			continue
+		}
+
+		key, val, err := consumeMapEntry(value)
+		if err != nil {
+			return "", nil, err
+		}
+		attributes[key] = val
+	}
+
+	return eventType, attributes, nil
+}
+
+func consumeMapEntry(entry []byte) (key, value string, err error) {
+	for len(entry) > 0 {
+		number, wireType, n := protowire.ConsumeTag(entry)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		entry = entry[n:]
+
+		if wireType != protowire.BytesType {
+			return "", "", errors.New("unexpected map entry wire type")
+		}
+
+		data, n := protowire.ConsumeBytes(entry)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		entry = entry[n:]
+
+		switch number {
+		case 1:
+			key = string(data)
+		case 2:
+			value = string(data)
+		}
+	}
+
+	return key, value, nil
+}
@@ -0,0 +1,46 @@
+package uplink
+
+import (
+	"testing"
+
+	pb "github.com/ArchiMoebius/uplink/pkg/gen/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestExtensionRoundTrip(t *testing.T) {
+	event := &pb.SSHConnectionEvent{
+		Username: []byte("root"),
+	}
+
+	SetExtension(event, "exec", map[string]string{
+		"command": "uname -a",
+		"pty":     "false",
+	})
+
+	data, err := proto.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := &pb.SSHConnectionEvent{}
+	if err := proto.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(decoded.Username) != "root" {
+		t.Fatalf("expected the known fields to survive got %s", decoded.Username)
+	}
+
+	eventType, attributes, err := Extension(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if eventType != "exec" {
+		t.Fatalf("expected event type exec got %s", eventType)
+	}
+
+	if attributes["command"] != "uname -a" || attributes["pty"] != "false" {
+		t.Fatalf("unexpected attributes %v", attributes)
+	}
+}
@@ -0,0 +1,196 @@
+package uplink
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	pb "github.com/ArchiMoebius/uplink/pkg/gen/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxRecordSize bounds a single journal record so a corrupt length prefix can't exhaust memory
+const maxRecordSize = 1024 * 1024
+
+// errCorruptRecord is a record that can never be replayed - anything else Peek fails with is
+// worth retrying
+var errCorruptRecord = errors.New("corrupt journal record")
+
+// journal is an append-only file of length prefixed events with a persisted replay offset
+//
+// record layout:
+//
+//	uint32   length (big endian)
+//	byte[]   SSHConnectionEvent (protobuf)
+type journal struct {
+	path   string
+	file   *os.File
+	offset int64
+	size   int64
+}
+
+func openJournal(path string) (*journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600) // #nosec
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	j := &journal{
+		path: path,
+		file: file,
+		size: stat.Size(),
+	}
+
+	data, err := os.ReadFile(j.offsetPath())
+	if err == nil {
+		j.offset, _ = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	}
+
+	if j.offset < 0 || j.offset > j.size {
+		j.offset = 0
+	}
+
+	return j, nil
+}
+
+func (j *journal) offsetPath() string {
+	return fmt.Sprintf("%s.offset", j.path)
+}
+
+// Empty reports whether every journaled event has been replayed
+func (j *journal) Empty() bool {
+	return j.offset >= j.size
+}
+
+func encodeRecord(event *pb.SSHConnectionEvent) ([]byte, error) {
+	data, err := proto.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	record := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(record, uint32(len(data))) // #nosec G115 -- bounded by the event size
+	copy(record[4:], data)
+
+	return record, nil
+}
+
+// Append writes the event to the end of the journal
+func (j *journal) Append(event *pb.SSHConnectionEvent) error {
+	record, err := encodeRecord(event)
+	if err != nil {
+		return err
+	}
+
+	n, err := j.file.WriteAt(record, j.size)
+	j.size += int64(n)
+
+	return err
+}
+
+// Prepend writes the event ahead of everything not yet replayed so it is replayed next
+//
+// The pending records are rewritten from the start of the file - the offset is reset first so a
+// crash part way through replays events twice rather than losing them
+func (j *journal) Prepend(event *pb.SSHConnectionEvent) error {
+	if j.Empty() {
+		return j.Append(event)
+	}
+
+	record, err := encodeRecord(event)
+	if err != nil {
+		return err
+	}
+
+	pending := make([]byte, j.size-j.offset)
+	if _, err := j.file.ReadAt(pending, j.offset); err != nil {
+		return err
+	}
+
+	if err := j.Advance(0); err != nil {
+		return err
+	}
+
+	data := append(record, pending...)
+
+	n, err := j.file.WriteAt(data, 0)
+	j.size = max(j.size, int64(n))
+
+	if err != nil {
+		return err
+	}
+
+	// the replayed records ahead of offset may have been longer than the event
+	j.size = int64(len(data))
+
+	return j.file.Truncate(j.size)
+}
+
+// Peek returns the oldest event not yet replayed and the offset of the record following it
+//
+// A failed read leaves the offset where it is so the record is retried, only an errCorruptRecord
+// carries the offset to skip ahead to - the end of the journal when the length can't be trusted
+func (j *journal) Peek() (*pb.SSHConnectionEvent, int64, error) {
+	if j.Empty() {
+		return nil, j.offset, io.EOF
+	}
+
+	header := make([]byte, 4)
+	if _, err := j.file.ReadAt(header, j.offset); err != nil {
+		return nil, j.offset, err
+	}
+
+	length := int64(binary.BigEndian.Uint32(header))
+	next := j.offset + 4 + length
+
+	if length > maxRecordSize || next > j.size {
+		return nil, j.size, errCorruptRecord
+	}
+
+	data := make([]byte, length)
+	if _, err := j.file.ReadAt(data, j.offset+4); err != nil {
+		return nil, j.offset, err
+	}
+
+	event := &pb.SSHConnectionEvent{}
+	if err := proto.Unmarshal(data, event); err != nil {
+		return nil, next, fmt.Errorf("%w: %w", errCorruptRecord, err)
+	}
+
+	return event, next, nil
+}
+
+// Advance marks everything before offset as replayed - the journal is truncated once fully replayed
+func (j *journal) Advance(offset int64) error {
+	j.offset = offset
+
+	if j.Empty() {
+		j.offset = 0
+		j.size = 0
+
+		if err := j.file.Truncate(0); err != nil {
+			return err
+		}
+	}
+
+	return os.WriteFile(j.offsetPath(), []byte(strconv.FormatInt(j.offset, 10)), 0600)
+}
+
+func (j *journal) Close() error {
+	return j.file.Close()
+}
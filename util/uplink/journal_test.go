@@ -0,0 +1,196 @@
+package uplink
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	pb "github.com/ArchiMoebius/uplink/pkg/gen/v1"
+)
+
+func TestJournalReplayOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+
+	j, err := openJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, username := range []string{"root", "admin", "oracle"} {
+		if err := j.Append(&pb.SSHConnectionEvent{Username: []byte(username)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	event, offset, err := j.Peek()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(event.Username) != "root" {
+		t.Fatalf("expected root to be replayed first got %s", event.Username)
+	}
+
+	if err := j.Advance(offset); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the replay offset must survive a restart
+	j, err = openJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	for _, expected := range []string{"admin", "oracle"} {
+		event, offset, err := j.Peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(event.Username) != expected {
+			t.Fatalf("expected %s got %s", expected, event.Username)
+		}
+
+		if err := j.Advance(offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !j.Empty() {
+		t.Fatal("expected the journal to be empty once replayed")
+	}
+
+	if _, _, err := j.Peek(); err != io.EOF {
+		t.Fatalf("expected io.EOF from an empty journal got %v", err)
+	}
+
+	if j.size != 0 {
+		t.Fatalf("expected the journal to be truncated once replayed got %d bytes", j.size)
+	}
+}
+
+func TestJournalPrepend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+
+	j, err := openJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, username := range []string{"replayed-already", "admin", "oracle"} {
+		if err := j.Append(&pb.SSHConnectionEvent{Username: []byte(username)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, offset, err := j.Peek()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := j.Advance(offset); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := j.Prepend(&pb.SSHConnectionEvent{Username: []byte("root")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err = openJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	for _, expected := range []string{"root", "admin", "oracle"} {
+		event, offset, err := j.Peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(event.Username) != expected {
+			t.Fatalf("expected %s got %s", expected, event.Username)
+		}
+
+		if err := j.Advance(offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !j.Empty() {
+		t.Fatal("expected the journal to be empty once replayed")
+	}
+}
+
+func TestJournalReadErrorKeepsOffset(t *testing.T) {
+	j, err := openJournal(filepath.Join(t.TempDir(), "journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, username := range []string{"root", "admin"} {
+		if err := j.Append(&pb.SSHConnectionEvent{Username: []byte(username)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// every read now fails as a transient I/O error would
+	if err := j.file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, offset, err := j.Peek()
+	if err == nil || errors.Is(err, errCorruptRecord) {
+		t.Fatalf("expected a read error got %v", err)
+	}
+
+	if offset != j.offset {
+		t.Fatalf("expected the offset to stay at %d got %d so the backlog would be dropped", j.offset, offset)
+	}
+}
+
+func TestJournalSkipsCorruptRecord(t *testing.T) {
+	j, err := openJournal(filepath.Join(t.TempDir(), "journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	// a record whose body isn't a protobuf followed by a good one
+	if _, err := j.file.WriteAt([]byte{0, 0, 0, 2, 0xff, 0xff}, 0); err != nil {
+		t.Fatal(err)
+	}
+	j.size = 6
+
+	if err := j.Append(&pb.SSHConnectionEvent{Username: []byte("root")}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, offset, err := j.Peek()
+	if !errors.Is(err, errCorruptRecord) {
+		t.Fatalf("expected a corrupt record got %v", err)
+	}
+
+	if err := j.Advance(offset); err != nil {
+		t.Fatal(err)
+	}
+
+	event, _, err := j.Peek()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(event.Username) != "root" {
+		t.Fatalf("expected the record after the corrupt one got %s", event.Username)
+	}
+}
@@ -0,0 +1,271 @@
+package uplink
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	client "github.com/ArchiMoebius/uplink/client"
+	pb "github.com/ArchiMoebius/uplink/pkg/gen/v1"
+	"github.com/sirupsen/logrus"
+
+	"github.com/archimoebius/fishler/util"
+	"github.com/archimoebius/fishler/util/metrics"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// Queue delivers events to the uplink server in order without blocking the caller
+//
+// Events are held in memory while the server is reachable; on a send failure (or
+// when the memory buffer fills) they spill to an on-disk journal which is replayed,
+// oldest first, once the connection is re-established.
+type Queue struct {
+	address  string
+	client   *client.BeamClient
+	events   chan *pb.SSHConnectionEvent
+	notify   chan struct{}
+	journal  *journal
+	mu       sync.Mutex
+	spilling bool
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewQueue creates a queue for address journaling to journalPath and starts delivering events
+func NewQueue(address string, journalPath string, size int) (*Queue, error) {
+	j, err := openJournal(journalPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if size < 1 {
+		size = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	q := &Queue{
+		address:  address,
+		events:   make(chan *pb.SSHConnectionEvent, size),
+		notify:   make(chan struct{}, 1),
+		journal:  j,
+		spilling: !j.Empty(), // left over from a previous run
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+
+	go q.run(ctx)
+
+	return q, nil
+}
+
+// Enqueue hands the event off for delivery - it never blocks on the network
+func (q *Queue) Enqueue(event *pb.SSHConnectionEvent) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.spilling {
+		select {
+		case q.events <- event:
+			return
+		default:
+		}
+
+		// the memory buffer is full - move it to disk so ordering is kept
+		q.spill()
+	}
+
+	if err := q.journal.Append(event); err != nil {
+		util.Logger.WithError(err).Error("failed to journal uplink event")
+	}
+
+	q.wake()
+}
+
+// Close stops delivery and journals anything still pending so it is replayed on the next run
+func (q *Queue) Close() error {
+	q.cancel()
+	<-q.done
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.spill()
+
+	if q.client != nil {
+		_ = q.client.Close()
+		q.client = nil
+	}
+
+	return q.journal.Close()
+}
+
+// spill moves the in-memory buffer to the journal - callers must hold q.mu
+func (q *Queue) spill() {
+	q.spilling = true
+
+	for {
+		select {
+		case event := <-q.events:
+			if err := q.journal.Append(event); err != nil {
+				util.Logger.WithError(err).Error("failed to journal uplink event")
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (q *Queue) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// next blocks until an event is available - journaled events are always replayed first
+func (q *Queue) next(ctx context.Context) (event *pb.SSHConnectionEvent, offset int64, journaled bool) {
+	for {
+		q.mu.Lock()
+		if q.spilling {
+			event, offset, err := q.journal.Peek()
+
+			switch {
+			case err == nil:
+				q.mu.Unlock()
+				return event, offset, true
+			case err == io.EOF:
+				q.spilling = false
+			case errors.Is(err, errCorruptRecord):
+				util.Logger.WithError(err).Error("skipping corrupt uplink journal record")
+
+				if err := q.journal.Advance(offset); err != nil {
+					util.Logger.WithError(err).Error("failed to advance uplink journal")
+				}
+
+				q.mu.Unlock()
+				continue
+			default:
+				q.mu.Unlock()
+
+				// the record is still there - read it again rather than dropping the backlog
+				util.Logger.WithError(err).Error("failed to read uplink journal record - will retry")
+
+				select {
+				case <-ctx.Done():
+					return nil, 0, false
+				case <-time.After(minBackoff):
+				}
+
+				continue
+			}
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, 0, false
+		case event := <-q.events:
+			return event, 0, false
+		case <-q.notify:
+		}
+	}
+}
+
+func (q *Queue) run(ctx context.Context) {
+	defer close(q.done)
+
+	backoff := minBackoff
+
+	for {
+		event, offset, journaled := q.next(ctx)
+		if event == nil {
+			return
+		}
+
+		if err := q.send(event); err != nil {
+			metrics.UplinkSendFailures.Inc()
+
+			util.Logger.WithFields(logrus.Fields{
+				"server":  q.address,
+				"error":   err,
+				"backoff": backoff.String(),
+			}).Warn("uplink event send failure - will retry")
+
+			if !journaled {
+				q.mu.Lock()
+				// the failed event is older than anything Enqueue journaled while it was in flight
+				// so it must lead the journal
+				if err := q.journal.Prepend(event); err != nil {
+					util.Logger.WithError(err).Error("failed to journal uplink event")
+				}
+				q.spill()
+				q.mu.Unlock()
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff/2 + time.Duration(rand.Int63n(int64(backoff)))): // #nosec
+			}
+
+			backoff = min(backoff*2, maxBackoff)
+
+			q.reconnect()
+			continue
+		}
+
+		backoff = minBackoff
+
+		if journaled {
+			q.mu.Lock()
+			if err := q.journal.Advance(offset); err != nil {
+				util.Logger.WithError(err).Error("failed to advance uplink journal")
+			}
+			q.mu.Unlock()
+		}
+	}
+}
+
+func (q *Queue) send(event *pb.SSHConnectionEvent) error {
+	if q.client == nil {
+		beamClient, err := client.NewBeamClient(q.address)
+		if err != nil {
+			return err
+		}
+
+		q.client = beamClient
+
+		util.Logger.WithFields(logrus.Fields{
+			"server": q.address,
+			"state":  beamClient.GetState(),
+		}).Info("connected to uplink server")
+	}
+
+	return q.client.SendEvent(event)
+}
+
+func (q *Queue) reconnect() {
+	metrics.UplinkReconnects.Inc()
+
+	if q.client == nil {
+		return // send will dial a fresh client
+	}
+
+	if err := q.client.Reconnect(); err != nil {
+		util.Logger.WithFields(logrus.Fields{
+			"server": q.address,
+			"error":  err,
+		}).Warn("failed to reconnect to uplink server")
+
+		_ = q.client.Close()
+		q.client = nil
+	}
+}
@@ -0,0 +1,163 @@
+package uplink
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "github.com/ArchiMoebius/uplink/pkg/gen/v1"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/archimoebius/fishler/util"
+)
+
+func TestMain(m *testing.M) {
+	util.Logger = logrus.New()
+	util.Logger.SetOutput(io.Discard)
+
+	os.Exit(m.Run())
+}
+
+// server collects the usernames of the events beamed to it
+type server struct {
+	pb.UnimplementedTransporterServer
+	usernames chan string
+}
+
+func (s *server) Beam(stream grpc.ClientStreamingServer[pb.SSHConnectionEvent, emptypb.Empty]) error {
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		s.usernames <- string(event.Username)
+	}
+}
+
+// freeAddress returns a loopback address nothing is listening on
+func freeAddress(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address := listener.Addr().String()
+	_ = listener.Close()
+
+	return address
+}
+
+func serve(t *testing.T, address string) *server {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &server{usernames: make(chan string, 16)}
+
+	g := grpc.NewServer()
+	pb.RegisterTransporterServer(g, s)
+
+	go func() {
+		_ = g.Serve(listener)
+	}()
+
+	t.Cleanup(g.Stop)
+
+	return s
+}
+
+func journaled(q *Queue) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.spilling && !q.journal.Empty()
+}
+
+func expectUsernames(t *testing.T, s *server, expected ...string) {
+	t.Helper()
+
+	for _, username := range expected {
+		select {
+		case got := <-s.usernames:
+			if got != username {
+				t.Fatalf("expected %s got %s", username, got)
+			}
+		case <-time.After(15 * time.Second):
+			t.Fatalf("timed out waiting for %s", username)
+		}
+	}
+}
+
+func TestQueueReconnect(t *testing.T) {
+	address := freeAddress(t)
+
+	q, err := NewQueue(address, filepath.Join(t.TempDir(), "journal"), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	// the server is down so these fail and are journaled
+	for _, username := range []string{"root", "admin", "oracle"} {
+		q.Enqueue(&pb.SSHConnectionEvent{Username: []byte(username)})
+	}
+
+	deadline := time.Now().Add(15 * time.Second)
+
+	for !journaled(q) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the events to be journaled")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s := serve(t, address)
+
+	expectUsernames(t, s, "root", "admin", "oracle")
+
+	q.Enqueue(&pb.SSHConnectionEvent{Username: []byte("pi")})
+
+	expectUsernames(t, s, "pi")
+}
+
+func TestQueueReplayAfterRestart(t *testing.T) {
+	address := freeAddress(t)
+	journalPath := filepath.Join(t.TempDir(), "journal")
+
+	q, err := NewQueue(address, journalPath, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, username := range []string{"root", "admin"} {
+		q.Enqueue(&pb.SSHConnectionEvent{Username: []byte(username)})
+	}
+
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := serve(t, address)
+
+	q, err = NewQueue(address, journalPath, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	q.Enqueue(&pb.SSHConnectionEvent{Username: []byte("oracle")})
+
+	expectUsernames(t, s, "root", "admin", "oracle")
+}
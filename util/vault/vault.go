@@ -0,0 +1,67 @@
+package vault
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ErrTooLarge is returned when a payload exceeds the size limit it was stored with
+var ErrTooLarge = errors.New("payload too large")
+
+// Vault stores payloads on disk named by their sha256 so each is kept once
+type Vault struct {
+	dir string
+}
+
+func Open(dir string) (*Vault, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	return &Vault{dir: dir}, nil
+}
+
+// Path is where the payload with the given sha256 is stored
+func (v *Vault) Path(sum string) string {
+	return filepath.Join(v.dir, sum)
+}
+
+// Store copies r into the vault returning its sha256 and size - a limit of zero is unlimited
+func (v *Vault) Store(r io.Reader, limit int64) (string, int64, error) {
+	incoming, err := os.CreateTemp(v.dir, ".incoming-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(incoming.Name())
+
+	if limit > 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+
+	hash := sha256.New()
+
+	size, err := io.Copy(io.MultiWriter(incoming, hash), r)
+	if closeErr := incoming.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return "", size, err
+	}
+
+	if limit > 0 && size > limit {
+		return "", size, ErrTooLarge
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	if _, err := os.Stat(v.Path(sum)); err == nil {
+		return sum, size, nil
+	}
+
+	return sum, size, os.Rename(incoming.Name(), v.Path(sum))
+}
@@ -0,0 +1,57 @@
+package vault
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	v, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum, size, err := v.Store(strings.NewReader("#!/bin/sh\n"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sum != "a8076d3d28d21e02012b20eaf7dbf75409a6277134439025f282e368e3305abf" {
+		t.Fatalf("unexpected sum %s", sum)
+	}
+
+	if size != 10 {
+		t.Fatalf("expected 10 bytes got %d", size)
+	}
+
+	stored, err := os.ReadFile(v.Path(sum))
+	if err != nil || string(stored) != "#!/bin/sh\n" {
+		t.Fatalf("unexpected vaulted payload %q (%v)", stored, err)
+	}
+
+	// storing again keeps the one copy
+	again, _, err := v.Store(strings.NewReader("#!/bin/sh\n"), 0)
+	if err != nil || again != sum {
+		t.Fatalf("expected the same sum got %s (%v)", again, err)
+	}
+}
+
+func TestStoreTooLarge(t *testing.T) {
+	dir := t.TempDir()
+
+	v, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := v.Store(strings.NewReader("0123456789"), 4); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge got %v", err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Fatalf("expected nothing to be vaulted got %d entries", len(entries))
+	}
+}
@@ -3,125 +3,589 @@ package util
 import (
 	"archive/tar"
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
 	"fmt"
-	"io"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/GehirnInc/crypt/sha512_crypt"
 )
 
-func GetProfileBuffer(username string) ([]byte, error) {
-	var buf bytes.Buffer
-	tw := tar.NewWriter(&buf)
+// Distro is the family of Linux distribution the account files of a profile are modelled on
+type Distro string
+
+const (
+	DistroAlpine Distro = "alpine"
+	DistroDebian Distro = "debian"
+	DistroCentOS Distro = "centos"
+)
+
+// ParseOSRelease picks the Distro of an /etc/os-release - anything unknown is taken for Alpine, the
+// fishler image's base
+func ParseOSRelease(osRelease string) Distro {
+	var ids []string
+
+	for line := range strings.SplitSeq(osRelease, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok || (key != "ID" && key != "ID_LIKE") {
+			continue
+		}
+
+		ids = append(ids, strings.Fields(strings.ToLower(strings.Trim(value, `"'`)))...)
+	}
+
+	for _, id := range ids {
+		switch id {
+		case "alpine":
+			return DistroAlpine
+		case "debian", "ubuntu":
+			return DistroDebian
+		case "centos", "rhel", "fedora", "rocky", "almalinux":
+			return DistroCentOS
+		}
+	}
+
+	return DistroAlpine
+}
 
-	var extra_user = ""
-	var extra_group = ""
-
-	if username != "root" {
-		extra_user = fmt.Sprintf("\n%s:x:1000:1000:%s:/home/%s:/bin/ash\n", username, username, username)
-		extra_group = fmt.Sprintf("\n%s:x:1000:\n", username)
-	}
-
-	var files = []struct {
-		Name, Body string
-	}{
-		{
-			"group",
-			fmt.Sprintf(`
-root:x:0:root
-bin:x:1:root,bin,daemon
-daemon:x:2:root,bin,daemon
-sys:x:3:root,bin,adm
-adm:x:4:root,adm,daemon
-tty:x:5:
-disk:x:6:root,adm
-lp:x:7:lp
-mem:x:8:
-kmem:x:9:
-wheel:x:10:root
-floppy:x:11:root
-mail:x:12:mail
-news:x:13:news
-uucp:x:14:uucp
-man:x:15:man
-cron:x:16:cron
-console:x:17:
-audio:x:18:
-cdrom:x:19:
-dialout:x:20:root
-ftp:x:21:
-sshd:x:22:
-input:x:23:
-at:x:25:at
-tape:x:26:root
-video:x:27:root
-netdev:x:28:
-readproc:x:30:
-squid:x:31:squid
-xfs:x:33:xfs
-kvm:x:34:kvm
-games:x:35:
-shadow:x:42:
-cdrw:x:80:
-www-data:x:82:
-usb:x:85:
-vpopmail:x:89:
-users:x:100:games
-ntp:x:123:
-nofiles:x:200:
-smmsp:x:209:smmsp
-locate:x:245:
-abuild:x:300:
-utmp:x:406:
-ping:x:999:
-nogroup:x:65533:
-nobody:x:65534:%s`, extra_group),
+// ContextKeyPassword is where the password a session authenticated with is kept in its context
+var ContextKeyPassword = &struct{ name string }{"password"}
+
+// Profile is the account a session logs in as - Password, the one the attacker used, is hashed into
+// /etc/shadow so it matches what they typed, an empty one (a key login) leaves the account locked
+//
+// Secret keys everything else picked for the profile so it can't be predicted from the username
+type Profile struct {
+	Distro   Distro
+	User     string
+	Password string
+	Secret   []byte
+}
+
+type account struct {
+	name  string
+	uid   int
+	gid   int
+	gecos string
+	home  string
+	shell string
+}
+
+type group struct {
+	name    string
+	gid     int
+	members []string
+}
+
+// distroTemplate is the stock passwd/group of a distribution along with how it sets up a new user
+type distroTemplate struct {
+	accounts []account
+	groups   []group
+	// rootShell and shell are the login shells of root and of users added to the system
+	rootShell string
+	shell     string
+	// admin is the group granting sudo, userGroups the other groups the first user is put in
+	admin      string
+	userGroups []string
+	// locked is the shadow password of system accounts, aging the fields after the last change day
+	locked     string
+	aging      string
+	shadowGID  int
+	shadowMode int64
+}
+
+var distroTemplates = map[Distro]distroTemplate{
+	DistroAlpine: {
+		accounts: []account{
+			{"root", 0, 0, "root", "/root", ""},
+			{"bin", 1, 1, "bin", "/bin", "/sbin/nologin"},
+			{"daemon", 2, 2, "daemon", "/sbin", "/sbin/nologin"},
+			{"adm", 3, 4, "adm", "/var/adm", "/sbin/nologin"},
+			{"lp", 4, 7, "lp", "/var/spool/lpd", "/sbin/nologin"},
+			{"sync", 5, 0, "sync", "/sbin", "/bin/sync"},
+			{"shutdown", 6, 0, "shutdown", "/sbin", "/sbin/shutdown"},
+			{"halt", 7, 0, "halt", "/sbin", "/sbin/halt"},
+			{"mail", 8, 12, "mail", "/var/mail", "/sbin/nologin"},
+			{"news", 9, 13, "news", "/usr/lib/news", "/sbin/nologin"},
+			{"uucp", 10, 14, "uucp", "/var/spool/uucppublic", "/sbin/nologin"},
+			{"operator", 11, 0, "operator", "/root", "/sbin/nologin"},
+			{"man", 13, 15, "man", "/usr/man", "/sbin/nologin"},
+			{"postmaster", 14, 12, "postmaster", "/var/mail", "/sbin/nologin"},
+			{"cron", 16, 16, "cron", "/var/spool/cron", "/sbin/nologin"},
+			{"ftp", 21, 21, "", "/var/lib/ftp", "/sbin/nologin"},
+			{"sshd", 22, 22, "sshd", "/dev/null", "/sbin/nologin"},
+			{"at", 25, 25, "at", "/var/spool/cron/atjobs", "/sbin/nologin"},
+			{"squid", 31, 31, "Squid", "/var/cache/squid", "/sbin/nologin"},
+			{"xfs", 33, 33, "X Font Server", "/etc/X11/fs", "/sbin/nologin"},
+			{"games", 35, 35, "games", "/usr/games", "/sbin/nologin"},
+			{"cyrus", 85, 12, "", "/usr/cyrus", "/sbin/nologin"},
+			{"vpopmail", 89, 89, "", "/var/vpopmail", "/sbin/nologin"},
+			{"ntp", 123, 123, "NTP", "/var/empty", "/sbin/nologin"},
+			{"smmsp", 209, 209, "smmsp", "/var/spool/mqueue", "/sbin/nologin"},
+			{"guest", 405, 100, "guest", "/dev/null", "/sbin/nologin"},
+			{"nobody", 65534, 65534, "nobody", "/", "/sbin/nologin"},
+		},
+		groups: []group{
+			{"root", 0, []string{"root"}},
+			{"bin", 1, []string{"root", "bin", "daemon"}},
+			{"daemon", 2, []string{"root", "bin", "daemon"}},
+			{"sys", 3, []string{"root", "bin", "adm"}},
+			{"adm", 4, []string{"root", "adm", "daemon"}},
+			{"tty", 5, nil},
+			{"disk", 6, []string{"root", "adm"}},
+			{"lp", 7, []string{"lp"}},
+			{"mem", 8, nil},
+			{"kmem", 9, nil},
+			{"wheel", 10, []string{"root"}},
+			{"floppy", 11, []string{"root"}},
+			{"mail", 12, []string{"mail"}},
+			{"news", 13, []string{"news"}},
+			{"uucp", 14, []string{"uucp"}},
+			{"man", 15, []string{"man"}},
+			{"cron", 16, []string{"cron"}},
+			{"console", 17, nil},
+			{"audio", 18, nil},
+			{"cdrom", 19, nil},
+			{"dialout", 20, []string{"root"}},
+			{"ftp", 21, nil},
+			{"sshd", 22, nil},
+			{"input", 23, nil},
+			{"at", 25, []string{"at"}},
+			{"tape", 26, []string{"root"}},
+			{"video", 27, []string{"root"}},
+			{"netdev", 28, nil},
+			{"readproc", 30, nil},
+			{"squid", 31, []string{"squid"}},
+			{"xfs", 33, []string{"xfs"}},
+			{"kvm", 34, []string{"kvm"}},
+			{"games", 35, nil},
+			{"shadow", 42, nil},
+			{"cdrw", 80, nil},
+			{"www-data", 82, nil},
+			{"usb", 85, nil},
+			{"vpopmail", 89, nil},
+			{"users", 100, []string{"games"}},
+			{"ntp", 123, nil},
+			{"nofiles", 200, nil},
+			{"smmsp", 209, []string{"smmsp"}},
+			{"locate", 245, nil},
+			{"abuild", 300, nil},
+			{"utmp", 406, nil},
+			{"ping", 999, nil},
+			{"nogroup", 65533, nil},
+			{"nobody", 65534, nil},
+		},
+		rootShell:  "/bin/ash",
+		shell:      "/bin/ash",
+		admin:      "wheel",
+		locked:     "!",
+		aging:      "0:::::",
+		shadowGID:  42,
+		shadowMode: 0640,
+	},
+	DistroDebian: {
+		accounts: []account{
+			{"root", 0, 0, "root", "/root", ""},
+			{"daemon", 1, 1, "daemon", "/usr/sbin", "/usr/sbin/nologin"},
+			{"bin", 2, 2, "bin", "/bin", "/usr/sbin/nologin"},
+			{"sys", 3, 3, "sys", "/dev", "/usr/sbin/nologin"},
+			{"sync", 4, 65534, "sync", "/bin", "/bin/sync"},
+			{"games", 5, 60, "games", "/usr/games", "/usr/sbin/nologin"},
+			{"man", 6, 12, "man", "/var/cache/man", "/usr/sbin/nologin"},
+			{"lp", 7, 7, "lp", "/var/spool/lpd", "/usr/sbin/nologin"},
+			{"mail", 8, 8, "mail", "/var/mail", "/usr/sbin/nologin"},
+			{"news", 9, 9, "news", "/var/spool/news", "/usr/sbin/nologin"},
+			{"uucp", 10, 10, "uucp", "/var/spool/uucp", "/usr/sbin/nologin"},
+			{"proxy", 13, 13, "proxy", "/bin", "/usr/sbin/nologin"},
+			{"www-data", 33, 33, "www-data", "/var/www", "/usr/sbin/nologin"},
+			{"backup", 34, 34, "backup", "/var/backups", "/usr/sbin/nologin"},
+			{"list", 38, 38, "Mailing List Manager", "/var/list", "/usr/sbin/nologin"},
+			{"irc", 39, 39, "ircd", "/run/ircd", "/usr/sbin/nologin"},
+			{"_apt", 42, 65534, "", "/nonexistent", "/usr/sbin/nologin"},
+			{"nobody", 65534, 65534, "nobody", "/nonexistent", "/usr/sbin/nologin"},
+			{"systemd-network", 998, 998, "systemd Network Management", "/", "/usr/sbin/nologin"},
+			{"messagebus", 100, 107, "", "/nonexistent", "/usr/sbin/nologin"},
+			{"sshd", 101, 65534, "", "/run/sshd", "/usr/sbin/nologin"},
 		},
-		{
-			"passwd",
-			fmt.Sprintf(`
-root:x:0:0:root:/root:/sbin/nologin
-bin:x:1:1:bin:/bin:/sbin/nologin
-daemon:x:2:2:daemon:/sbin:/sbin/nologin
-adm:x:3:4:adm:/var/adm:/sbin/nologin
-lp:x:4:7:lp:/var/spool/lpd:/sbin/nologin
-sync:x:5:0:sync:/sbin:/bin/sync
-shutdown:x:6:0:shutdown:/sbin:/sbin/shutdown
-halt:x:7:0:halt:/sbin:/sbin/halt
-mail:x:8:12:mail:/var/mail:/sbin/nologin
-news:x:9:13:news:/usr/lib/news:/sbin/nologin
-uucp:x:10:14:uucp:/var/spool/uucppublic:/sbin/nologin
-operator:x:11:0:operator:/root:/sbin/nologin
-man:x:13:15:man:/usr/man:/sbin/nologin
-postmaster:x:14:12:postmaster:/var/mail:/sbin/nologin
-cron:x:16:16:cron:/var/spool/cron:/sbin/nologin
-ftp:x:21:21::/var/lib/ftp:/sbin/nologin
-sshd:x:22:22:sshd:/dev/null:/sbin/nologin
-at:x:25:25:at:/var/spool/cron/atjobs:/sbin/nologin
-squid:x:31:31:Squid:/var/cache/squid:/sbin/nologin
-xfs:x:33:33:X Font Server:/etc/X11/fs:/sbin/nologin
-games:x:35:35:games:/usr/games:/sbin/nologin
-cyrus:x:85:12::/usr/cyrus:/sbin/nologin
-vpopmail:x:89:89::/var/vpopmail:/sbin/nologin
-ntp:x:123:123:NTP:/var/empty:/sbin/nologin
-smmsp:x:209:209:smmsp:/var/spool/mqueue:/sbin/nologin
-guest:x:405:100:guest:/dev/null:/sbin/nologin
-nobody:x:65534:65534:nobody:/:/sbin/nologin%s`, extra_user),
+		groups: []group{
+			{"root", 0, nil},
+			{"daemon", 1, nil},
+			{"bin", 2, nil},
+			{"sys", 3, nil},
+			{"adm", 4, nil},
+			{"tty", 5, nil},
+			{"disk", 6, nil},
+			{"lp", 7, nil},
+			{"mail", 8, nil},
+			{"news", 9, nil},
+			{"uucp", 10, nil},
+			{"man", 12, nil},
+			{"proxy", 13, nil},
+			{"kmem", 15, nil},
+			{"dialout", 20, nil},
+			{"fax", 21, nil},
+			{"voice", 22, nil},
+			{"cdrom", 24, nil},
+			{"floppy", 25, nil},
+			{"tape", 26, nil},
+			{"sudo", 27, nil},
+			{"audio", 29, nil},
+			{"dip", 30, nil},
+			{"www-data", 33, nil},
+			{"backup", 34, nil},
+			{"operator", 37, nil},
+			{"list", 38, nil},
+			{"irc", 39, nil},
+			{"src", 40, nil},
+			{"shadow", 42, nil},
+			{"utmp", 43, nil},
+			{"video", 44, nil},
+			{"sasl", 45, nil},
+			{"plugdev", 46, nil},
+			{"staff", 50, nil},
+			{"games", 60, nil},
+			{"users", 100, nil},
+			{"systemd-journal", 999, nil},
+			{"systemd-network", 998, nil},
+			{"messagebus", 107, nil},
+			{"nogroup", 65534, nil},
 		},
+		rootShell:  "/bin/bash",
+		shell:      "/bin/bash",
+		admin:      "sudo",
+		userGroups: []string{"adm", "cdrom", "dip", "plugdev"},
+		locked:     "*",
+		aging:      "0:99999:7:::",
+		shadowGID:  42,
+		shadowMode: 0640,
+	},
+	DistroCentOS: {
+		accounts: []account{
+			{"root", 0, 0, "root", "/root", ""},
+			{"bin", 1, 1, "bin", "/bin", "/sbin/nologin"},
+			{"daemon", 2, 2, "daemon", "/sbin", "/sbin/nologin"},
+			{"adm", 3, 4, "adm", "/var/adm", "/sbin/nologin"},
+			{"lp", 4, 7, "lp", "/var/spool/lpd", "/sbin/nologin"},
+			{"sync", 5, 0, "sync", "/sbin", "/bin/sync"},
+			{"shutdown", 6, 0, "shutdown", "/sbin", "/sbin/shutdown"},
+			{"halt", 7, 0, "halt", "/sbin", "/sbin/halt"},
+			{"mail", 8, 12, "mail", "/var/spool/mail", "/sbin/nologin"},
+			{"operator", 11, 0, "operator", "/root", "/sbin/nologin"},
+			{"games", 12, 100, "games", "/usr/games", "/sbin/nologin"},
+			{"ftp", 14, 50, "FTP User", "/var/ftp", "/sbin/nologin"},
+			{"nobody", 65534, 65534, "Kernel Overflow User", "/", "/sbin/nologin"},
+			{"dbus", 81, 81, "System message bus", "/", "/sbin/nologin"},
+			{"systemd-coredump", 999, 997, "systemd Core Dumper", "/", "/sbin/nologin"},
+			{"tss", 59, 59, "Account used for TPM access", "/dev/null", "/sbin/nologin"},
+			{"polkitd", 998, 996, "User for polkitd", "/", "/sbin/nologin"},
+			{"chrony", 997, 995, "", "/var/lib/chrony", "/sbin/nologin"},
+			{"sshd", 74, 74, "Privilege-separated SSH", "/usr/share/empty.sshd", "/sbin/nologin"},
+		},
+		groups: []group{
+			{"root", 0, nil},
+			{"bin", 1, nil},
+			{"daemon", 2, nil},
+			{"sys", 3, nil},
+			{"adm", 4, nil},
+			{"tty", 5, nil},
+			{"disk", 6, nil},
+			{"lp", 7, nil},
+			{"mem", 8, nil},
+			{"kmem", 9, nil},
+			{"wheel", 10, nil},
+			{"cdrom", 11, nil},
+			{"mail", 12, nil},
+			{"man", 15, nil},
+			{"dialout", 18, nil},
+			{"floppy", 19, nil},
+			{"games", 20, nil},
+			{"tape", 33, nil},
+			{"video", 39, nil},
+			{"ftp", 50, nil},
+			{"lock", 54, nil},
+			{"audio", 63, nil},
+			{"users", 100, nil},
+			{"nobody", 65534, nil},
+			{"utmp", 22, nil},
+			{"utempter", 35, nil},
+			{"input", 999, nil},
+			{"kvm", 36, nil},
+			{"render", 998, nil},
+			{"systemd-journal", 190, nil},
+			{"systemd-coredump", 997, nil},
+			{"dbus", 81, nil},
+			{"tss", 59, nil},
+			{"polkitd", 996, nil},
+			{"chrony", 995, nil},
+			{"ssh_keys", 994, nil},
+			{"sshd", 74, nil},
+		},
+		rootShell:  "/bin/bash",
+		shell:      "/bin/bash",
+		admin:      "wheel",
+		locked:     "*",
+		aging:      "0:99999:7:::",
+		shadowGID:  0,
+		shadowMode: 0000,
+	},
+}
+
+// extraUsers are the people and services a host plausibly has accounts for besides the attacker's
+var extraUsers = []struct {
+	name  string
+	gecos string
+}{
+	{"admin", "Administrator"},
+	{"deploy", "Deploy User"},
+	{"jenkins", "Jenkins"},
+	{"git", "git version control"},
+	{"ansible", "Ansible"},
+	{"backup", "Backup"},
+	{"dev", "Developer"},
+	{"support", "Support"},
+	{"postgres", "PostgreSQL administrator"},
+	{"oracle", "Oracle"},
+}
+
+// profileEpoch is the day (since 1970) the profile's passwords were set around
+const profileEpoch = 19500
+
+// ProfileUser is the uid:gid the session's user is given in the profile - containers run as it so
+// docker never has to look the name up in the image's own /etc/passwd
+func ProfileUser(user string) string {
+	return fmt.Sprintf("%d:%d", ProfileID(user), ProfileID(user))
+}
+
+// ProfileID is the uid, and gid, the session's user is given in the profile
+func ProfileID(user string) int {
+	if user == "root" {
+		return 0
 	}
+
+	return profileUID
+}
+
+// profileUID is the uid (and gid) of the session's user when they aren't root
+const profileUID = 1000
+
+// profileFile is one of the account files a profile puts in /etc
+type profileFile struct {
+	name string
+	body string
+	mode int64
+	gid  int
+}
+
+// GetProfileBuffer generates the passwd, group and shadow of profile's distro as a tar for /etc - the
+// extra users, salts and dates are picked from the username and secret so they stay put across
+// sessions yet differ between sensors
+func GetProfileBuffer(profile Profile) ([]byte, error) {
+	files, modTime, err := buildProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
 	for _, file := range files {
 		hdr := &tar.Header{
-			Name: file.Name,
-			Mode: 0600,
-			Size: int64(len(file.Body)),
+			Name:    file.name,
+			Mode:    file.mode,
+			Gid:     file.gid,
+			Size:    int64(len(file.body)),
+			ModTime: modTime,
 		}
 		if err := tw.WriteHeader(hdr); err != nil {
-			Logger.Error(err)
+			return nil, err
 		}
-		if _, err := tw.Write([]byte(file.Body)); err != nil {
-			Logger.Error(err)
+		if _, err := tw.Write([]byte(file.body)); err != nil {
+			return nil, err
 		}
 	}
 	if err := tw.Close(); err != nil {
-		Logger.Error(err)
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// WriteProfile writes the passwd, group and shadow of profile into dir, for bind mounting over /etc
+// where the container's root filesystem can't be copied into - the group of shadow is only set
+// when running as root
+func WriteProfile(dir string, profile Profile) error {
+	files, modTime, err := buildProfile(profile)
+	if err != nil {
+		return err
+	}
+
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	for _, file := range files {
+		mode := os.FileMode(file.mode) // #nosec G115 -- permission bits
+
+		if err := root.WriteFile(file.name, []byte(file.body), mode); err != nil {
+			return err
+		}
+
+		if err := root.Chmod(file.name, mode); err != nil {
+			return err
+		}
+
+		if file.gid != 0 {
+			_ = root.Chown(file.name, -1, file.gid)
+		}
+
+		if err := root.Chtimes(file.name, modTime, modTime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// buildProfile generates the account files of profile along with when they were last modified
+func buildProfile(profile Profile) ([]profileFile, time.Time, error) {
+	base, ok := distroTemplates[profile.Distro]
+	if !ok {
+		return nil, time.Time{}, fmt.Errorf("unknown distro %q", profile.Distro)
+	}
+
+	mac := hmac.New(sha256.New, profile.Secret)
+	_, _ = mac.Write([]byte(profile.User))
+
+	rng := rand.New(rand.NewChaCha8([32]byte(mac.Sum(nil)))) // #nosec
+
+	// an attacker logging in as a system account gets a home like any other user
+	accounts := slices.DeleteFunc(slices.Clone(base.accounts), func(a account) bool {
+		return a.name == profile.User && a.name != "root"
+	})
+
+	groups := make([]group, len(base.groups))
+	for idx, g := range base.groups {
+		groups[idx] = group{g.name, g.gid, slices.Clone(g.members)}
+	}
+
+	shadow := map[string]string{}
+
+	for _, a := range accounts {
+		shadow[a.name] = base.locked
+	}
+
+	shadow["root"] = cryptPassword(rng, randomPassword(rng))
+
+	// a key login has no password to hash - an empty one would let anyone su to the account
+	password := "!" + cryptPassword(rng, randomPassword(rng))
+
+	if profile.Password != "" {
+		password = cryptPassword(rng, profile.Password)
+	}
+
+	if profile.User == "root" {
+		shadow["root"] = password
+	} else {
+		accounts = append(accounts, account{profile.User, profileUID, profileUID, profile.User, "/home/" + profile.User, base.shell})
+		groups = append(groups, group{profile.User, profileUID, nil})
+		shadow[profile.User] = password
+
+		for idx := range groups {
+			if groups[idx].name == base.admin || slices.Contains(base.userGroups, groups[idx].name) {
+				groups[idx].members = append(groups[idx].members, profile.User)
+			}
+		}
+	}
+
+	uid := profileUID + 1
+
+	for _, idx := range rng.Perm(len(extraUsers))[:2] {
+		extra := extraUsers[idx]
+
+		if slices.ContainsFunc(accounts, func(a account) bool { return a.name == extra.name }) {
+			continue
+		}
+
+		accounts = append(accounts, account{extra.name, uid, uid, extra.gecos, "/home/" + extra.name, base.shell})
+		groups = append(groups, group{extra.name, uid, nil})
+		shadow[extra.name] = cryptPassword(rng, randomPassword(rng))
+
+		uid++
+	}
+
+	for idx := range accounts {
+		if accounts[idx].name == "root" {
+			accounts[idx].shell = base.rootShell
+		}
+	}
+
+	var passwdBody, groupBody, shadowBody strings.Builder
+
+	// system accounts date from the install, passwords were set some time after
+	installed := profileEpoch + rng.IntN(400)
+	lastModified := installed
+
+	for _, a := range accounts {
+		fmt.Fprintf(&passwdBody, "%s:x:%d:%d:%s:%s:%s\n", a.name, a.uid, a.gid, a.gecos, a.home, a.shell)
+
+		lastChange := ""
+
+		switch {
+		case shadow[a.name] != base.locked:
+			changed := installed + rng.IntN(300)
+			lastModified = max(lastModified, changed)
+			lastChange = fmt.Sprint(changed)
+		case profile.Distro != DistroAlpine:
+			lastChange = fmt.Sprint(installed)
+		}
+
+		fmt.Fprintf(&shadowBody, "%s:%s:%s:%s\n", a.name, shadow[a.name], lastChange, base.aging)
+	}
+
+	for _, g := range groups {
+		fmt.Fprintf(&groupBody, "%s:x:%d:%s\n", g.name, g.gid, strings.Join(g.members, ","))
+	}
+
+	modTime := time.Unix(int64(lastModified)*24*60*60, 0)
+
+	return []profileFile{
+		{"group", groupBody.String(), 0644, 0},
+		{"passwd", passwdBody.String(), 0644, 0},
+		{"shadow", shadowBody.String(), base.shadowMode, base.shadowGID},
+	}, modTime, nil
+}
+
+// randomPassword is a password for an account the attacker doesn't know the password of
+func randomPassword(rng *rand.Rand) string {
+	const chars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+	b := make([]byte, 16)
+	for idx := range b {
+		b[idx] = chars[rng.IntN(len(chars))]
+	}
+
+	return string(b)
+}
+
+// cryptPassword is the sha512-crypt hash of password as found in /etc/shadow
+func cryptPassword(rng *rand.Rand, password string) string {
+	const saltChars = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	salt := make([]byte, 16)
+	for idx := range salt {
+		salt[idx] = saltChars[rng.IntN(len(saltChars))]
+	}
+
+	hash, err := sha512_crypt.New().Generate([]byte(password), []byte(sha512_crypt.MagicPrefix+string(salt)))
+	if err != nil {
+		return "!"
 	}
 
-	return io.ReadAll(&buf)
+	return hash
 }
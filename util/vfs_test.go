@@ -4,11 +4,18 @@ import (
 	"archive/tar"
 	"bytes"
 	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+
+	"github.com/GehirnInc/crypt/sha512_crypt"
+	"github.com/docker/docker/api/types/container"
 )
 
 func TestGetProfileBuffer(t *testing.T) {
-	data, err := GetProfileBuffer("tester")
+	data, err := GetProfileBuffer(Profile{Distro: DistroAlpine, User: "tester", Password: "hunter2"})
 
 	if err != nil {
 		t.Fatal(err)
@@ -63,3 +70,197 @@ func TestGetProfileBuffer(t *testing.T) {
 		t.Fatal("Failed to locate passwd file in tar for profile")
 	}
 }
+
+// profileFiles unpacks a profile tar into its file bodies
+func profileFiles(t *testing.T, profile Profile) map[string]string {
+	data, err := GetProfileBuffer(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{}
+
+	tr := tar.NewReader(bytes.NewReader(data))
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		files[header.Name] = string(body)
+	}
+}
+
+// entry is the line of an account file for name split on its colons
+func entry(body string, name string) []string {
+	for line := range strings.SplitSeq(body, "\n") {
+		if fields := strings.Split(line, ":"); fields[0] == name {
+			return fields
+		}
+	}
+
+	return nil
+}
+
+func TestGetProfileShadow(t *testing.T) {
+	for distro, admin := range map[Distro]string{DistroAlpine: "wheel", DistroDebian: "sudo", DistroCentOS: "wheel"} {
+		files := profileFiles(t, Profile{Distro: distro, User: "tester", Password: "hunter2"})
+
+		passwd := entry(files["passwd"], "tester")
+		if len(passwd) != 7 || passwd[2] != "1000" || passwd[5] != "/home/tester" {
+			t.Fatalf("%s: unexpected passwd entry %v", distro, passwd)
+		}
+
+		shadow := entry(files["shadow"], "tester")
+		if len(shadow) != 9 || !strings.HasPrefix(shadow[1], "$6$") {
+			t.Fatalf("%s: unexpected shadow entry %v", distro, shadow)
+		}
+
+		if err := sha512_crypt.New().Verify(shadow[1], []byte("hunter2")); err != nil {
+			t.Fatalf("%s: shadow doesn't hold the password: %v", distro, err)
+		}
+
+		if group := entry(files["group"], admin); group == nil || !slices.Contains(strings.Split(group[3], ","), "tester") {
+			t.Fatalf("%s: tester isn't in %s: %v", distro, admin, group)
+		}
+
+		// the system accounts and at least one other user
+		if accounts := strings.Count(files["passwd"], "\n"); accounts < 15 || !strings.Contains(files["passwd"], ":1001:1001:") {
+			t.Fatalf("%s: expected extra users in\n%s", distro, files["passwd"])
+		}
+	}
+}
+
+func TestGetProfileStable(t *testing.T) {
+	first := profileFiles(t, Profile{Distro: DistroDebian, User: "root", Password: "toor"})
+	second := profileFiles(t, Profile{Distro: DistroDebian, User: "root", Password: "toor"})
+
+	if first["shadow"] != second["shadow"] || first["passwd"] != second["passwd"] {
+		t.Fatal("expected the same profile for the same user and password")
+	}
+
+	if root := entry(first["shadow"], "root"); sha512_crypt.New().Verify(root[1], []byte("toor")) != nil {
+		t.Fatalf("root's shadow doesn't hold the password: %v", root)
+	}
+
+	if root := entry(first["passwd"], "root"); root[6] != "/bin/bash" {
+		t.Fatalf("unexpected root shell %v", root)
+	}
+}
+
+func TestGetProfileSecretKeyed(t *testing.T) {
+	first := profileFiles(t, Profile{Distro: DistroDebian, User: "root", Password: "toor", Secret: []byte("first sensor")})
+	second := profileFiles(t, Profile{Distro: DistroDebian, User: "root", Password: "toor", Secret: []byte("second sensor")})
+
+	if entry(first["shadow"], "root")[1] == entry(second["shadow"], "root")[1] {
+		t.Fatal("expected sensors with different secrets to salt the password differently")
+	}
+}
+
+func TestGetProfileKeyLogin(t *testing.T) {
+	for _, user := range []string{"root", "tester"} {
+		files := profileFiles(t, Profile{Distro: DistroDebian, User: user})
+
+		shadow := entry(files["shadow"], user)
+		if !strings.HasPrefix(shadow[1], "!$6$") {
+			t.Fatalf("%s: expected a locked password got %v", user, shadow)
+		}
+
+		if err := sha512_crypt.New().Verify(strings.TrimPrefix(shadow[1], "!"), []byte("")); err == nil {
+			t.Fatalf("%s: the empty password unlocks the account", user)
+		}
+	}
+}
+
+func TestParseOSRelease(t *testing.T) {
+	for osRelease, expected := range map[string]Distro{
+		"NAME=\"Alpine Linux\"\nID=alpine\nVERSION_ID=3.20.0\n":              DistroAlpine,
+		"PRETTY_NAME=\"Ubuntu 24.04 LTS\"\nID=ubuntu\nID_LIKE=debian\n":      DistroDebian,
+		"NAME=\"Rocky Linux\"\nID=\"rocky\"\nID_LIKE=\"rhel centos fedora\"": DistroCentOS,
+		"": DistroAlpine,
+	} {
+		if distro := ParseOSRelease(osRelease); distro != expected {
+			t.Fatalf("expected %s got %s for %q", expected, distro, osRelease)
+		}
+	}
+}
+
+func TestWriteProfile(t *testing.T) {
+	profile := Profile{Distro: DistroDebian, User: "tester", Password: "hunter2"}
+	dir := t.TempDir()
+
+	if err := WriteProfile(dir, profile); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, body := range profileFiles(t, profile) {
+		written, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(written) != body {
+			t.Fatalf("expected %s to match the profile tar got\n%s", name, written)
+		}
+	}
+}
+
+func TestMountProfile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "profile")
+
+	createCfg := &container.Config{User: "tester"}
+	hostCfg := &container.HostConfig{}
+
+	if err := mountProfile(dir, "tester", createCfg, hostCfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hostCfg.Mounts) != 0 || createCfg.User != "tester" {
+		t.Fatal("expected a writable root filesystem to have the profile copied in")
+	}
+
+	hostCfg.ReadonlyRootfs = true
+
+	if err := mountProfile(dir, "tester", createCfg, hostCfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// the name isn't in the image so docker must be handed the uid
+	if createCfg.User != "1000:1000" {
+		t.Fatalf("expected the container to run as 1000:1000 got %s", createCfg.User)
+	}
+
+	targets := map[string]bool{}
+
+	for _, m := range hostCfg.Mounts {
+		if !m.ReadOnly || m.Source != filepath.Join(dir, filepath.Base(m.Target)) {
+			t.Fatalf("unexpected mount %+v", m)
+		}
+
+		if _, err := os.Stat(m.Source); err != nil {
+			t.Fatalf("expected the mount source to exist: %v", err)
+		}
+
+		targets[m.Target] = true
+	}
+
+	for _, target := range []string{"/etc/passwd", "/etc/group", "/etc/shadow"} {
+		if !targets[target] {
+			t.Fatalf("expected %s to be mounted got %v", target, hostCfg.Mounts)
+		}
+	}
+
+	if user := ProfileUser("root"); user != "0:0" {
+		t.Fatalf("expected root to run as 0:0 got %s", user)
+	}
+}
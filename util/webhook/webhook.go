@@ -0,0 +1,348 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
+
+	"github.com/archimoebius/fishler/util"
+	"github.com/archimoebius/fishler/util/event"
+)
+
+const (
+	queueSize      = 256
+	requestTimeout = 10 * time.Second
+)
+
+// Target is a webhook endpoint as read from the webhook file
+type Target struct {
+	URL       string            `mapstructure:"url"`
+	Method    string            `mapstructure:"method"`
+	Headers   map[string]string `mapstructure:"headers"`
+	Template  string            `mapstructure:"template"`
+	Filters   []Filter          `mapstructure:"filters"`
+	RateLimit int               `mapstructure:"rate-limit"` // requests per minute - 0 is unlimited
+	Retries   int               `mapstructure:"retries"`
+}
+
+// Filter selects events of Kind ("*" for any) whose fields match every regular expression in Fields
+type Filter struct {
+	Kind   string            `mapstructure:"kind"`
+	Fields map[string]string `mapstructure:"fields"`
+}
+
+// Payload is the data handed to a target's template
+type Payload struct {
+	Kind          event.Kind        `json:"kind"`
+	Timestamp     time.Time         `json:"timestamp"`
+	SessionID     string            `json:"session_id"`
+	Address       string            `json:"address"`
+	Username      string            `json:"username"`
+	Password      string            `json:"password"`
+	AuthMethod    string            `json:"auth_method"`
+	ClientVersion string            `json:"client_version"`
+	HASSH         string            `json:"hassh"`
+	Fields        map[string]string `json:"fields"`
+}
+
+type filter struct {
+	kind   event.Kind
+	fields map[string]*regexp.Regexp
+}
+
+type target struct {
+	Target
+	filters  []filter
+	template *template.Template
+	limiter  *rate.Limiter
+	events   chan Payload
+}
+
+// Dispatcher delivers matching events to every configured target
+type Dispatcher struct {
+	targets []*target
+	client  *http.Client
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+}
+
+var templateFuncs = template.FuncMap{
+	// json renders v as a JSON value - use it to safely embed strings in a JSON template
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// Load reads the webhook targets from the yaml file at path and starts delivering to them
+//
+//	webhooks:
+//	  - url: https://example.com/hook
+//	    template: '{"text": {{json (printf "%s logged in from %s" .Username .Address)}}}'
+//	    rate-limit: 30
+//	    retries: 3
+//	    filters:
+//	      - kind: auth
+//	        fields: {success: "^true$"}
+//	      - kind: exec
+//	        fields: {command: '(wget|curl).*\|\s*(ba)?sh'}
+func Load(path string) (*Dispatcher, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	var targets []Target
+	if err := v.UnmarshalKey("webhooks", &targets); err != nil {
+		return nil, err
+	}
+
+	return NewDispatcher(targets)
+}
+
+// NewDispatcher validates the targets and starts a delivery worker for each
+func NewDispatcher(targets []Target) (*Dispatcher, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	d := &Dispatcher{
+		client: &http.Client{Timeout: requestTimeout},
+		cancel: cancel,
+	}
+
+	for idx, cfg := range targets {
+		t, err := newTarget(cfg)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("webhook %d (%s): %w", idx, cfg.URL, err)
+		}
+
+		d.targets = append(d.targets, t)
+	}
+
+	for _, t := range d.targets {
+		d.workers.Go(func() {
+			d.deliver(ctx, t)
+		})
+	}
+
+	return d, nil
+}
+
+func newTarget(cfg Target) (*target, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("missing url")
+	}
+
+	if cfg.Method == "" {
+		cfg.Method = http.MethodPost
+	}
+
+	t := &target{
+		Target:  cfg,
+		limiter: rate.NewLimiter(rate.Inf, 0),
+		events:  make(chan Payload, queueSize),
+	}
+
+	if cfg.RateLimit > 0 {
+		t.limiter = rate.NewLimiter(rate.Every(time.Minute/time.Duration(cfg.RateLimit)), cfg.RateLimit)
+	}
+
+	if cfg.Template != "" {
+		tmpl, err := template.New(cfg.URL).Funcs(templateFuncs).Parse(cfg.Template)
+		if err != nil {
+			return nil, err
+		}
+
+		t.template = tmpl
+	}
+
+	for _, f := range cfg.Filters {
+		compiled := filter{
+			kind:   event.Kind(f.Kind),
+			fields: make(map[string]*regexp.Regexp),
+		}
+
+		for field, expr := range f.Fields {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("filter %s field %s: %w", f.Kind, field, err)
+			}
+
+			compiled.fields[field] = re
+		}
+
+		t.filters = append(t.filters, compiled)
+	}
+
+	return t, nil
+}
+
+// matches reports whether any filter selects the payload - a target without filters receives everything
+func (t *target) matches(p Payload) bool {
+	if len(t.filters) == 0 {
+		return true
+	}
+
+	for _, f := range t.filters {
+		if f.kind != "*" && f.kind != p.Kind {
+			continue
+		}
+
+		matched := true
+		for field, re := range f.fields {
+			if !re.MatchString(p.Fields[field]) {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// render produces the request body - the payload as JSON unless a template is configured
+func (t *target) render(p Payload) ([]byte, error) {
+	if t.template == nil {
+		return json.Marshal(p)
+	}
+
+	var buf bytes.Buffer
+	if err := t.template.Execute(&buf, p); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Dispatch queues the event for every target whose filters match - it never blocks
+func (d *Dispatcher) Dispatch(e *event.Event) {
+	p := Payload{
+		Kind:          e.Kind,
+		Timestamp:     e.Timestamp,
+		SessionID:     e.SessionID,
+		Username:      e.Username,
+		Password:      e.Password,
+		AuthMethod:    e.AuthMethod,
+		ClientVersion: e.ClientVersion,
+		HASSH:         e.HASSH,
+		Fields:        e.Fields,
+	}
+
+	if e.RemoteAddr != nil {
+		p.Address = e.RemoteAddr.String()
+	}
+
+	for _, t := range d.targets {
+		if !t.matches(p) {
+			continue
+		}
+
+		if !t.limiter.Allow() {
+			util.Logger.WithFields(logrus.Fields{
+				"url":  t.URL,
+				"kind": p.Kind,
+			}).Warn("webhook rate limit exceeded - dropping event")
+			continue
+		}
+
+		select {
+		case t.events <- p:
+		default:
+			util.Logger.WithFields(logrus.Fields{
+				"url":  t.URL,
+				"kind": p.Kind,
+			}).Warn("webhook queue full - dropping event")
+		}
+	}
+}
+
+// Close stops delivery - events still queued are dropped
+func (d *Dispatcher) Close() {
+	d.cancel()
+	d.workers.Wait()
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, t *target) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case p := <-t.events:
+			body, err := t.render(p)
+			if err != nil {
+				util.Logger.WithFields(logrus.Fields{
+					"url":   t.URL,
+					"error": err,
+				}).Error("failed to render webhook template")
+				continue
+			}
+
+			backoff := time.Second
+
+			for attempt := 0; ; attempt++ {
+				err = d.send(ctx, t, body)
+				if err == nil || attempt >= t.Retries {
+					break
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+
+				backoff *= 2
+			}
+
+			if err != nil {
+				util.Logger.WithFields(logrus.Fields{
+					"url":   t.URL,
+					"kind":  p.Kind,
+					"error": err,
+				}).Error("webhook delivery failed")
+			}
+		}
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, t *target, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, t.Method, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "fishler")
+
+	for key, value := range t.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := d.client.Do(req) // #nosec
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
